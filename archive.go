@@ -0,0 +1,345 @@
+package mediashrink
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/haxii/filetype/matchers"
+)
+
+// archiveEntry an entry listed in an archive
+type archiveEntry struct {
+	Path  string
+	IsDir bool
+}
+
+// ShrinkArchive shrinks every supported media inside the zip / 7z / rar archive at archivePath
+// and repacks it into outputPath with the same entry names & order, non-media entries are kept untouched.
+// the format of the new archive is decided by the ext of outputPath, which can be either zip or 7z,
+// zips are written natively, 7z archives by 7z,
+// archivePath and outputPath can be the same file.
+func ShrinkArchive(archivePath, outputPath string, guessMissingExt bool) error {
+	return defaultShrinker().ShrinkArchiveContext(context.Background(), archivePath, outputPath, guessMissingExt)
+}
+
+// ShrinkArchiveContext is like ShrinkArchive but kills the external commands when ctx is done.
+func ShrinkArchiveContext(ctx context.Context, archivePath, outputPath string, guessMissingExt bool) error {
+	return defaultShrinker().ShrinkArchiveContext(ctx, archivePath, outputPath, guessMissingExt)
+}
+
+// ShrinkArchive shrinks every supported media inside the archive at archivePath into outputPath using s,
+// see ShrinkArchive.
+func (s *Shrinker) ShrinkArchive(archivePath, outputPath string, guessMissingExt bool) error {
+	return s.ShrinkArchiveContext(context.Background(), archivePath, outputPath, guessMissingExt)
+}
+
+// ShrinkArchiveContext is like ShrinkArchive but kills the external commands when ctx is done.
+func (s *Shrinker) ShrinkArchiveContext(ctx context.Context, archivePath, outputPath string, guessMissingExt bool) error {
+	outputExt := strings.ToLower(filepath.Ext(outputPath))
+	if len(outputExt) > 1 {
+		outputExt = outputExt[1:]
+	}
+	if !isArchive(outputExt) {
+		return fmt.Errorf("%w: %s", ErrUnknownMediaType, outputPath)
+	} else if !isWritableArchive(outputExt) {
+		return fmt.Errorf("%w: unable to create %s archive %s", ErrUnsupportedFormat, outputExt, outputPath)
+	}
+
+	entries, err := s.listArchive(ctx, archivePath)
+	if err != nil {
+		return err
+	}
+
+	workDir, err := ioutil.TempDir(s.tempDir, "mediashrink")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+	extractDir := filepath.Join(workDir, "entries")
+	if err := s.extractArchive(ctx, archivePath, extractDir); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if entry.IsDir {
+			continue
+		}
+		entryPath := filepath.Join(extractDir, filepath.FromSlash(entry.Path))
+		if regular, err := isExtractedFile(extractDir, entryPath); err != nil {
+			return fmt.Errorf("failed stat %s in %s with err %w", entry.Path, archivePath, err)
+		} else if !regular {
+			// symlinks & special files restored by 7z are kept as is, never followed
+			continue
+		}
+		info, err := s.getMediaInfo(ctx, "", guessMissingExt, entryPath, true)
+		if errors.Is(err, ErrUnknownMediaType) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed get media info of %s in %s with err %w", entry.Path, archivePath, err)
+		}
+		if err := s.ShrinkV2Context(ctx, info, entryPath); err != nil {
+			return fmt.Errorf("failed shrink %s in %s with err %w", entry.Path, archivePath, err)
+		}
+	}
+
+	// repack into a tmp archive first, so the original one can be overwritten
+	tmpArchive := filepath.Join(workDir, "shrink."+outputExt)
+	if err := s.packArchive(ctx, tmpArchive, outputExt, extractDir, entries); err != nil {
+		return err
+	}
+	return moveFile(tmpArchive, outputPath)
+}
+
+// listArchive list entries of the archive in its original order
+func (s *Shrinker) listArchive(ctx context.Context, archivePath string) ([]archiveEntry, error) {
+	// 7z l -slt archive.zip
+	output, err := s.command(ctx, s.commands.P7Zip.P7z, "l", "-slt", "-sccUTF-8", archivePath).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed list %s: %w", archivePath, err)
+	}
+	return parseArchiveListing(output)
+}
+
+// parseArchiveListing parse the technical listing of `7z l -slt`,
+// entries are blocks of `Key = Value` lines following a `----------` line,
+// listings with an entry out of the archive root like ../evil are rejected
+func parseArchiveListing(listing []byte) ([]archiveEntry, error) {
+	var entries []archiveEntry
+	var entry *archiveEntry
+	started := false
+	scanner := bufio.NewScanner(bytes.NewReader(listing))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if !started {
+			started = line == "----------"
+			continue
+		}
+		if len(line) == 0 {
+			entry = nil
+			continue
+		}
+		keyValue := strings.SplitN(line, " = ", 2)
+		if len(keyValue) != 2 {
+			continue
+		}
+		switch key, value := keyValue[0], keyValue[1]; key {
+		case "Path":
+			entryPath, err := cleanArchivePath(value)
+			if err != nil {
+				return nil, &ParseError{Tool: "7z", Output: listing, Err: err}
+			}
+			entries = append(entries, archiveEntry{Path: entryPath})
+			entry = &entries[len(entries)-1]
+		case "Folder":
+			if entry != nil && value == "+" {
+				entry.IsDir = true
+			}
+		case "Attributes":
+			if entry != nil && strings.HasPrefix(value, "D") {
+				entry.IsDir = true
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !started {
+		return nil, &ParseError{Tool: "7z", Output: listing, Err: errors.New("no entries listed")}
+	}
+	return entries, nil
+}
+
+// cleanArchivePath clean the listed path of an entry into a slash separated one relative to the archive root,
+// which fails if the path is absolute or leaves the root
+func cleanArchivePath(name string) (string, error) {
+	cleaned := path.Clean(filepath.ToSlash(name))
+	if !filepath.IsLocal(filepath.FromSlash(cleaned)) {
+		return "", fmt.Errorf("entry %q out of the archive", name)
+	}
+	return cleaned, nil
+}
+
+// extractArchive extract all entries with full paths into dir
+func (s *Shrinker) extractArchive(ctx context.Context, archivePath, dir string) error {
+	// 7z x -y -odir archive.zip
+	if _, err := s.command(ctx,
+		s.commands.P7Zip.P7z,
+		"x", "-y", "-bd", "-o"+dir, archivePath,
+	).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed extract %s: %w", archivePath, err)
+	}
+	return nil
+}
+
+// packArchive pack entries under dir into a new archive in the given order
+func (s *Shrinker) packArchive(ctx context.Context, archivePath, archiveType, dir string, entries []archiveEntry) error {
+	if archiveType == matchers.TypeZip.Extension {
+		// 7z sorts zip entries on its own, so zips are written here to keep the order
+		return packZip(ctx, archivePath, dir, entries)
+	}
+	// directories are added recursively by 7z, so only empty ones are listed explicitly,
+	// others are created implicitly by the files inside them
+	var list bytes.Buffer
+	for i, entry := range entries {
+		if entry.IsDir && !isEmptyArchiveDir(entries, i) {
+			continue
+		}
+		list.WriteString(entry.Path)
+		list.WriteByte('\n')
+	}
+	listFile := archivePath + ".list"
+	if err := ioutil.WriteFile(listFile, list.Bytes(), 0644); err != nil {
+		return err
+	}
+	defer os.Remove(listFile)
+
+	// 7z a -t7z -spd -snl -mqs=off -scsUTF-8 archive.7z @listfile
+	// -spd makes 7z take the listed names literally instead of as wildcards,
+	// -snl stores symlinks as links instead of following them,
+	// -mqs=off keeps the entry order instead of sorting by type
+	args := []string{"a", "-t" + archiveType, "-y", "-bd", "-spd", "-snl", "-mqs=off", "-scsUTF-8",
+		archivePath, "@" + listFile}
+	cmd := s.command(ctx, s.commands.P7Zip.P7z, args...)
+	cmd.Dir = dir
+	if _, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed pack %s: %w", archivePath, err)
+	}
+	return nil
+}
+
+// packZip write entries under dir into a new zip archive in the given order,
+// symlinks are stored as links the way zip & unzip do
+func packZip(ctx context.Context, archivePath, dir string, entries []archiveEntry) error {
+	f, err := os.Create(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := zip.NewWriter(f)
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := addZipEntry(w, dir, entry); err != nil {
+			return fmt.Errorf("failed pack %s into %s with err %w", entry.Path, archivePath, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// addZipEntry write a single entry under dir into w
+func addZipEntry(w *zip.Writer, dir string, entry archiveEntry) error {
+	entryPath := filepath.Join(dir, filepath.FromSlash(entry.Path))
+	fi, err := os.Lstat(entryPath)
+	if err != nil {
+		return err
+	}
+	header, err := zip.FileInfoHeader(fi)
+	if err != nil {
+		return err
+	}
+	header.Name = strings.TrimSuffix(entry.Path, "/")
+	if fi.IsDir() {
+		header.Name += "/"
+	} else {
+		header.Method = zip.Deflate
+	}
+	writer, err := w.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(entryPath)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(writer, filepath.ToSlash(target))
+		return err
+	case fi.Mode().IsRegular():
+		in, err := os.Open(entryPath)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		_, err = io.Copy(writer, in)
+		return err
+	}
+	return nil
+}
+
+// isExtractedFile check if entryPath is a regular file inside extractDir,
+// neither itself nor any of its parent directories may be a symlink
+func isExtractedFile(extractDir, entryPath string) (bool, error) {
+	if !strings.HasPrefix(entryPath, extractDir+string(filepath.Separator)) {
+		return false, nil
+	}
+	fi, err := os.Lstat(entryPath)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if !fi.Mode().IsRegular() {
+		return false, nil
+	}
+	for dir := filepath.Dir(entryPath); len(dir) > len(extractDir); dir = filepath.Dir(dir) {
+		if fi, err := os.Lstat(dir); err != nil {
+			return false, err
+		} else if !fi.IsDir() {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// isEmptyArchiveDir check if no other entry lives in the directory entries[i]
+func isEmptyArchiveDir(entries []archiveEntry, i int) bool {
+	prefix := strings.TrimSuffix(entries[i].Path, "/") + "/"
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Path, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// moveFile rename src to dst, copy it when rename is not possible across devices
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to move %s to %s with err %s", src, dst, err)
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
@@ -0,0 +1,71 @@
+package mediashrink
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseArchiveListing(t *testing.T) {
+	const header = "7-Zip [64] 16.02 : Copyright (c) 1999-2016 Igor Pavlov : 2016-05-21\n\n" +
+		"Listing archive: fixtures.zip\n\n--\nPath = fixtures.zip\nType = zip\nPhysical Size = 1234\n\n----------\n"
+	tests := []struct {
+		name    string
+		listing string
+		want    []archiveEntry
+	}{
+		{"empty archive", header, nil},
+		{
+			"files & folders in order",
+			header + "Path = b.jpg\nFolder = -\nSize = 10\n\n" +
+				"Path = photos\nFolder = +\nSize = 0\n\n" +
+				"Path = photos/a = b.png\nFolder = -\nSize = 20\n\n" +
+				"Path = empty\nFolder = +\nSize = 0\n\n",
+			[]archiveEntry{{Path: "b.jpg"}, {Path: "photos", IsDir: true}, {Path: "photos/a = b.png"},
+				{Path: "empty", IsDir: true}},
+		},
+		{
+			// 7z archives mark folders by attributes only, with CRLF line endings on Windows
+			"folders by attributes",
+			header + "Path = docs\r\nAttributes = D_ drwxr-xr-x\r\n\r\nPath = docs/readme.txt\r\nAttributes = A_\r\n\r\n",
+			[]archiveEntry{{Path: "docs", IsDir: true}, {Path: "docs/readme.txt"}},
+		},
+		{
+			"paths cleaned",
+			header + "Path = ./a//b/../c.mp3\nFolder = -\n\nPath = dir/\nFolder = +\n\n",
+			[]archiveEntry{{Path: "a/c.mp3"}, {Path: "dir", IsDir: true}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entries, err := parseArchiveListing([]byte(test.listing))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(entries, test.want) {
+				t.Errorf("got %+v, want %+v", entries, test.want)
+			}
+		})
+	}
+}
+
+func TestParseArchiveListingInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		listing string
+	}{
+		{"no entries listed", "7-Zip [64] 16.02\n\nERROR: fixtures.zip\nCan not open the file as archive\n"},
+		{"parent dir", "----------\nPath = ../evil.jpg\nFolder = -\n\n"},
+		{"parent dir inside", "----------\nPath = a/../../evil.jpg\nFolder = -\n\n"},
+		{"absolute", "----------\nPath = ok.jpg\n\nPath = /etc/passwd\nFolder = -\n\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entries, err := parseArchiveListing([]byte(test.listing))
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Errorf("got %+v with error %v, want a *ParseError", entries, err)
+			}
+		})
+	}
+}
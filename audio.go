@@ -1,64 +1,166 @@
 package mediashrink
 
 import (
+	"context"
 	"fmt"
-	"os/exec"
+	"math"
 	"strconv"
+	"strings"
+	"time"
 )
 
-// getAudioInfo get audio and video duration in secs with video dimension as well
-func getAudioInfo(audioPath string) (*MediaInfo, error) {
-	d, err := getDuration(audioPath)
+// getAudioInfo get audio duration, the audio backends are tried in order,
+// the file is parsed natively when possible, the audio backend is used for the unusual ones
+func (s *Shrinker) getAudioInfo(ctx context.Context, src Source) (*MediaInfoV2, error) {
+	var info *MediaInfoV2
+	name, err := tryBackends(ctx, s, "probe "+src.Name(), s.audioChain, nil, func(backend AudioBackend) (err error) {
+		info, err = backend.ProbeAudio(ctx, s, src)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
-	return &MediaInfo{0, 0, d, "", ""}, nil
+	info.Backend = name
+	return info, nil
 }
 
-// makeNullAudio make a null audio using aInfo, returns nil if success
-func (aInfo *MediaInfo) makeNullAudio(outputPath string) error {
+// ProbeAudio implements AudioBackend, get audio duration & format using ffprobe
+func (b FFmpegBackend) ProbeAudio(ctx context.Context, s *Shrinker, src Source) (*MediaInfoV2, error) {
+	audioPath, release, err := src.file(s.tempDir)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	probed, err := b.probe(ctx, s, audioPath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := probed.durationInfo()
+	if err != nil {
+		return nil, err
+	}
+	probed.setAudioStream(info)
+	return info, nil
+}
+
+// makeNullAudio make a null audio using aInfo with the first audio backend which can make it, returns nil if success
+func (s *Shrinker) makeNullAudio(ctx context.Context, aInfo *MediaInfoV2, sink Sink) error {
+	_, err := tryBackends(ctx, s, "make "+aInfo.ToString(), s.audioChain, &sink, func(backend AudioBackend) error {
+		return backend.MakeNullAudio(ctx, s, aInfo, sink)
+	})
+	return err
+}
+
+// MakeNullAudio implements AudioBackend, make a null audio using ffmpeg
+func (b FFmpegBackend) MakeNullAudio(ctx context.Context, s *Shrinker, aInfo *MediaInfoV2, sink Sink) error {
 	// ffmpeg -f lavfi -i anullsrc=sample_rate=11025 -t 10.231  -metadata title="signature" silence.mp4
-	// ffmpeg DTS delay time -11ms
-	dtsDelay := float32(0.011)
-	audioDuration := fmt.Sprintf("%.3f", float32(aInfo.Duration)/1000-dtsDelay)
-
-	if info, err := exec.Command(
-		commands.FFMPEG.FFMpeg,
-		"-loglevel", "fatal",
-		"-y", "-f", "lavfi", "-i", "anullsrc=sample_rate=128000",
-		"-t", audioDuration,
-		"-metadata", "title=\""+aInfo.Signature+"\"",
-		outputPath,
-	).CombinedOutput(); err != nil {
-		return fmt.Errorf("exec ffmpeg %s with err: %s, info: %s", outputPath, err, info)
-	}
-	return nil
+	// the duration is corrected by the calibration of the container, see calibrate
+	// the codec, channel layout, sample rate & bit depth are kept when known, see nullAudioSource
+	codecArgs := b.audioCodecArgs(ctx, s, aInfo)
+
+	outputPath, done, err := sink.file(s.tempDir, aInfo.Ext)
+	if err != nil {
+		return err
+	}
+	return done(b.calibrate(ctx, s, "audio", outputPath, aInfo.Duration, func(correction time.Duration) error {
+		args := []string{
+			"-loglevel", "fatal",
+			"-y", "-f", "lavfi", "-i", nullAudioSource(aInfo, "128000"),
+			"-t", durationArg(aInfo.Duration, correction),
+			"-metadata", "title=\"" + aInfo.Signature + "\"",
+		}
+		args = append(append(args, codecArgs...), outputPath)
+		if _, err := s.command(ctx, b.ffmpeg(), args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed make %s: %w", outputPath, err)
+		}
+		return nil
+	}))
 }
 
-func getDuration(filePath string) (uint32, error) {
+// maxAudioChannels channels more than it are ignored, which is the most of the ffmpeg encoders
+const maxAudioChannels = 64
+
+// nullAudioSource the lavfi source of silence in the channel layout, sample rate & bit depth of info,
+// defaultSampleRate is used if the sample rate is unknown, the layout is in the channels of info if unnamed
+func nullAudioSource(info *MediaInfoV2, defaultSampleRate string) string {
+	source := "anullsrc=sample_rate=" + defaultSampleRate
+	if rate := math.Round(info.SampleRate.Float64()); rate > 0 && rate <= math.MaxInt32 {
+		source = "anullsrc=sample_rate=" + strconv.FormatFloat(rate, 'f', 0, 64)
+	}
+	// the layout is not escaped in the filter graph, names with other characters are not accepted
+	if isChannelLayoutName(info.ChannelLayout) {
+		source += ":channel_layout=" + info.ChannelLayout
+	} else if info.Channels > 0 && info.Channels <= maxAudioChannels {
+		source += ":channel_layout=" + strconv.Itoa(info.Channels) + "c"
+	}
+	// ffmpeg converts the sample format into the closest one of the encoder
+	if sampleFormat := audioSampleFormat(info.BitDepth); len(sampleFormat) > 0 {
+		source += ",aformat=sample_fmts=" + sampleFormat
+	}
+	return source
+}
+
+// isChannelLayoutName check if layout is a channel layout name of ffmpeg like 5.1(side) & FL+FR+LFE
+func isChannelLayoutName(layout string) bool {
+	for _, c := range layout {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune(".()+-_", c)) {
+			return false
+		}
+	}
+	return len(layout) > 0
+}
+
+// audioSampleFormat the packed sample format of ffmpeg holding samples in bitDepth, empty if unknown,
+// 24-bit samples are held in s32, which flac & alac encode in 24-bit
+func audioSampleFormat(bitDepth int) string {
+	switch {
+	case bitDepth <= 0:
+		return ""
+	case bitDepth <= 8:
+		return "u8"
+	case bitDepth <= 16:
+		return "s16"
+	case bitDepth <= 32:
+		return "s32"
+	}
+	return ""
+}
+
+// audioCodecArgs ffmpeg output args to encode the audio streams in the codec of info,
+// the experimental encoders like the native opus & truehd ones are allowed
+func (b FFmpegBackend) audioCodecArgs(ctx context.Context, s *Shrinker, info *MediaInfoV2) []string {
+	args := b.encoderArgs(ctx, s, info, "a", info.AudioCodec)
+	if len(args) > 0 {
+		args = append(args, "-strict", "experimental")
+	}
+	return args
+}
+
+// getDuration get duration of filePath in full precision using ffprobe
+func (b FFmpegBackend) getDuration(ctx context.Context, s *Shrinker, filePath string) (time.Duration, error) {
 	// ffprobe -v quiet -show_entries format=duration -of default=noprint_wrappers=1:nokey=1
 
 	// get raw duration output
-	durationOutput, err1 := exec.Command(
-		commands.FFMPEG.FFProbe,
+	durationOutput, err1 := s.command(ctx,
+		b.ffprobe(),
 		"-v", "quiet",
 		"-show_entries", "format=duration",
 		"-of", "default=noprint_wrappers=1:nokey=1",
 		filePath).CombinedOutput()
 	if err1 != nil {
-		return 0, fmt.Errorf("exec ffprobe %s with err: %s", filePath, err1)
+		return 0, fmt.Errorf("failed probe %s: %w", filePath, err1)
 	}
 
 	// convert raw duration into numeric
 	duration, err2 := getDurationFromBytes(durationOutput)
 	if err2 != nil {
-		return 0, fmt.Errorf("failed get media duration from %s with err %s", durationOutput, err2)
+		return 0, &ParseError{Tool: "ffprobe", Output: durationOutput, Err: err2}
 	}
 
 	return duration, nil
 }
 
-func getDurationFromBytes(durationOutput []byte) (uint32, error) {
+func getDurationFromBytes(durationOutput []byte) (time.Duration, error) {
 	// parse duration
 	durationIndex := 0
 	for index, b := range durationOutput {
@@ -71,9 +173,14 @@ func getDurationFromBytes(durationOutput []byte) (uint32, error) {
 		return 0, fmt.Errorf("error occurred when convert %s to duration", durationOutput)
 	}
 	durationStr := string(durationOutput[0:durationIndex])
-	i, err := strconv.ParseFloat(durationStr, 32)
+	i, err := strconv.ParseFloat(durationStr, 64)
 	if err == nil {
-		return uint32(i * 1000), nil
+		return secondsToDuration(i), nil
 	}
 	return 0, fmt.Errorf("error occurred when convert %s to int: %s", durationStr, err)
 }
+
+// secondsToDuration convert secs printed by ffprobe into a duration rounded to ns
+func secondsToDuration(secs float64) time.Duration {
+	return time.Duration(math.Round(secs * float64(time.Second)))
+}
@@ -0,0 +1,122 @@
+package mediashrink
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNullAudioSource(t *testing.T) {
+	tests := []struct {
+		name string
+		info *MediaInfoV2
+		want string
+	}{
+		{"unknown", &MediaInfoV2{}, "anullsrc=sample_rate=128000"},
+		{
+			"stereo in 16-bit",
+			&MediaInfoV2{SampleRate: Rational{Num: 44100, Den: 1}, Channels: 2, ChannelLayout: "stereo", BitDepth: 16},
+			"anullsrc=sample_rate=44100:channel_layout=stereo,aformat=sample_fmts=s16",
+		},
+		{
+			"5.1(side) in 24-bit",
+			&MediaInfoV2{SampleRate: Rational{Num: 48000, Den: 1}, Channels: 6, ChannelLayout: "5.1(side)", BitDepth: 24},
+			"anullsrc=sample_rate=48000:channel_layout=5.1(side),aformat=sample_fmts=s32",
+		},
+		{
+			"channels without layout",
+			&MediaInfoV2{SampleRate: Rational{Num: 22050, Den: 1}, Channels: 3},
+			"anullsrc=sample_rate=22050:channel_layout=3c",
+		},
+		{
+			"custom layout",
+			&MediaInfoV2{Channels: 3, ChannelLayout: "FL+FR+LFE", BitDepth: 8},
+			"anullsrc=sample_rate=128000:channel_layout=FL+FR+LFE,aformat=sample_fmts=u8",
+		},
+		{
+			"layout not escaped",
+			&MediaInfoV2{Channels: 2, ChannelLayout: "stereo:x=1"},
+			"anullsrc=sample_rate=128000:channel_layout=2c",
+		},
+		{
+			"too many channels",
+			&MediaInfoV2{SampleRate: Rational{Num: 96000, Den: 1}, Channels: 65},
+			"anullsrc=sample_rate=96000",
+		},
+		{
+			"fractional sample rate",
+			&MediaInfoV2{SampleRate: Rational{Num: 88201, Den: 2}},
+			"anullsrc=sample_rate=44101",
+		},
+		{
+			"sample rate too high",
+			&MediaInfoV2{SampleRate: Rational{Num: 1 << 40, Den: 1}},
+			"anullsrc=sample_rate=128000",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := nullAudioSource(test.info, "128000"); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestIsChannelLayoutName(t *testing.T) {
+	for layout, want := range map[string]bool{
+		"mono":               true,
+		"5.1(side)":          true,
+		"7.1(wide-side)":     true,
+		"FL+FR+LFE":          true,
+		"hexadecagonal":      true,
+		"":                   false,
+		"6 channels (FL+FR)": false,
+		"stereo,anullsrc":    false,
+		"a:b":                false,
+	} {
+		if got := isChannelLayoutName(layout); got != want {
+			t.Errorf("got %t of %q, want %t", got, layout, want)
+		}
+	}
+}
+
+func TestAudioSampleFormat(t *testing.T) {
+	for bitDepth, want := range map[int]string{-1: "", 0: "", 4: "u8", 8: "u8", 12: "s16", 16: "s16", 20: "s32",
+		24: "s32", 32: "s32", 64: ""} {
+		if got := audioSampleFormat(bitDepth); got != want {
+			t.Errorf("got %q of %d-bit, want %q", got, bitDepth, want)
+		}
+	}
+}
+
+func TestAudioCodecArgs(t *testing.T) {
+	// the encoders are cached by the ffmpeg command, which is never run
+	b := FFmpegBackend{FFMpeg: "ffmpeg-audio-codec"}
+	ffmpegEncoders.Lock()
+	ffmpegEncoders.codecs[b.ffmpeg()] = map[string]bool{"aac": true, "opus": true}
+	ffmpegEncoders.Unlock()
+	tests := []struct {
+		codec string
+		args  []string
+	}{
+		{"", nil},
+		{"aac", []string{"-c:a", "aac", "-strict", "experimental"}},
+		{"opus", []string{"-c:a", "opus", "-strict", "experimental"}},
+		{"truehd", nil},
+	}
+	for _, test := range tests {
+		var logs bytes.Buffer
+		s := NewShrinker(WithLogger(log.New(&logs, "", 0)))
+		info := &MediaInfoV2{Signature: "abcdef", Ext: "mka", AudioCodec: test.codec}
+		if args := b.audioCodecArgs(context.Background(), s, info); !reflect.DeepEqual(args, test.args) {
+			t.Errorf("got %q of %q, want %q", args, test.codec, test.args)
+		}
+		if noEncoder := strings.Contains(logs.String(), "no encoder of truehd"); noEncoder != (test.codec == "truehd") {
+			t.Errorf("got logs %q of %q", logs.String(), test.codec)
+		}
+	}
+}
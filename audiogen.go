@@ -0,0 +1,409 @@
+package mediashrink
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"time"
+)
+
+// audioGenerator write a silent audio of info into w natively without ffmpeg
+type audioGenerator func(w io.Writer, info *MediaInfoV2) error
+
+// pcmFormat the sample format of generated silence
+type pcmFormat struct {
+	SampleRate    uint32
+	Channels      uint16
+	BitsPerSample uint16
+}
+
+// nullAudioFormat sample format of silent audios of unknown formats, 8k is enough for silence
+// and makes any duration in ms an exact number of samples
+var nullAudioFormat = pcmFormat{SampleRate: 8000, Channels: 1, BitsPerSample: 16}
+
+// nullAudioFormatOf the sample format of the silence of info, unknown channels, sample rate & bits per sample
+// are the ones of nullAudioFormat
+func nullAudioFormatOf(info *MediaInfoV2) (pcmFormat, error) {
+	format := nullAudioFormat
+	if !info.SampleRate.IsZero() {
+		if info.SampleRate.Num <= 0 || info.SampleRate.Den <= 0 || info.SampleRate.Num%info.SampleRate.Den != 0 ||
+			info.SampleRate.Num/info.SampleRate.Den > math.MaxUint32 {
+			return pcmFormat{}, unsupportedAudioFormat(info, "sample rate "+info.SampleRate.String())
+		}
+		format.SampleRate = uint32(info.SampleRate.Num / info.SampleRate.Den)
+	}
+	if info.Channels < 0 || info.Channels > math.MaxUint16 || info.BitDepth < 0 || info.BitDepth > math.MaxUint16 {
+		return pcmFormat{}, unsupportedAudioFormat(info, fmt.Sprintf("%d channels in %d-bit", info.Channels, info.BitDepth))
+	}
+	if info.Channels > 0 {
+		format.Channels = uint16(info.Channels)
+	}
+	if info.BitDepth > 0 {
+		format.BitsPerSample = uint16(info.BitDepth)
+	}
+	return format, nil
+}
+
+// unsupportedAudioFormat the error of the format of info which can not be generated natively
+func unsupportedAudioFormat(info *MediaInfoV2, format string) error {
+	return fmt.Errorf("%w %s in %s to generate natively", ErrUnsupportedFormat, info.Ext, format)
+}
+
+// totalSamples samples per channel of info's duration, which is rounded up to keep the duration
+// in sample rates like 44.1k, math.MaxUint64 if it overflows
+func (f pcmFormat) totalSamples(info *MediaInfoV2) uint64 {
+	if info.Duration <= 0 {
+		return 0
+	}
+	hi, lo := bits.Mul64(uint64(info.Duration), uint64(f.SampleRate))
+	lo, carry := bits.Add64(lo, uint64(time.Second)-1, 0)
+	if hi += carry; hi >= uint64(time.Second) {
+		return math.MaxUint64
+	}
+	samples, _ := bits.Div64(hi, lo, uint64(time.Second))
+	return samples
+}
+
+// blockAlign bytes of a sample in all channels
+func (f pcmFormat) blockAlign() uint64 {
+	return uint64(f.Channels) * uint64(f.BitsPerSample/8)
+}
+
+// writeZeros write n zero bytes into w
+func writeZeros(w io.Writer, n uint64) error {
+	zeros := make([]byte, 32*1024)
+	for n > 0 {
+		chunk := uint64(len(zeros))
+		if chunk > n {
+			chunk = n
+		}
+		if _, err := w.Write(zeros[:chunk]); err != nil {
+			return err
+		}
+		n -= chunk
+	}
+	return nil
+}
+
+// wavPCMSubFormat the KSDATAFORMAT_SUBTYPE_PCM GUID of WAVE_FORMAT_EXTENSIBLE
+var wavPCMSubFormat = []byte{
+	0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x80, 0x00, 0x00, 0xAA, 0x00, 0x38, 0x9B, 0x71,
+}
+
+// makeNullWAV write a silent PCM wav of exactly info's duration into w in the format of info, with the signature
+// as its title, RF64 is used when the data exceeds 4GB, WAVE_FORMAT_EXTENSIBLE is used for more than 2 channels
+// or 16 bits, which holds the channel layout
+func makeNullWAV(w io.Writer, info *MediaInfoV2) error {
+	format, err := nullAudioFormatOf(info)
+	if err != nil {
+		return err
+	}
+	codec := pcmCodec(int(format.BitsPerSample), false, false)
+	if len(codec) == 0 || (len(info.AudioCodec) > 0 && info.AudioCodec != codec) {
+		return unsupportedAudioFormat(info, fmt.Sprintf("codec %s in %d-bit", orDefault(info.AudioCodec, "pcm"),
+			format.BitsPerSample))
+	}
+	channelMask, extensible := uint32(0), format.Channels > 2 || format.BitsPerSample > 16
+	if len(info.ChannelLayout) > 0 {
+		mask, exists := wavChannelMasks[info.ChannelLayout]
+		if !exists || bits.OnesCount32(mask) != int(format.Channels) {
+			return unsupportedAudioFormat(info, fmt.Sprintf("channel layout %s in %d channels",
+				info.ChannelLayout, format.Channels))
+		}
+		channelMask = mask
+	}
+	if format.totalSamples(info) > math.MaxInt64/format.blockAlign() {
+		return unsupportedAudioFormat(info, "duration "+info.Duration.String())
+	}
+	dataSize := format.totalSamples(info) * format.blockAlign()
+
+	// LIST/INFO/INAM chunk with the signature, text is null terminated and padded to even size
+	title := append([]byte(info.Signature), 0)
+	if len(title)%2 == 1 {
+		title = append(title, 0)
+	}
+	list := make([]byte, 0, 12+len(title))
+	list = append(list, "INFO"...)
+	list = append(list, "INAM"...)
+	list = appendUint32LE(list, uint32(len(title)))
+	list = append(list, title...)
+
+	// fmt chunk: format(2) channels(2) sample_rate(4) byte_rate(4) block_align(2) bits(2)
+	// [extension_size(2) valid_bits(2) channel_mask(4) sub_format(16)] of WAVE_FORMAT_EXTENSIBLE
+	fmtChunk := make([]byte, 0, 40)
+	if extensible {
+		fmtChunk = appendUint16LE(fmtChunk, 0xFFFE)
+	} else {
+		fmtChunk = appendUint16LE(fmtChunk, 1) // PCM
+	}
+	fmtChunk = appendUint16LE(fmtChunk, format.Channels)
+	fmtChunk = appendUint32LE(fmtChunk, format.SampleRate)
+	fmtChunk = appendUint32LE(fmtChunk, format.SampleRate*uint32(format.blockAlign()))
+	fmtChunk = appendUint16LE(fmtChunk, uint16(format.blockAlign()))
+	fmtChunk = appendUint16LE(fmtChunk, format.BitsPerSample)
+	if extensible {
+		fmtChunk = appendUint16LE(fmtChunk, 22)
+		fmtChunk = appendUint16LE(fmtChunk, format.BitsPerSample)
+		fmtChunk = appendUint32LE(fmtChunk, channelMask)
+		fmtChunk = append(fmtChunk, wavPCMSubFormat...)
+	}
+
+	dataPadding := dataSize % 2
+	riffSize := 4 + (8 + uint64(len(fmtChunk))) + (8 + uint64(len(list))) + (8 + dataSize + dataPadding)
+	header := make([]byte, 0, 128)
+	if riffSize <= 0xFFFFFFFF {
+		header = append(header, "RIFF"...)
+		header = appendUint32LE(header, uint32(riffSize))
+		header = append(header, "WAVE"...)
+	} else {
+		// ds64 chunk: riff_size(8) data_size(8) sample_count(8) table_length(4)
+		riffSize += 8 + 28
+		header = append(header, "RF64"...)
+		header = appendUint32LE(header, 0xFFFFFFFF)
+		header = append(header, "WAVE"...)
+		header = append(header, "ds64"...)
+		header = appendUint32LE(header, 28)
+		header = appendUint64LE(header, riffSize)
+		header = appendUint64LE(header, dataSize)
+		header = appendUint64LE(header, format.totalSamples(info))
+		header = appendUint32LE(header, 0)
+	}
+	header = append(header, "fmt "...)
+	header = appendUint32LE(header, uint32(len(fmtChunk)))
+	header = append(header, fmtChunk...)
+	header = append(header, "LIST"...)
+	header = appendUint32LE(header, uint32(len(list)))
+	header = append(header, list...)
+	header = append(header, "data"...)
+	if dataSize <= 0xFFFFFFFF {
+		header = appendUint32LE(header, uint32(dataSize))
+	} else {
+		header = appendUint32LE(header, 0xFFFFFFFF)
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	return writeZeros(w, dataSize+dataPadding)
+}
+
+// flacBlockSize samples per channel of every flac frame except the last one
+const flacBlockSize = 4096
+
+// flacMaxSampleRate the max sample rate of flac
+const flacMaxSampleRate = 655350
+
+// makeNullFLAC write a silent flac of exactly info's duration into w in the format of info, with the signature
+// as its title, every frame is made of CONSTANT subframes, which takes a few bytes only, the channel layout
+// is the default one of the channels
+func makeNullFLAC(w io.Writer, info *MediaInfoV2) error {
+	format, err := nullAudioFormatOf(info)
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(info.AudioCodec) > 0 && info.AudioCodec != "flac":
+		return unsupportedAudioFormat(info, "codec "+info.AudioCodec)
+	case format.Channels > 8 ||
+		(len(info.ChannelLayout) > 0 && info.ChannelLayout != vorbisChannelLayouts[format.Channels-1]):
+		return unsupportedAudioFormat(info, fmt.Sprintf("channel layout %s in %d channels",
+			orDefault(info.ChannelLayout, "unknown"), format.Channels))
+	case flacSampleSizeCode(format.BitsPerSample) == 0: // CONSTANT subframes are byte aligned in 8, 16 & 24 bits
+		return unsupportedAudioFormat(info, fmt.Sprintf("%d-bit", format.BitsPerSample))
+	case format.SampleRate > flacMaxSampleRate:
+		return unsupportedAudioFormat(info, "sample rate "+info.SampleRate.String())
+	}
+	totalSamples := format.totalSamples(info)
+	if totalSamples >= 1<<36 {
+		return unsupportedAudioFormat(info, "duration "+info.Duration.String())
+	}
+
+	// STREAMINFO: min_block(16) max_block(16) min_frame(24) max_frame(24)
+	// sample_rate(20) channels(3) bits_per_sample(5) total_samples(36) md5(128), md5 is left unset
+	streamInfo := make([]byte, 34)
+	binary.BigEndian.PutUint16(streamInfo[0:2], flacBlockSize)
+	binary.BigEndian.PutUint16(streamInfo[2:4], flacBlockSize)
+	binary.BigEndian.PutUint64(streamInfo[10:18], uint64(format.SampleRate)<<44|
+		uint64(format.Channels-1)<<41|uint64(format.BitsPerSample-1)<<36|totalSamples&(1<<36-1))
+
+	// VORBIS_COMMENT: vendor_length(4) vendor comments_count(4) [comment_length(4) comment], in little endian
+	vendor := "mediashrink"
+	comment := "TITLE=" + info.Signature
+	vorbisComment := make([]byte, 0, 16+len(vendor)+len(comment))
+	vorbisComment = appendUint32LE(vorbisComment, uint32(len(vendor)))
+	vorbisComment = append(vorbisComment, vendor...)
+	vorbisComment = appendUint32LE(vorbisComment, 1)
+	vorbisComment = appendUint32LE(vorbisComment, uint32(len(comment)))
+	vorbisComment = append(vorbisComment, comment...)
+
+	// metadata block header: last(1 bit) type(7 bits) length(24)
+	header := make([]byte, 0, 4+4+len(streamInfo)+4+len(vorbisComment))
+	header = append(header, "fLaC"...)
+	header = append(header, 0x00, 0, 0, byte(len(streamInfo)))
+	header = append(header, streamInfo...)
+	header = append(header, 0x80|0x04, byte(len(vorbisComment)>>16), byte(len(vorbisComment)>>8), byte(len(vorbisComment)))
+	header = append(header, vorbisComment...)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	frame := make([]byte, 0, 64)
+	for frameNumber := uint64(0); frameNumber*flacBlockSize < totalSamples; frameNumber++ {
+		blockSize := totalSamples - frameNumber*flacBlockSize
+		if blockSize > flacBlockSize {
+			blockSize = flacBlockSize
+		}
+		frame = appendFLACFrame(frame[:0], format, frameNumber, blockSize)
+		if _, err := w.Write(frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendFLACFrame append a frame of silence in blockSize samples
+func appendFLACFrame(frame []byte, format pcmFormat, frameNumber, blockSize uint64) []byte {
+	// sync(14) reserved(1) blocking_strategy(1), fixed block size
+	frame = append(frame, 0xFF, 0xF8)
+	// block_size(4) sample_rate(4)
+	blockSizeCode := byte(0x07) // 16 bits (block size - 1) at the end of the header
+	if blockSize == flacBlockSize {
+		blockSizeCode = 0x0C
+	}
+	sampleRateCode, sampleRateBytes := flacSampleRateCode(format.SampleRate)
+	frame = append(frame, blockSizeCode<<4|sampleRateCode)
+	// channel_assignment(4) sample_size(3) reserved(1), channels are stored independently
+	frame = append(frame, byte(format.Channels-1)<<4|flacSampleSizeCode(format.BitsPerSample)<<1)
+	frame = appendUTF8Number(frame, frameNumber)
+	if blockSizeCode == 0x07 {
+		frame = append(frame, byte((blockSize-1)>>8), byte(blockSize-1))
+	}
+	frame = append(frame, sampleRateBytes...)
+	frame = append(frame, crc8(frame))
+
+	// CONSTANT subframe of every channel: padding(1) type(6) wasted_bits(1), then the value 0 in sample size,
+	// the frame stays byte aligned as long as bits per sample is a multiple of 8
+	for c := uint16(0); c < format.Channels; c++ {
+		frame = append(frame, 0x00)
+		for b := uint16(0); b < format.BitsPerSample/8; b++ {
+			frame = append(frame, 0x00)
+		}
+	}
+	crc := crc16(frame)
+	return append(frame, byte(crc>>8), byte(crc))
+}
+
+// flacSampleRateCode sample rate code in the frame header and the extra bytes following it
+func flacSampleRateCode(sampleRate uint32) (byte, []byte) {
+	switch sampleRate {
+	case 88200:
+		return 0x01, nil
+	case 176400:
+		return 0x02, nil
+	case 192000:
+		return 0x03, nil
+	case 8000:
+		return 0x04, nil
+	case 16000:
+		return 0x05, nil
+	case 22050:
+		return 0x06, nil
+	case 24000:
+		return 0x07, nil
+	case 32000:
+		return 0x08, nil
+	case 44100:
+		return 0x09, nil
+	case 48000:
+		return 0x0A, nil
+	case 96000:
+		return 0x0B, nil
+	}
+	switch {
+	case sampleRate%1000 == 0 && sampleRate/1000 <= 0xFF:
+		return 0x0C, []byte{byte(sampleRate / 1000)}
+	case sampleRate <= 0xFFFF:
+		return 0x0D, []byte{byte(sampleRate >> 8), byte(sampleRate)}
+	case sampleRate%10 == 0 && sampleRate/10 <= 0xFFFF:
+		return 0x0E, []byte{byte(sampleRate / 10 >> 8), byte(sampleRate / 10)}
+	}
+	return 0x00, nil // get from STREAMINFO
+}
+
+// flacSampleSizeCode sample size code in the frame header
+func flacSampleSizeCode(bitsPerSample uint16) byte {
+	switch bitsPerSample {
+	case 8:
+		return 0x01
+	case 16:
+		return 0x04
+	case 24:
+		return 0x06
+	}
+	return 0x00 // get from STREAMINFO
+}
+
+// appendUTF8Number append n in the extended UTF-8 coding used by flac frame numbers
+func appendUTF8Number(b []byte, n uint64) []byte {
+	if n < 0x80 {
+		return append(b, byte(n))
+	}
+	// count continuation bytes carrying 6 bits each
+	continuations := 1
+	for n >= uint64(1)<<uint(6*continuations+6-continuations) {
+		continuations++
+	}
+	leading := byte(0xFF << uint(7-continuations))
+	b = append(b, leading|byte(n>>uint(6*continuations)))
+	for i := continuations - 1; i >= 0; i-- {
+		b = append(b, 0x80|byte(n>>uint(6*i))&0x3F)
+	}
+	return b
+}
+
+// crc8 CRC-8 of flac frame headers, polynomial x^8 + x^2 + x^1 + x^0
+func crc8(data []byte) byte {
+	crc := byte(0)
+	for _, b := range data {
+		crc ^= b
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// crc16 CRC-16 of flac frames, polynomial x^16 + x^15 + x^2 + x^0
+func crc16(data []byte) uint16 {
+	crc := uint16(0)
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// appendUint32LE append v in little endian
+func appendUint32LE(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// appendUint64LE append v in little endian
+func appendUint64LE(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
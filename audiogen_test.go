@@ -0,0 +1,207 @@
+package mediashrink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestFLACCRC(t *testing.T) {
+	// the check values of CRC-8 & CRC-16/UMTS (BUYPASS), which are the ones of flac
+	check := []byte("123456789")
+	if got := crc8(check); got != 0xF4 {
+		t.Errorf("got crc8 %#x, want 0xf4", got)
+	}
+	if got := crc16(check); got != 0xFEE8 {
+		t.Errorf("got crc16 %#x, want 0xfee8", got)
+	}
+	if got := crc8(nil); got != 0 {
+		t.Errorf("got crc8 %#x of nothing, want 0", got)
+	}
+}
+
+func TestAppendUTF8Number(t *testing.T) {
+	tests := []struct {
+		n    uint64
+		want string
+	}{
+		{0, "00"},
+		{0x7F, "7f"},
+		{0x80, "c280"},
+		{200, "c388"},
+		{0x7FF, "dfbf"},
+		{0x800, "e0a080"},
+		{5000, "e18e88"},
+		{0xFFFF, "efbfbf"},
+		{0x10000, "f0908080"},
+		{1<<36 - 1, "febfbfbfbfbfbf"}, // the largest sample number
+	}
+	for _, test := range tests {
+		if got := hex.EncodeToString(appendUTF8Number(nil, test.n)); got != test.want {
+			t.Errorf("got %s of %d, want %s", got, test.n, test.want)
+		}
+	}
+}
+
+func TestAppendFLACFrame(t *testing.T) {
+	// frames hand-crafted with the CRCs computed independently
+	tests := []struct {
+		name        string
+		format      pcmFormat
+		frameNumber uint64
+		blockSize   uint64
+		want        string
+	}{
+		{"cd", pcmFormat{SampleRate: 44100, Channels: 2, BitsPerSample: 16}, 0, 4096,
+			"fff8c91800c2000000000000b8ee"},
+		{"last frame", pcmFormat{SampleRate: 22050, Channels: 1, BitsPerSample: 8}, 200, 100,
+			"fff87602c3880063890000fe13"},
+		{"rate in Hz", pcmFormat{SampleRate: 12345, Channels: 3, BitsPerSample: 24}, 5000, 4096,
+			"fff8cd2ce18e88303952000000000000000000000000da89"},
+	}
+	for _, test := range tests {
+		got := hex.EncodeToString(appendFLACFrame(nil, test.format, test.frameNumber, test.blockSize))
+		if got != test.want {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestMakeNullFLAC(t *testing.T) {
+	tests := []*MediaInfoV2{
+		{Duration: time.Second},
+		{Duration: 2500 * time.Millisecond, SampleRate: Rational{Num: 44100, Den: 1}, Channels: 2, BitDepth: 24},
+		{Duration: 10 * time.Millisecond, SampleRate: Rational{Num: 22050, Den: 1}, Channels: 1, BitDepth: 8},
+		{Duration: 3 * time.Second, SampleRate: Rational{Num: 12345, Den: 1}, Channels: 6},
+		{Duration: 5 * time.Minute, SampleRate: Rational{Num: 96000, Den: 1}, Channels: 8}, // frame numbers in 3 bytes
+	}
+	for _, info := range tests {
+		var buf bytes.Buffer
+		if err := makeNullFLAC(&buf, info); err != nil {
+			t.Fatalf("%s: %v", info.ToString(), err)
+		}
+		data := buf.Bytes()
+		probed, err := getFLACInfo(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("%s: %v", info.ToString(), err)
+		}
+		format, _ := nullAudioFormatOf(info)
+		// the duration is rounded up to samples
+		sample := time.Second / time.Duration(format.SampleRate)
+		if probed.Duration < info.Duration || probed.Duration-info.Duration >= sample ||
+			probed.Channels != int(format.Channels) || probed.BitDepth != int(format.BitsPerSample) ||
+			probed.SampleRate != (Rational{Num: int64(format.SampleRate), Den: 1}) {
+			t.Errorf("got %s, want %s", probed.ToString(), info.ToString())
+		}
+		totalSamples := format.totalSamples(info)
+		if samples, err := walkFLACFrames(data, format); err != nil {
+			t.Errorf("%s: %v", info.ToString(), err)
+		} else if samples != totalSamples {
+			t.Errorf("%s: got %d samples in frames, want %d", info.ToString(), samples, totalSamples)
+		}
+	}
+}
+
+// walkFLACFrames check the sync codes, frame numbers & CRCs of the frames of CONSTANT subframes in a flac
+// following its metadata blocks, returns the samples per channel of the frames
+func walkFLACFrames(data []byte, format pcmFormat) (uint64, error) {
+	offset := 4
+	for last := false; !last; {
+		if offset+4 > len(data) {
+			return 0, errNotFLAC
+		}
+		last = data[offset]&0x80 != 0
+		offset += 4 + (int(data[offset+1])<<16 | int(data[offset+2])<<8 | int(data[offset+3]))
+	}
+	samples := uint64(0)
+	for frameNumber := uint64(0); offset < len(data); frameNumber++ {
+		frame := data[offset:]
+		if len(frame) < 6 || frame[0] != 0xFF || frame[1] != 0xF8 {
+			return 0, fmt.Errorf("no sync code of frame %d", frameNumber)
+		}
+		number := appendUTF8Number(nil, frameNumber)
+		if !bytes.HasPrefix(frame[4:], number) {
+			return 0, fmt.Errorf("frame number %d missing", frameNumber)
+		}
+		n, blockSize := 4+len(number), uint64(flacBlockSize)
+		if frame[2]>>4 == 0x07 {
+			blockSize = uint64(binary.BigEndian.Uint16(frame[n:])) + 1
+			n += 2
+		}
+		switch frame[2] & 0x0F {
+		case 0x0C:
+			n++
+		case 0x0D, 0x0E:
+			n += 2
+		}
+		end := n + 1 + int(format.Channels)*(1+int(format.BitsPerSample/8)) + 2
+		if end > len(frame) {
+			return 0, fmt.Errorf("frame %d truncated", frameNumber)
+		}
+		if crc8(frame[:n]) != frame[n] || crc16(frame[:end-2]) != binary.BigEndian.Uint16(frame[end-2:end]) {
+			return 0, fmt.Errorf("CRC mismatch of frame %d", frameNumber)
+		}
+		samples += blockSize
+		offset += end
+	}
+	return samples, nil
+}
+
+func TestMakeNullAudioFormat(t *testing.T) {
+	tests := []*MediaInfoV2{
+		{Duration: 1001 * time.Millisecond, Ext: "wav", AudioCodec: "pcm_s16le", Channels: 2, ChannelLayout: "stereo",
+			SampleRate: Rational{Num: 44100, Den: 1}, BitDepth: 16},
+		{Duration: 2345 * time.Millisecond, Ext: "wav", AudioCodec: "pcm_s24le", Channels: 6,
+			ChannelLayout: "5.1(side)", SampleRate: Rational{Num: 48000, Den: 1}, BitDepth: 24},
+		{Duration: 2345 * time.Millisecond, Ext: "wav", AudioCodec: "pcm_u8", Channels: 1, ChannelLayout: "mono",
+			SampleRate: Rational{Num: 22050, Den: 1}, BitDepth: 8},
+		{Duration: 3001 * time.Millisecond, Ext: "flac", AudioCodec: "flac", Channels: 6, ChannelLayout: "5.1",
+			SampleRate: Rational{Num: 96000, Den: 1}, BitDepth: 24},
+		{Duration: 3001 * time.Millisecond, Ext: "flac", AudioCodec: "flac", Channels: 2, ChannelLayout: "stereo",
+			SampleRate: Rational{Num: 44100, Den: 1}, BitDepth: 16},
+	}
+	for _, info := range tests {
+		info.Signature = "abcdef"
+		var buf bytes.Buffer
+		if err := audioGenerators[info.Ext](&buf, info); err != nil {
+			t.Fatalf("%s: %v", info.ToString(), err)
+		}
+		probed, err := audioHeaderParsers[info.Ext](bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatalf("%s: %v", info.ToString(), err)
+		}
+		if probed.Duration.Milliseconds() != info.Duration.Milliseconds() || probed.AudioCodec != info.AudioCodec ||
+			probed.Channels != info.Channels || probed.ChannelLayout != info.ChannelLayout ||
+			probed.SampleRate != info.SampleRate || probed.BitDepth != info.BitDepth {
+			t.Errorf("got %s, want %s", probed.ToString(), info.ToString())
+		}
+	}
+}
+
+func TestMakeNullAudioFormatUnsupported(t *testing.T) {
+	tests := []*MediaInfoV2{
+		{Ext: "wav", AudioCodec: "mp3"},
+		{Ext: "wav", AudioCodec: "pcm_s16le", BitDepth: 24},
+		{Ext: "wav", Channels: 2, ChannelLayout: "5.1"},
+		{Ext: "wav", ChannelLayout: "FL+FR+LFE", Channels: 3},
+		{Ext: "wav", BitDepth: 20},
+		{Ext: "wav", SampleRate: Rational{Num: 1, Den: 3}},
+		{Ext: "flac", AudioCodec: "alac"},
+		{Ext: "flac", Channels: 6, ChannelLayout: "5.1(side)"},
+		{Ext: "flac", Channels: 9},
+		{Ext: "flac", BitDepth: 20},
+		{Ext: "flac", SampleRate: Rational{Num: 1 << 20, Den: 1}},
+	}
+	for _, info := range tests {
+		info.Duration, info.Signature = time.Second, "abcdef"
+		var buf bytes.Buffer
+		if err := audioGenerators[info.Ext](&buf, info); !errors.Is(err, ErrUnsupportedFormat) || buf.Len() != 0 {
+			t.Errorf("got error %v with %d bytes written of %s, want %v without writing",
+				err, buf.Len(), info.ToString(), ErrUnsupportedFormat)
+		}
+	}
+}
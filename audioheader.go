@@ -0,0 +1,510 @@
+package mediashrink
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+)
+
+var (
+	errNotWAV  = errors.New("not a WAV file")
+	errNotFLAC = errors.New("not a FLAC file")
+	errNotOGG  = errors.New("not an OGG file")
+	errNotMP3  = errors.New("not a MP3 file")
+	errNotAAC  = errors.New("not an ADTS AAC file")
+)
+
+// durationOfSamples convert samples in sampleRate into a duration in full precision
+func durationOfSamples(samples, sampleRate uint64) time.Duration {
+	if sampleRate == 0 {
+		return 0
+	}
+	return time.Duration(samples/sampleRate*uint64(time.Second) + samples%sampleRate*uint64(time.Second)/sampleRate)
+}
+
+// skipID3v2 get offset of the data following the ID3v2 tag, 0 if there is no tag
+func skipID3v2(r io.ReaderAt) int64 {
+	// "ID3" version(2) flags(1) size(4, syncsafe integer)
+	header := make([]byte, 10)
+	if err := readAt(r, header, 0); err != nil || !bytes.Equal(header[:3], []byte("ID3")) {
+		return 0
+	}
+	size := int64(header[6]&0x7F)<<21 | int64(header[7]&0x7F)<<14 | int64(header[8]&0x7F)<<7 | int64(header[9]&0x7F)
+	if header[5]&0x10 != 0 { // footer presented
+		size += 10
+	}
+	return 10 + size
+}
+
+// getWAVInfo get duration of wav (and RF64) files from the fmt and data chunks
+func getWAVInfo(r io.ReaderAt, size int64) (*MediaInfoV2, error) {
+	header := make([]byte, 12)
+	if err := readAt(r, header, 0); err != nil {
+		return nil, errNotWAV
+	}
+	isRF64 := bytes.Equal(header[:4], []byte("RF64"))
+	if (!bytes.Equal(header[:4], []byte("RIFF")) && !isRF64) || !bytes.Equal(header[8:12], []byte("WAVE")) {
+		return nil, errNotWAV
+	}
+
+	// chunks: id(4) size(4, little endian) data, padded to even size
+	var format, byteRate, sampleRate, factSamples, dataSize, ds64DataSize uint64
+	var channels, bits, validBits int
+	var channelMask uint32
+	var subFormat uint16
+	hasFormat, hasData := false, false
+	chunk := make([]byte, 40)
+	for offset := int64(12); offset+8 <= size && !(hasFormat && hasData); {
+		if err := readAt(r, chunk[:8], offset); err != nil {
+			return nil, err
+		}
+		chunkID := string(chunk[:4])
+		chunkSize := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+		switch chunkID {
+		case "fmt ":
+			// format(2) channels(2) sample_rate(4) byte_rate(4) block_align(2) bits(2)
+			// [extension_size(2) valid_bits(2) channel_mask(4) sub_format(16)] of WAVE_FORMAT_EXTENSIBLE
+			if chunkSize < 16 {
+				return nil, errNotWAV
+			}
+			if err := readAt(r, chunk[:16], offset+8); err != nil {
+				return nil, err
+			}
+			format = uint64(binary.LittleEndian.Uint16(chunk[0:2]))
+			channels = int(binary.LittleEndian.Uint16(chunk[2:4]))
+			sampleRate = uint64(binary.LittleEndian.Uint32(chunk[4:8]))
+			byteRate = uint64(binary.LittleEndian.Uint32(chunk[8:12]))
+			bits = int(binary.LittleEndian.Uint16(chunk[14:16]))
+			if format == 0xFFFE && chunkSize >= 40 {
+				if err := readAt(r, chunk[16:40], offset+8+16); err != nil {
+					return nil, err
+				}
+				validBits = int(binary.LittleEndian.Uint16(chunk[18:20]))
+				channelMask = binary.LittleEndian.Uint32(chunk[20:24])
+				subFormat = binary.LittleEndian.Uint16(chunk[24:26])
+			}
+			hasFormat = true
+		case "fact":
+			if chunkSize >= 4 {
+				if err := readAt(r, chunk[:4], offset+8); err != nil {
+					return nil, err
+				}
+				factSamples = uint64(binary.LittleEndian.Uint32(chunk[0:4]))
+			}
+		case "ds64":
+			// riff_size(8) data_size(8) sample_count(8)
+			if chunkSize >= 24 {
+				if err := readAt(r, chunk[:24], offset+8); err != nil {
+					return nil, err
+				}
+				ds64DataSize = binary.LittleEndian.Uint64(chunk[8:16])
+			}
+		case "data":
+			dataSize = uint64(chunkSize)
+			if isRF64 && chunkSize == 0xFFFFFFFF {
+				dataSize = ds64DataSize
+			}
+			// truncated files
+			if remains := uint64(size - offset - 8); dataSize > remains {
+				dataSize = remains
+			}
+			hasData = true
+		}
+		offset += 8 + chunkSize + chunkSize%2
+	}
+	if !hasFormat || !hasData || byteRate == 0 {
+		return nil, errNotWAV
+	}
+
+	// PCM, IEEE float and extensible formats are in constant byte rate,
+	// the fact chunk is more accurate for the compressed ones
+	var info *MediaInfoV2
+	if format != 0x0001 && format != 0x0003 && format != 0xFFFE && factSamples > 0 {
+		info = durationInfo(durationOfSamples(factSamples, sampleRate))
+	} else {
+		info = durationInfo(durationOfSamples(dataSize, byteRate))
+	}
+
+	// the format of extensible ones is in the first 2 bytes of the sub format GUID
+	if format == 0xFFFE {
+		format = uint64(subFormat)
+	}
+	codec := wavFormatCodecs[uint16(format)]
+	if format == 0x0001 || format == 0x0003 {
+		codec = pcmCodec(bits, format == 0x0003, false)
+	}
+	if validBits == 0 || validBits > bits {
+		validBits = bits
+	}
+	info.setAudioFormat(codec, channels, sampleRate, validBits)
+	if channelMask != 0 {
+		info.ChannelLayout = wavChannelLayout(channelMask)
+	}
+	return info, nil
+}
+
+// getFLACInfo get duration of flac files from total samples & sample rate of the STREAMINFO block
+func getFLACInfo(r io.ReaderAt, size int64) (*MediaInfoV2, error) {
+	offset := skipID3v2(r)
+	header := make([]byte, 8)
+	if err := readAt(r, header, offset); err != nil || !bytes.Equal(header[:4], []byte("fLaC")) {
+		return nil, errNotFLAC
+	}
+	// the 1st metadata block must be STREAMINFO: last(1 bit) type(7 bits) length(3)
+	if header[4]&0x7F != 0 {
+		return nil, errNotFLAC
+	}
+	streamInfo := make([]byte, 34)
+	if err := readAt(r, streamInfo, offset+8); err != nil {
+		return nil, err
+	}
+	sampleRate, totalSamples := parseFLACStreamInfo(streamInfo)
+	if sampleRate == 0 || totalSamples == 0 {
+		return nil, errNotFLAC
+	}
+	info := durationInfo(durationOfSamples(totalSamples, sampleRate))
+	format := parseFLACFormat(streamInfo)
+	info.setAudioFormat("flac", int(format.Channels), uint64(format.SampleRate), int(format.BitsPerSample))
+	return info, nil
+}
+
+// parseFLACStreamInfo get sample rate and total samples from a STREAMINFO block
+func parseFLACStreamInfo(streamInfo []byte) (uint64, uint64) {
+	// min_block(16) max_block(16) min_frame(24) max_frame(24)
+	// sample_rate(20) channels(3) bits_per_sample(5) total_samples(36) md5(128)
+	bits := binary.BigEndian.Uint64(streamInfo[10:18])
+	return bits >> 44, bits & (1<<36 - 1)
+}
+
+// parseFLACFormat get sample rate, channels and bits per sample from a STREAMINFO block
+func parseFLACFormat(streamInfo []byte) pcmFormat {
+	bits := binary.BigEndian.Uint64(streamInfo[10:18])
+	return pcmFormat{
+		SampleRate:    uint32(bits >> 44),
+		Channels:      uint16(bits>>41&0x07) + 1,
+		BitsPerSample: uint16(bits>>36&0x1F) + 1,
+	}
+}
+
+// getOGGInfo get duration of ogg files from the granule position of the last page
+// and the sample rate of the identification header of the 1st stream
+func getOGGInfo(r io.ReaderAt, size int64) (*MediaInfoV2, error) {
+	// page header: "OggS" version(1) type(1) granule(8) serial(4) sequence(4) crc(4) segments(1) segment_table
+	first := make([]byte, 27+255+64)
+	if int64(len(first)) > size {
+		first = first[:size]
+	}
+	if err := readAt(r, first, 0); err != nil || len(first) < 28 || !bytes.Equal(first[:4], []byte("OggS")) {
+		return nil, errNotOGG
+	}
+	serial := binary.LittleEndian.Uint32(first[14:18])
+	packetOffset := 27 + int(first[26])
+	if packetOffset >= len(first) {
+		return nil, errNotOGG
+	}
+	sampleRate, preSkip := parseOGGIdentification(first[packetOffset:])
+	if sampleRate == 0 {
+		return nil, errNotOGG
+	}
+
+	// search the last page of the stream backward from the end of the file
+	const tailSize = 64 * 1024
+	for end := size; end > 0; {
+		start := end - tailSize
+		if start < 0 {
+			start = 0
+		}
+		tail := make([]byte, end-start)
+		if err := readAt(r, tail, start); err != nil {
+			return nil, err
+		}
+		for i := bytes.LastIndex(tail, []byte("OggS")); i >= 0; i = bytes.LastIndex(tail[:i], []byte("OggS")) {
+			if i+27 > len(tail) || binary.LittleEndian.Uint32(tail[i+14:i+18]) != serial {
+				continue
+			}
+			granule := int64(binary.LittleEndian.Uint64(tail[i+6 : i+14]))
+			if granule < 0 { // -1 for pages without any finished packet
+				continue
+			}
+			samples := uint64(0)
+			if uint64(granule) > preSkip {
+				samples = uint64(granule) - preSkip
+			}
+			info := durationInfo(durationOfSamples(samples, sampleRate))
+			info.setAudioFormat(parseOGGAudioFormat(first[packetOffset:]))
+			return info, nil
+		}
+		if start == 0 {
+			break
+		}
+		// keep 27 bytes overlapped, so pages crossing the boundary are found in the next round
+		end = start + 27
+	}
+	return nil, errNotOGG
+}
+
+// parseOGGIdentification get the sample rate in which granule positions are counted and the samples to skip
+// from the 1st packet of a vorbis, opus, flac or speex stream
+func parseOGGIdentification(packet []byte) (uint64, uint64) {
+	switch {
+	case len(packet) >= 16 && bytes.Equal(packet[:7], []byte("\x01vorbis")):
+		// version(4) channels(1) sample_rate(4)
+		return uint64(binary.LittleEndian.Uint32(packet[12:16])), 0
+	case len(packet) >= 12 && bytes.Equal(packet[:8], []byte("OpusHead")):
+		// version(1) channels(1) pre_skip(2), granule positions are always in 48k
+		return 48000, uint64(binary.LittleEndian.Uint16(packet[10:12]))
+	case len(packet) >= 51 && bytes.Equal(packet[:5], []byte("\x7FFLAC")):
+		// version(2) headers(2) "fLaC" metadata_header(4) STREAMINFO(34)
+		sampleRate, _ := parseFLACStreamInfo(packet[17:51])
+		return sampleRate, 0
+	case len(packet) >= 40 && bytes.Equal(packet[:8], []byte("Speex   ")):
+		// version(20) version_id(4) header_size(4) sample_rate(4)
+		return uint64(binary.LittleEndian.Uint32(packet[36:40])), 0
+	}
+	return 0, 0
+}
+
+// parseOGGAudioFormat get codec, channels, sample rate and bits per sample from the 1st packet of a vorbis, opus,
+// flac or speex stream, opus is always decoded in 48k
+func parseOGGAudioFormat(packet []byte) (string, int, uint64, int) {
+	switch {
+	case len(packet) >= 16 && bytes.Equal(packet[:7], []byte("\x01vorbis")):
+		// version(4) channels(1) sample_rate(4)
+		return "vorbis", int(packet[11]), uint64(binary.LittleEndian.Uint32(packet[12:16])), 0
+	case len(packet) >= 12 && bytes.Equal(packet[:8], []byte("OpusHead")):
+		// version(1) channels(1)
+		return "opus", int(packet[9]), 48000, 0
+	case len(packet) >= 51 && bytes.Equal(packet[:5], []byte("\x7FFLAC")):
+		format := parseFLACFormat(packet[17:51])
+		return "flac", int(format.Channels), uint64(format.SampleRate), int(format.BitsPerSample)
+	case len(packet) >= 52 && bytes.Equal(packet[:8], []byte("Speex   ")):
+		// version(20) version_id(4) header_size(4) sample_rate(4) mode(4) mode_version(4) channels(4)
+		return "speex", int(binary.LittleEndian.Uint32(packet[48:52])), uint64(binary.LittleEndian.Uint32(packet[36:40])), 0
+	}
+	return "", 0, 0, 0
+}
+
+var (
+	// mp3 bitrates in kbps indexed by [version is MPEG1 ? 0 : 1][layer - 1][bitrate index]
+	mp3Bitrates = [2][3][15]uint64{
+		{
+			{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+			{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+		},
+		{
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		},
+	}
+	// mp3 sample rates indexed by [version bits][sample rate index], version bits 01 is reserved
+	mp3SampleRates = [4][3]uint64{
+		{11025, 12000, 8000},  // MPEG 2.5
+		{0, 0, 0},             // reserved
+		{22050, 24000, 16000}, // MPEG 2
+		{44100, 48000, 32000}, // MPEG 1
+	}
+	// aac sample rates indexed by the sampling frequency index
+	aacSampleRates = []uint64{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+)
+
+// mp3Frame a parsed mp3 frame header
+type mp3Frame struct {
+	Size       int
+	Samples    uint64
+	SampleRate uint64
+	SideInfo   int // size of side info of layer III
+	Layer      int
+	Channels   int
+}
+
+// parseMP3Frame parse the 4 bytes mp3 frame header
+func parseMP3Frame(header []byte) (mp3Frame, bool) {
+	// sync(11) version(2) layer(2) protection(1) bitrate(4) sample_rate(2) padding(1) private(1) channel_mode(2) ...
+	if len(header) < 4 || header[0] != 0xFF || header[1]&0xE0 != 0xE0 {
+		return mp3Frame{}, false
+	}
+	version := (header[1] >> 3) & 0x03
+	layer := 4 - int((header[1]>>1)&0x03) // 1, 2, 3 or 4 for the reserved
+	bitrateIndex := header[2] >> 4
+	sampleRateIndex := (header[2] >> 2) & 0x03
+	padding := int((header[2] >> 1) & 0x01)
+	mono := header[3]>>6 == 0x03
+	if version == 1 || layer == 4 || bitrateIndex == 0 || bitrateIndex == 15 || sampleRateIndex == 3 {
+		return mp3Frame{}, false
+	}
+	isMPEG1 := version == 3
+	table := 1
+	if isMPEG1 {
+		table = 0
+	}
+	bitrate := mp3Bitrates[table][layer-1][bitrateIndex] * 1000
+	frame := mp3Frame{SampleRate: mp3SampleRates[version][sampleRateIndex], Layer: layer, Channels: 2}
+	if mono {
+		frame.Channels = 1
+	}
+	switch {
+	case layer == 1:
+		frame.Samples = 384
+		frame.Size = (int(12*bitrate/frame.SampleRate) + padding) * 4
+	case layer == 2 || isMPEG1:
+		frame.Samples = 1152
+		frame.Size = int(144*bitrate/frame.SampleRate) + padding
+	default: // layer III of MPEG 2 & 2.5
+		frame.Samples = 576
+		frame.Size = int(72*bitrate/frame.SampleRate) + padding
+	}
+	switch {
+	case isMPEG1 && !mono:
+		frame.SideInfo = 32
+	case isMPEG1 || !mono:
+		frame.SideInfo = 17
+	default:
+		frame.SideInfo = 9
+	}
+	return frame, frame.Size > 4
+}
+
+// getMP3Info get duration of mp3 files from the Xing / Info or VBRI header of the 1st frame,
+// or by counting all frames for CBR files without them
+func getMP3Info(r io.ReaderAt, size int64) (*MediaInfoV2, error) {
+	offset := skipID3v2(r)
+	if offset >= size {
+		return nil, errNotMP3
+	}
+	// locate the 1st frame, there might be junk between the ID3 tag and it
+	const searchSize = 64 * 1024
+	search := make([]byte, searchSize)
+	if int64(len(search)) > size-offset {
+		search = search[:size-offset]
+	}
+	if err := readAt(r, search, offset); err != nil {
+		return nil, errNotMP3
+	}
+	var first mp3Frame
+	found := false
+	for i := 0; i+4 <= len(search); i++ {
+		frame, ok := parseMP3Frame(search[i:])
+		if !ok {
+			continue
+		}
+		// the next frame must be valid too, avoid false sync in junk
+		if next := i + frame.Size; next+4 <= len(search) {
+			if _, ok := parseMP3Frame(search[next:]); !ok {
+				continue
+			}
+		}
+		first, found = frame, true
+		offset += int64(i)
+		search = search[i:]
+		break
+	}
+	if !found {
+		return nil, errNotMP3
+	}
+
+	// Xing / Info header following the side info: "Xing" flags(4) [frames(4)] ...
+	if xing := 4 + first.SideInfo; xing+12 <= len(search) &&
+		(bytes.Equal(search[xing:xing+4], []byte("Xing")) || bytes.Equal(search[xing:xing+4], []byte("Info"))) &&
+		binary.BigEndian.Uint32(search[xing+4:xing+8])&0x01 != 0 {
+		frames := uint64(binary.BigEndian.Uint32(search[xing+8 : xing+12]))
+		return first.info(durationOfSamples(frames*first.Samples, first.SampleRate)), nil
+	}
+	// VBRI header at 32 bytes after the frame header: "VBRI" version(2) delay(2) quality(2) bytes(4) frames(4)
+	if vbri := 4 + 32; vbri+18 <= len(search) && bytes.Equal(search[vbri:vbri+4], []byte("VBRI")) {
+		frames := uint64(binary.BigEndian.Uint32(search[vbri+14 : vbri+18]))
+		return first.info(durationOfSamples(frames*first.Samples, first.SampleRate)), nil
+	}
+
+	samples, err := countFrames(r, offset, size, 4, func(header []byte) (int, uint64, bool) {
+		frame, ok := parseMP3Frame(header)
+		return frame.Size, frame.Samples, ok
+	})
+	if err != nil {
+		return nil, err
+	}
+	return first.info(durationOfSamples(samples, first.SampleRate)), nil
+}
+
+// info a MediaInfoV2 of duration d in the format of the frame
+func (frame mp3Frame) info(d time.Duration) *MediaInfoV2 {
+	info := durationInfo(d)
+	info.setAudioFormat("mp"+strconv.Itoa(frame.Layer), frame.Channels, frame.SampleRate, 0)
+	return info
+}
+
+// parseAACFrame parse the 7 bytes ADTS header, returns frame size, samples and sample rate
+func parseAACFrame(header []byte) (int, uint64, uint64, bool) {
+	// sync(12) id(1) layer(2) protection_absent(1) profile(2) sample_rate(4) private(1) channels(3)
+	// original(1) home(1) copyright(2) frame_length(13) buffer_fullness(11) raw_data_blocks(2)
+	if len(header) < 7 || header[0] != 0xFF || header[1]&0xF6 != 0xF0 {
+		return 0, 0, 0, false
+	}
+	sampleRateIndex := int((header[2] >> 2) & 0x0F)
+	if sampleRateIndex >= len(aacSampleRates) {
+		return 0, 0, 0, false
+	}
+	frameLength := int(header[3]&0x03)<<11 | int(header[4])<<3 | int(header[5]>>5)
+	rawDataBlocks := uint64(header[6]&0x03) + 1
+	return frameLength, rawDataBlocks * 1024, aacSampleRates[sampleRateIndex], frameLength >= 7
+}
+
+// getAACInfo get duration of ADTS aac files by counting all frames
+func getAACInfo(r io.ReaderAt, size int64) (*MediaInfoV2, error) {
+	offset := skipID3v2(r)
+	header := make([]byte, 7)
+	if err := readAt(r, header, offset); err != nil {
+		return nil, errNotAAC
+	}
+	_, _, sampleRate, ok := parseAACFrame(header)
+	if !ok {
+		return nil, errNotAAC
+	}
+	// channel configuration of 3 bits in the 3rd & 4th bytes, 7 is 7.1 in 8 channels, 0 is defined in the stream
+	channels := int(header[2]&0x01)<<2 | int(header[3]>>6)
+	if channels == 7 {
+		channels = 8
+	}
+	samples, err := countFrames(r, offset, size, 7, func(header []byte) (int, uint64, bool) {
+		frameSize, frameSamples, _, ok := parseAACFrame(header)
+		return frameSize, frameSamples, ok
+	})
+	if err != nil {
+		return nil, err
+	}
+	info := durationInfo(durationOfSamples(samples, sampleRate))
+	info.setAudioFormat("aac", channels, sampleRate, 0)
+	return info, nil
+}
+
+// countFrames sum up samples of continuous frames from offset, frame headers in headerSize are parsed by parser,
+// stops at the first invalid frame such as the ID3v1 or APE tag at the end of file
+func countFrames(r io.ReaderAt, offset, size int64, headerSize int,
+	parser func(header []byte) (int, uint64, bool)) (uint64, error) {
+	reader := bufio.NewReaderSize(io.NewSectionReader(r, offset, size-offset), 64*1024)
+	samples := uint64(0)
+	for {
+		header, err := reader.Peek(headerSize)
+		if err == io.EOF || err == io.ErrUnexpectedEOF || (err == nil && len(header) < headerSize) {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+		frameSize, frameSamples, ok := parser(header)
+		if !ok {
+			break
+		}
+		samples += frameSamples
+		if _, err := reader.Discard(frameSize); err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	return samples, nil
+}
@@ -0,0 +1,506 @@
+package mediashrink
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetFLACInfo(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    *MediaInfoV2
+	}{
+		{
+			// STREAMINFO followed by a PADDING block
+			fixture: "surround.flac",
+			want: &MediaInfoV2{
+				Duration:      2500 * time.Millisecond,
+				SampleRate:    Rational{Num: 48000, Den: 1},
+				AudioCodec:    "flac",
+				Channels:      6,
+				ChannelLayout: "5.1",
+				BitDepth:      24,
+			},
+		},
+		{
+			// the last STREAMINFO after an ID3v2 tag
+			fixture: "id3.flac",
+			want: &MediaInfoV2{
+				Duration:      3500 * time.Millisecond,
+				SampleRate:    Rational{Num: 44100, Den: 1},
+				AudioCodec:    "flac",
+				Channels:      2,
+				ChannelLayout: "stereo",
+				BitDepth:      16,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.fixture, func(t *testing.T) {
+			r := bytes.NewReader(readFixture(t, test.fixture))
+			info, err := getFLACInfo(r, r.Size())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(info, test.want) {
+				t.Errorf("got %s, want %s", info.ToString(), test.want.ToString())
+			}
+		})
+	}
+}
+
+func TestGetFLACInfoInvalid(t *testing.T) {
+	streamInfo := func(rate, totalSamples uint64) []byte {
+		block := make([]byte, 34)
+		bits := rate<<44 | 1<<41 | 15<<36 | totalSamples
+		for i := 0; i < 8; i++ {
+			block[10+i] = byte(bits >> uint(56-8*i))
+		}
+		return block
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"not flac", []byte("OggS\x00\x02\x00\x00")},
+		{"no STREAMINFO 1st", append([]byte("fLaC\x84\x00\x00\x22"), streamInfo(44100, 44100)...)},
+		{"truncated STREAMINFO", []byte("fLaC\x80\x00\x00\x22\x10\x00\x10\x00")},
+		{"no sample rate", append([]byte("fLaC\x80\x00\x00\x22"), streamInfo(0, 44100)...)},
+		{"no total samples", append([]byte("fLaC\x80\x00\x00\x22"), streamInfo(44100, 0)...)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := getFLACInfo(bytes.NewReader(test.data), int64(len(test.data))); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
+
+// le16 v in 2 bytes little endian
+func le16(v uint16) string {
+	return string([]byte{byte(v), byte(v >> 8)})
+}
+
+// le32 v in 4 bytes little endian
+func le32(v uint32) string {
+	return le16(uint16(v)) + le16(uint16(v>>16))
+}
+
+// riffChunk a chunk of id & data padded to even size
+func riffChunk(id, data string) string {
+	chunk := id + le32(uint32(len(data))) + data
+	if len(data)%2 != 0 {
+		chunk += "\x00"
+	}
+	return chunk
+}
+
+// wavFmt a fmt chunk of PCM like formats
+func wavFmt(format, channels uint16, sampleRate, byteRate uint32, bits uint16) string {
+	return riffChunk("fmt ", le16(format)+le16(channels)+le32(sampleRate)+le32(byteRate)+
+		le16(channels*bits/8)+le16(bits))
+}
+
+// wavFile a wav of the chunks, riff is RIFF or RF64
+func wavFile(riff string, chunks ...string) []byte {
+	body := "WAVE" + strings.Join(chunks, "")
+	return []byte(riff + le32(uint32(len(body))) + body)
+}
+
+func TestGetWAVInfo(t *testing.T) {
+	// WAVE_FORMAT_EXTENSIBLE of 20 valid bits in 24 bits samples, 5.1 channel mask & PCM sub format
+	extensible := riffChunk("fmt ", le16(0xFFFE)+le16(6)+le32(48000)+le32(864000)+le16(18)+le16(24)+
+		le16(22)+le16(20)+le32(0x3F)+le16(0x0001)+"\x00\x00\x00\x00\x10\x00\x80\x00\x00\xAA\x00\x38\x9B\x71")
+	tests := []struct {
+		name string
+		data []byte
+		want *MediaInfoV2
+	}{
+		{
+			name: "pcm after a LIST chunk of odd size",
+			data: wavFile("RIFF", wavFmt(1, 1, 8000, 8000, 8), riffChunk("LIST", "INFOabc"),
+				riffChunk("data", strings.Repeat("\x80", 12000))),
+			want: &MediaInfoV2{
+				Duration:      1500 * time.Millisecond,
+				SampleRate:    Rational{Num: 8000, Den: 1},
+				AudioCodec:    "pcm_u8",
+				Channels:      1,
+				ChannelLayout: "mono",
+				BitDepth:      8,
+			},
+		},
+		{
+			name: "truncated data",
+			data: wavFile("RIFF", wavFmt(1, 1, 8000, 16000, 16), "data"+le32(16000)+strings.Repeat("\x00", 8000)),
+			want: &MediaInfoV2{
+				Duration:      500 * time.Millisecond,
+				SampleRate:    Rational{Num: 8000, Den: 1},
+				AudioCodec:    "pcm_s16le",
+				Channels:      1,
+				ChannelLayout: "mono",
+				BitDepth:      16,
+			},
+		},
+		{
+			name: "float",
+			data: wavFile("RIFF", wavFmt(3, 1, 8000, 32000, 32), riffChunk("data", strings.Repeat("\x00", 8000))),
+			want: &MediaInfoV2{
+				Duration:      250 * time.Millisecond,
+				SampleRate:    Rational{Num: 8000, Den: 1},
+				AudioCodec:    "pcm_f32le",
+				Channels:      1,
+				ChannelLayout: "mono",
+				BitDepth:      32,
+			},
+		},
+		{
+			name: "rf64 of the data size in ds64",
+			data: wavFile("RF64", riffChunk("ds64", le32(0)+le32(0)+le32(32000)+le32(0)+le32(8000)+le32(0)),
+				wavFmt(1, 2, 8000, 32000, 16), "data"+le32(0xFFFFFFFF)+strings.Repeat("\x00", 32000)),
+			want: &MediaInfoV2{
+				Duration:      time.Second,
+				SampleRate:    Rational{Num: 8000, Den: 1},
+				AudioCodec:    "pcm_s16le",
+				Channels:      2,
+				ChannelLayout: "stereo",
+				BitDepth:      16,
+			},
+		},
+		{
+			name: "extensible",
+			data: wavFile("RIFF", extensible, riffChunk("data", strings.Repeat("\x00", 216000))),
+			want: &MediaInfoV2{
+				Duration:      250 * time.Millisecond,
+				SampleRate:    Rational{Num: 48000, Den: 1},
+				AudioCodec:    "pcm_s24le",
+				Channels:      6,
+				ChannelLayout: "5.1",
+				BitDepth:      20,
+			},
+		},
+		{
+			name: "adpcm in samples of the fact chunk",
+			data: wavFile("RIFF", wavFmt(0x0011, 1, 22050, 11100, 4), riffChunk("fact", le32(44100)),
+				riffChunk("data", strings.Repeat("\x00", 100))),
+			want: &MediaInfoV2{
+				Duration:      2 * time.Second,
+				SampleRate:    Rational{Num: 22050, Den: 1},
+				AudioCodec:    "adpcm_ima_wav",
+				Channels:      1,
+				ChannelLayout: "mono",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info, err := getWAVInfo(bytes.NewReader(test.data), int64(len(test.data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(info, test.want) {
+				t.Errorf("got %s, want %s", info.ToString(), test.want.ToString())
+			}
+		})
+	}
+}
+
+func TestGetWAVInfoInvalid(t *testing.T) {
+	data := riffChunk("data", strings.Repeat("\x00", 100))
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"not wav", []byte("RIFF\x04\x00\x00\x00AVI ")},
+		{"no fmt", wavFile("RIFF", data)},
+		{"no data", wavFile("RIFF", wavFmt(1, 1, 8000, 8000, 8))},
+		{"fmt too small", wavFile("RIFF", riffChunk("fmt ", le16(1)+le16(1)), data)},
+		{"no byte rate", wavFile("RIFF", wavFmt(1, 1, 8000, 0, 8), data)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := getWAVInfo(bytes.NewReader(test.data), int64(len(test.data))); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
+
+// oggPage an ogg page of a single packet in payload
+func oggPage(serial uint32, granule int64, payload string) string {
+	segments := strings.Repeat("\xFF", len(payload)/255) + string([]byte{byte(len(payload) % 255)})
+	return "OggS\x00\x00" + le32(uint32(granule)) + le32(uint32(granule>>32)) + le32(serial) + le32(0) + le32(0) +
+		string([]byte{byte(len(segments))}) + segments + payload
+}
+
+// vorbisIdentification the identification header of vorbis
+func vorbisIdentification(channels byte, sampleRate uint32) string {
+	return "\x01vorbis" + le32(0) + string([]byte{channels}) + le32(sampleRate) + le32(0) + le32(128000) + le32(0) +
+		"\xB8\x01"
+}
+
+func TestGetOGGInfo(t *testing.T) {
+	// the last page of the stream is followed by more than 64KiB of pages of another stream,
+	// and its header crosses the start of the 1st tail read backward
+	crossing := oggPage(1, 0, vorbisIdentification(1, 8000)) + oggPage(1, 4000, strings.Repeat("a", 1000)) +
+		oggPage(1, 12000, "abcd") + oggPage(2, 100000, strings.Repeat("b", 40000)) +
+		oggPage(2, 200000, strings.Repeat("c", 25204))
+	if start, last := len(crossing)-64*1024, strings.LastIndex(crossing, "abcd")-28; last >= start || last+27 <= start {
+		t.Fatalf("got the last page at %d, want it crossing %d", last, start)
+	}
+	tests := []struct {
+		name string
+		data string
+		want *MediaInfoV2
+	}{
+		{
+			name: "vorbis",
+			data: oggPage(1, 0, vorbisIdentification(2, 44100)) + oggPage(1, 44100, strings.Repeat("a", 600)) +
+				oggPage(1, 66150, strings.Repeat("b", 300)),
+			want: &MediaInfoV2{
+				Duration:      1500 * time.Millisecond,
+				SampleRate:    Rational{Num: 44100, Den: 1},
+				AudioCodec:    "vorbis",
+				Channels:      2,
+				ChannelLayout: "stereo",
+			},
+		},
+		{
+			// the pre-skip is not played, pages without a finished packet & of other streams are skipped
+			name: "opus",
+			data: oggPage(7, 0, "OpusHead\x01\x02"+le16(312)+le32(44100)+"\x00\x00\x00") +
+				oggPage(7, 96312, "abcd") + oggPage(7, -1, "efgh") + oggPage(8, 999999, "ijkl"),
+			want: &MediaInfoV2{
+				Duration:      2 * time.Second,
+				SampleRate:    Rational{Num: 48000, Den: 1},
+				AudioCodec:    "opus",
+				Channels:      2,
+				ChannelLayout: "stereo",
+			},
+		},
+		{
+			name: "last page crossing the tail",
+			data: crossing,
+			want: &MediaInfoV2{
+				Duration:      1500 * time.Millisecond,
+				SampleRate:    Rational{Num: 8000, Den: 1},
+				AudioCodec:    "vorbis",
+				Channels:      1,
+				ChannelLayout: "mono",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info, err := getOGGInfo(strings.NewReader(test.data), int64(len(test.data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(info, test.want) {
+				t.Errorf("got %s, want %s", info.ToString(), test.want.ToString())
+			}
+		})
+	}
+}
+
+func TestGetOGGInfoInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"not ogg", "fLaC\x00\x00\x00\x22"},
+		{"unknown codec", oggPage(1, 0, "\x80theora"+strings.Repeat("\x00", 40)) + oggPage(1, 100, "abcd")},
+		{"no identification", "OggS\x00\x02" + strings.Repeat("\x00", 21)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := getOGGInfo(strings.NewReader(test.data), int64(len(test.data))); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
+
+// mp3Frames frames of the 4 bytes header in size, the 1st one begins with the payload
+func mp3Frames(header string, size, count int, payload string) string {
+	frames := header + payload + strings.Repeat("\x00", size-len(header)-len(payload))
+	for i := 1; i < count; i++ {
+		frames += header + strings.Repeat("\x00", size-len(header))
+	}
+	return frames
+}
+
+func TestGetMP3Info(t *testing.T) {
+	const (
+		// MPEG1 layer III of 128kbps in 44100Hz, 417 bytes & 1152 samples per frame
+		stereo = "\xFF\xFB\x90\x00"
+		mono   = "\xFF\xFB\x90\xC0"
+		// MPEG2 layer III of 64kbps in 22050Hz, 208 bytes & 576 samples per frame
+		mpeg2 = "\xFF\xF3\x80\x00"
+	)
+	id3v2 := "ID3\x03\x00\x00\x00\x00\x00\x14" + strings.Repeat("\x00", 20)
+	id3v1 := "TAG" + strings.Repeat("\x00", 125)
+	tests := []struct {
+		name string
+		data string
+		want *MediaInfoV2
+	}{
+		{
+			name: "cbr after an ID3v2 tag & junk",
+			data: id3v2 + "\x00\x00\x00" + mp3Frames(stereo, 417, 10, "") + id3v1,
+			want: &MediaInfoV2{
+				Duration:      11520 * time.Second / 44100,
+				SampleRate:    Rational{Num: 44100, Den: 1},
+				AudioCodec:    "mp3",
+				Channels:      2,
+				ChannelLayout: "stereo",
+			},
+		},
+		{
+			name: "xing",
+			data: mp3Frames(stereo, 417, 3, strings.Repeat("\x00", 32)+"Xing\x00\x00\x00\x0F\x00\x00\x03\xE8"),
+			want: &MediaInfoV2{
+				Duration:      1000 * 1152 * time.Second / 44100,
+				SampleRate:    Rational{Num: 44100, Den: 1},
+				AudioCodec:    "mp3",
+				Channels:      2,
+				ChannelLayout: "stereo",
+			},
+		},
+		{
+			name: "info of mono",
+			data: mp3Frames(mono, 417, 3, strings.Repeat("\x00", 17)+"Info\x00\x00\x00\x01\x00\x00\x00\x64"),
+			want: &MediaInfoV2{
+				Duration:      100 * 1152 * time.Second / 44100,
+				SampleRate:    Rational{Num: 44100, Den: 1},
+				AudioCodec:    "mp3",
+				Channels:      1,
+				ChannelLayout: "mono",
+			},
+		},
+		{
+			name: "vbri",
+			data: mp3Frames(stereo, 417, 3, strings.Repeat("\x00", 32)+"VBRI\x00\x01\x00\x00\x00\x64"+
+				"\x00\x01\x00\x00\x00\x00\x01\xF4"),
+			want: &MediaInfoV2{
+				Duration:      500 * 1152 * time.Second / 44100,
+				SampleRate:    Rational{Num: 44100, Den: 1},
+				AudioCodec:    "mp3",
+				Channels:      2,
+				ChannelLayout: "stereo",
+			},
+		},
+		{
+			name: "mpeg2",
+			data: mp3Frames(mpeg2, 208, 5, ""),
+			want: &MediaInfoV2{
+				Duration:      5 * 576 * time.Second / 22050,
+				SampleRate:    Rational{Num: 22050, Den: 1},
+				AudioCodec:    "mp3",
+				Channels:      2,
+				ChannelLayout: "stereo",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info, err := getMP3Info(strings.NewReader(test.data), int64(len(test.data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(info, test.want) {
+				t.Errorf("got %s, want %s", info.ToString(), test.want.ToString())
+			}
+		})
+	}
+}
+
+func TestGetMP3InfoInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"no frame", strings.Repeat("\x00", 1000)},
+		{"only the ID3v2 tag", "ID3\x03\x00\x00\x00\x00\x00\x14" + strings.Repeat("\x00", 20)},
+		{"false sync", "\xFF\xFB\x90\x00" + strings.Repeat("\x00", 500)},
+		{"reserved version", mp3Frames("\xFF\xEB\x90\x00", 417, 3, "")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := getMP3Info(strings.NewReader(test.data), int64(len(test.data))); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
+
+// adtsFrames ADTS frames of LC profile in size, which carry blocks raw data blocks each
+func adtsFrames(sampleRateIndex, channels byte, size, blocks, count int) string {
+	header := []byte{0xFF, 0xF1, 1<<6 | sampleRateIndex<<2 | channels>>2, channels<<6 | byte(size>>11),
+		byte(size >> 3), byte(size)<<5 | 0x1F, 0xFC | byte(blocks-1)}
+	return strings.Repeat(string(header)+strings.Repeat("\x00", size-len(header)), count)
+}
+
+func TestGetAACInfo(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want *MediaInfoV2
+	}{
+		{
+			name: "stereo followed by junk",
+			data: adtsFrames(4, 2, 100, 1, 43) + "APETAGEX",
+			want: &MediaInfoV2{
+				Duration:      43 * 1024 * time.Second / 44100,
+				SampleRate:    Rational{Num: 44100, Den: 1},
+				AudioCodec:    "aac",
+				Channels:      2,
+				ChannelLayout: "stereo",
+			},
+		},
+		{
+			name: "7.1 of 2 raw data blocks per frame after an ID3v2 tag",
+			data: "ID3\x04\x00\x00\x00\x00\x00\x0A" + strings.Repeat("\x00", 10) + adtsFrames(3, 7, 300, 2, 10),
+			want: &MediaInfoV2{
+				Duration:      10 * 2048 * time.Second / 48000,
+				SampleRate:    Rational{Num: 48000, Den: 1},
+				AudioCodec:    "aac",
+				Channels:      8,
+				ChannelLayout: "7.1(wide)",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info, err := getAACInfo(strings.NewReader(test.data), int64(len(test.data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(info, test.want) {
+				t.Errorf("got %s, want %s", info.ToString(), test.want.ToString())
+			}
+		})
+	}
+}
+
+func TestGetAACInfoInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"not aac", "\xFF\xFB\x90\x00\x00\x00\x00\x00"},
+		{"reserved sample rate", adtsFrames(13, 2, 100, 1, 3)},
+		{"frame too short", "\xFF\xF1\x50\x80\x00\xDF\xFC\x00"},
+		{"truncated", "\xFF\xF1\x50"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := getAACInfo(strings.NewReader(test.data), int64(len(test.data))); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
@@ -0,0 +1,313 @@
+package mediashrink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+// ImageBackend probes images & makes null images, see NewShrinker for how it is chosen
+type ImageBackend interface {
+	// Name of the backend used in logs & errors
+	Name() string
+	// Available check if the tools of the backend are installed
+	Available() bool
+	ProbeImage(ctx context.Context, s *Shrinker, src Source) (*MediaInfoV2, error)
+	MakeNullImage(ctx context.Context, s *Shrinker, info *MediaInfoV2, sink Sink) error
+}
+
+// AudioBackend probes audios & makes silent audios
+type AudioBackend interface {
+	Name() string
+	Available() bool
+	ProbeAudio(ctx context.Context, s *Shrinker, src Source) (*MediaInfoV2, error)
+	MakeNullAudio(ctx context.Context, s *Shrinker, info *MediaInfoV2, sink Sink) error
+}
+
+// VideoBackend probes videos & makes null videos
+type VideoBackend interface {
+	Name() string
+	Available() bool
+	ProbeVideo(ctx context.Context, s *Shrinker, src Source) (*MediaInfoV2, error)
+	MakeNullVideo(ctx context.Context, s *Shrinker, info *MediaInfoV2, sink Sink) error
+}
+
+// WithImageBackend use backend for the images which can not be handled natively instead of the detected one,
+// it is tried after the pure Go backend & before ffmpeg
+func WithImageBackend(backend ImageBackend) Option {
+	return func(s *Shrinker) {
+		s.imageBackend = backend
+	}
+}
+
+// WithAudioBackend use backend for the audios which can not be handled natively instead of the detected one
+func WithAudioBackend(backend AudioBackend) Option {
+	return func(s *Shrinker) {
+		s.audioBackend = backend
+	}
+}
+
+// WithVideoBackend use backend for the videos which can not be handled natively instead of the detected one
+func WithVideoBackend(backend VideoBackend) Option {
+	return func(s *Shrinker) {
+		s.videoBackend = backend
+	}
+}
+
+// WithImageBackends try images with backends in order, instead of the default chain, see NewShrinker
+func WithImageBackends(backends ...ImageBackend) Option {
+	return func(s *Shrinker) {
+		s.imageChain = append([]ImageBackend{}, backends...)
+	}
+}
+
+// WithAudioBackends try audios with backends in order, instead of the default chain, see NewShrinker
+func WithAudioBackends(backends ...AudioBackend) Option {
+	return func(s *Shrinker) {
+		s.audioChain = append([]AudioBackend{}, backends...)
+	}
+}
+
+// WithVideoBackends try videos with backends in order, instead of the default chain, see NewShrinker
+func WithVideoBackends(backends ...VideoBackend) Option {
+	return func(s *Shrinker) {
+		s.videoChain = append([]VideoBackend{}, backends...)
+	}
+}
+
+// ImageBackend the image backend used by s
+func (s *Shrinker) ImageBackend() ImageBackend {
+	return s.imageBackend
+}
+
+// AudioBackend the audio backend used by s
+func (s *Shrinker) AudioBackend() AudioBackend {
+	return s.audioBackend
+}
+
+// VideoBackend the video backend used by s
+func (s *Shrinker) VideoBackend() VideoBackend {
+	return s.videoBackend
+}
+
+// ImageBackends the chain of image backends used by s
+func (s *Shrinker) ImageBackends() []ImageBackend {
+	return append([]ImageBackend{}, s.imageChain...)
+}
+
+// AudioBackends the chain of audio backends used by s
+func (s *Shrinker) AudioBackends() []AudioBackend {
+	return append([]AudioBackend{}, s.audioChain...)
+}
+
+// VideoBackends the chain of video backends used by s
+func (s *Shrinker) VideoBackends() []VideoBackend {
+	return append([]VideoBackend{}, s.videoChain...)
+}
+
+// defaultImageChain the pure Go backend, the image backend, then the ffmpeg of the video backend,
+// or the one in $PATH, which reads & writes most image formats as well
+func defaultImageChain(imageBackend ImageBackend, videoBackend VideoBackend) []ImageBackend {
+	chain := []ImageBackend{NativeImageBackend{}}
+	if _, native := imageBackend.(NativeImageBackend); !native {
+		chain = append(chain, imageBackend)
+	}
+	if _, ffmpeg := imageBackend.(FFmpegBackend); !ffmpeg {
+		if ffmpeg, ok := videoBackend.(FFmpegBackend); ok {
+			chain = append(chain, ffmpeg)
+		} else if ffmpeg := (FFmpegBackend{}); ffmpeg.Available() {
+			chain = append(chain, ffmpeg)
+		}
+	}
+	return chain
+}
+
+// tryBackends call attempt with each of the backends in order until one succeeds,
+// returns the name of the succeeded one, or the errors of all the backends joined.
+// the chain is stopped when ctx is done, or when a failed backend has written into the writer of sink
+func tryBackends[B interface{ Name() string }](ctx context.Context, s *Shrinker, what string, backends []B,
+	sink *Sink, attempt func(backend B) error) (string, error) {
+	if len(backends) == 0 {
+		return "", fmt.Errorf("%w: no backend to %s", ErrUnsupportedFormat, what)
+	}
+	var errs []error
+	for i, backend := range backends {
+		err := attempt(backend)
+		if err == nil {
+			if sink != nil && sink.backend != nil {
+				*sink.backend = backend.Name()
+			}
+			return backend.Name(), nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", backend.Name(), err))
+		if ctx.Err() != nil || (sink != nil && sink.dirty()) {
+			break
+		}
+		if i+1 < len(backends) {
+			s.logger.Printf("mediashrink: %s failed to %s with err %s, fallback to %s",
+				backend.Name(), what, err, backends[i+1].Name())
+		}
+	}
+	return "", errors.Join(errs...)
+}
+
+// detectImageBackend pick the first installed one of ImageMagick 7, ImageMagick 6, GraphicsMagick & libvips,
+// the pure Go backend is used when none of them is installed
+func detectImageBackend() ImageBackend {
+	for _, backend := range []ImageBackend{
+		ImageMagick7Backend{}, ImageMagick6Backend{}, GraphicsMagickBackend{}, VipsBackend{},
+	} {
+		if backend.Available() {
+			return backend
+		}
+	}
+	return NativeImageBackend{}
+}
+
+// detectAudioBackend use ffmpeg if installed, otherwise the pure Go backend
+func detectAudioBackend() AudioBackend {
+	if backend := (FFmpegBackend{}); backend.Available() {
+		return backend
+	}
+	return NativeAudioBackend{}
+}
+
+// detectVideoBackend use ffmpeg if installed, otherwise the pure Go backend
+func detectVideoBackend() VideoBackend {
+	if backend := (FFmpegBackend{}); backend.Available() {
+		return backend
+	}
+	return NativeVideoBackend{}
+}
+
+// lookPath check if all the commands are installed
+func lookPath(commands ...string) bool {
+	for _, command := range commands {
+		if _, err := exec.LookPath(command); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// orDefault return v, or def if v is empty
+func orDefault(v, def string) string {
+	if len(v) > 0 {
+		return v
+	}
+	return def
+}
+
+// FFmpegBackend probes with ffprobe & makes media with ffmpeg, empty commands are looked up in $PATH,
+// it reads & writes most image formats as well
+type FFmpegBackend struct {
+	FFMpeg  string
+	FFProbe string
+}
+
+// Name implements ImageBackend, AudioBackend & VideoBackend
+func (b FFmpegBackend) Name() string { return "ffmpeg" }
+
+// Available implements ImageBackend, AudioBackend & VideoBackend
+func (b FFmpegBackend) Available() bool { return lookPath(b.ffmpeg(), b.ffprobe()) }
+
+func (b FFmpegBackend) ffmpeg() string  { return orDefault(b.FFMpeg, "ffmpeg") }
+func (b FFmpegBackend) ffprobe() string { return orDefault(b.FFProbe, "ffprobe") }
+
+// NativeImageBackend probes & makes images in pure Go, for the formats supported natively only
+type NativeImageBackend struct{}
+
+// Name implements ImageBackend
+func (NativeImageBackend) Name() string { return "native" }
+
+// Available implements ImageBackend
+func (NativeImageBackend) Available() bool { return true }
+
+// ProbeImage implements ImageBackend
+func (NativeImageBackend) ProbeImage(ctx context.Context, s *Shrinker, src Source) (*MediaInfoV2, error) {
+	parser, exists := imageHeaderParsers[src.ext]
+	if !exists {
+		return nil, fmt.Errorf("%w %s to parse natively", ErrUnsupportedFormat, src.ext)
+	}
+	info, err := src.Parse(parser)
+	if err != nil {
+		return nil, err
+	} else if info.Width == 0 || info.Height == 0 {
+		return nil, fmt.Errorf("%w: no dimension in %s", ErrUnknownMediaType, src.Name())
+	}
+	return info, nil
+}
+
+// MakeNullImage implements ImageBackend
+func (NativeImageBackend) MakeNullImage(ctx context.Context, s *Shrinker, info *MediaInfoV2, sink Sink) error {
+	generator, exists := imageGenerators[info.Ext]
+	if !exists {
+		return fmt.Errorf("%w %s to generate natively", ErrUnsupportedFormat, info.Ext)
+	}
+	return sink.Generate(info, generator)
+}
+
+// NativeAudioBackend probes & makes audios in pure Go, for the formats supported natively only
+type NativeAudioBackend struct{}
+
+// Name implements AudioBackend
+func (NativeAudioBackend) Name() string { return "native" }
+
+// Available implements AudioBackend
+func (NativeAudioBackend) Available() bool { return true }
+
+// ProbeAudio implements AudioBackend
+func (NativeAudioBackend) ProbeAudio(ctx context.Context, s *Shrinker, src Source) (*MediaInfoV2, error) {
+	parser, exists := audioHeaderParsers[src.ext]
+	if !exists {
+		return nil, fmt.Errorf("%w %s to parse natively", ErrUnsupportedFormat, src.ext)
+	}
+	info, err := src.Parse(parser)
+	if err != nil {
+		return nil, err
+	} else if info.Duration == 0 {
+		return nil, fmt.Errorf("%w: no duration in %s", ErrUnknownMediaType, src.Name())
+	}
+	info.Width, info.Height = 0, 0
+	return info, nil
+}
+
+// MakeNullAudio implements AudioBackend
+func (NativeAudioBackend) MakeNullAudio(ctx context.Context, s *Shrinker, info *MediaInfoV2, sink Sink) error {
+	generator, exists := audioGenerators[info.Ext]
+	if !exists {
+		return fmt.Errorf("%w %s to generate natively", ErrUnsupportedFormat, info.Ext)
+	}
+	return sink.Generate(info, generator)
+}
+
+// NativeVideoBackend probes videos in pure Go, for the formats supported natively only,
+// videos can not be made natively
+type NativeVideoBackend struct{}
+
+// Name implements VideoBackend
+func (NativeVideoBackend) Name() string { return "native" }
+
+// Available implements VideoBackend
+func (NativeVideoBackend) Available() bool { return true }
+
+// ProbeVideo implements VideoBackend
+func (NativeVideoBackend) ProbeVideo(ctx context.Context, s *Shrinker, src Source) (*MediaInfoV2, error) {
+	parser, exists := videoHeaderParsers[src.ext]
+	if !exists {
+		return nil, fmt.Errorf("%w %s to parse natively", ErrUnsupportedFormat, src.ext)
+	}
+	info, err := src.Parse(parser)
+	if err != nil {
+		return nil, err
+	} else if info.Width == 0 || info.Height == 0 || info.Duration == 0 {
+		return nil, fmt.Errorf("%w: no dimension or duration in %s", ErrUnknownMediaType, src.Name())
+	}
+	return info, nil
+}
+
+// MakeNullVideo implements VideoBackend
+func (NativeVideoBackend) MakeNullVideo(ctx context.Context, s *Shrinker, info *MediaInfoV2, sink Sink) error {
+	return fmt.Errorf("%w %s to generate natively", ErrUnsupportedFormat, info.Ext)
+}
@@ -0,0 +1,294 @@
+package mediashrink
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeImageBackend probes every image in 16x16 & makes images of its name, calls are recorded into calls,
+// it fails with err if set
+type fakeImageBackend struct {
+	name  string
+	calls *[]string
+	err   error
+}
+
+// Name implements ImageBackend
+func (b fakeImageBackend) Name() string { return b.name }
+
+// Available implements ImageBackend
+func (b fakeImageBackend) Available() bool { return true }
+
+// ProbeImage implements ImageBackend
+func (b fakeImageBackend) ProbeImage(ctx context.Context, s *Shrinker, src Source) (*MediaInfoV2, error) {
+	*b.calls = append(*b.calls, "probe "+b.name)
+	if b.err != nil {
+		return nil, b.err
+	}
+	return &MediaInfoV2{Width: 16, Height: 16}, nil
+}
+
+// MakeNullImage implements ImageBackend
+func (b fakeImageBackend) MakeNullImage(ctx context.Context, s *Shrinker, info *MediaInfoV2, sink Sink) error {
+	*b.calls = append(*b.calls, "make "+b.name)
+	if b.err != nil {
+		return b.err
+	}
+	return sink.Generate(info, func(w io.Writer, info *MediaInfoV2) error {
+		_, err := io.WriteString(w, b.name)
+		return err
+	})
+}
+
+func TestWithCommandsBackends(t *testing.T) {
+	ffmpeg := FFmpegBackend{FFMpeg: "/opt/ffmpeg", FFProbe: "/opt/ffprobe"}
+	tests := []struct {
+		name     string
+		option   Option
+		image    ImageBackend
+		audio    AudioBackend
+		video    VideoBackend
+		detected bool // audios & videos are left to the detected backends
+	}{
+		{
+			name:     "imagemagick 7",
+			option:   WithCommands(&CommandNames{ImageMagicK: &ImageMagicKExec{Magick: "/opt/magick"}}),
+			image:    ImageMagick7Backend{Magick: "/opt/magick"},
+			detected: true,
+		},
+		{
+			name:     "imagemagick 6",
+			option:   WithCommands(&CommandNames{ImageMagicK: &ImageMagicKExec{Identify: "/opt/identify"}}),
+			image:    ImageMagick6Backend{Identify: "/opt/identify"},
+			detected: true,
+		},
+		{
+			name:   "ffmpeg",
+			option: WithCommands(&CommandNames{FFMPEG: &FFMPEGExec{FFMpeg: "/opt/ffmpeg", FFProbe: "/opt/ffprobe"}}),
+			image:  detectImageBackend(),
+			audio:  ffmpeg,
+			video:  ffmpeg,
+		},
+		{
+			name:   "backends",
+			option: WithVideoBackend(ffmpeg),
+			image:  detectImageBackend(),
+			audio:  detectAudioBackend(),
+			video:  ffmpeg,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := NewShrinker(test.option)
+			if test.detected {
+				test.audio, test.video = detectAudioBackend(), detectVideoBackend()
+			}
+			if s.ImageBackend() != test.image || s.AudioBackend() != test.audio || s.VideoBackend() != test.video {
+				t.Errorf("got %#v, %#v & %#v, want %#v, %#v & %#v", s.ImageBackend(), s.AudioBackend(),
+					s.VideoBackend(), test.image, test.audio, test.video)
+			}
+		})
+	}
+}
+
+func TestWithImageBackend(t *testing.T) {
+	var calls []string
+	s := NewShrinker(WithImageBackend(fakeImageBackend{name: "fake", calls: &calls}))
+	dir := t.TempDir()
+	ico := filepath.Join(dir, "icon.ico")
+	if err := os.WriteFile(ico, []byte("\x00\x00\x01\x00\x01\x00\x20\x40"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// ico is probed natively but made by the backend
+	info, err := s.GetMediaInfo("123456", false, ico)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Width != 32 || info.Height != 64 || len(calls) != 0 {
+		t.Errorf("got %s with calls %q, want 32x64 probed natively", info.ToString(), calls)
+	}
+	output := filepath.Join(dir, "shrunk.ico")
+	if err := s.Shrink(info, output); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(output); err != nil || string(data) != "fake" {
+		t.Errorf("got %q with err %v, want the ico made by the backend", data, err)
+	}
+
+	// the images failed to parse natively are probed by the backend
+	if err := os.WriteFile(ico, []byte("not an ico"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := s.GetMediaInfo("123456", false, ico); err != nil || info.Width != 16 || info.Height != 16 {
+		t.Errorf("got %v with err %v, want 16x16 probed by the backend", info, err)
+	}
+	if want := []string{"make fake", "probe fake"}; len(calls) != 2 || calls[0] != want[0] || calls[1] != want[1] {
+		t.Errorf("got calls %q, want %q", calls, want)
+	}
+
+	// nothing makes ico with the pure Go backend only
+	s = NewShrinker(WithImageBackends(NativeImageBackend{}))
+	if err := s.Shrink(info, output); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("got err %v, want %v", err, ErrUnsupportedFormat)
+	}
+}
+
+func TestCallBackendDirectly(t *testing.T) {
+	ctx, s, backend := context.Background(), NewShrinker(), NativeImageBackend{}
+	path := writePNG(t, t.TempDir(), 40, 30)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sources := []Source{NewFileSource(path, "png"), NewReaderSource(bytes.NewReader(data), int64(len(data)), "png")}
+	for _, src := range sources {
+		info, err := backend.ProbeImage(ctx, s, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Width != 40 || info.Height != 30 || src.Ext() != "png" {
+			t.Errorf("got %dx%d of %s, want 40x30", info.Width, info.Height, src.Name())
+		}
+	}
+	if src := NewFileSource(path, "png"); src.Path() != path || src.Name() != path {
+		t.Errorf("got path %s & name %s, want %s", src.Path(), src.Name(), path)
+	}
+
+	info := &MediaInfoV2{Width: 40, Height: 30, Signature: "abcdef", Ext: "png"}
+	var buf bytes.Buffer
+	if err := backend.MakeNullImage(ctx, s, info, NewWriterSink(&buf)); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(t.TempDir(), "shrunk.png")
+	sink := NewFileSink(output)
+	if err := backend.MakeNullImage(ctx, s, info, sink); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(sink.Path()); err != nil || !bytes.Equal(data, buf.Bytes()) {
+		t.Errorf("got %d bytes with err %v in the file, want the %d bytes written", len(data), err, buf.Len())
+	}
+}
+
+func TestTryBackends(t *testing.T) {
+	errMissing, errFailed := errors.New("missing"), errors.New("failed")
+	var calls []string
+	first := fakeImageBackend{name: "first", calls: &calls, err: errMissing}
+	second := fakeImageBackend{name: "second", calls: &calls, err: errFailed}
+	third := fakeImageBackend{name: "third", calls: &calls}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		backends []ImageBackend
+		sink     Sink
+		want     string
+		calls    []string
+		errs     []error
+	}{
+		{
+			name:     "fallback",
+			backends: []ImageBackend{first, second, third},
+			want:     "third",
+			calls:    []string{"make first", "make second", "make third"},
+		},
+		{
+			name:     "all failed",
+			backends: []ImageBackend{first, second},
+			calls:    []string{"make first", "make second"},
+			errs:     []error{errMissing, errFailed},
+		},
+		{
+			name:     "no backend",
+			backends: nil,
+			errs:     []error{ErrUnsupportedFormat},
+		},
+		{
+			name:     "canceled",
+			ctx:      canceled,
+			backends: []ImageBackend{first, third},
+			calls:    []string{"make first"},
+			errs:     []error{errMissing},
+		},
+		{
+			// a failed backend which has written into the writer is not followed by another one
+			name:     "dirty sink",
+			backends: []ImageBackend{fakeImageBackend{name: "dirty", calls: &calls, err: errFailed}, third},
+			sink:     Sink{w: &countingWriter{w: io.Discard, n: 1}},
+			calls:    []string{"make dirty"},
+			errs:     []error{errFailed},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls = nil
+			ctx := test.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			if test.sink.w == nil {
+				test.sink = NewWriterSink(io.Discard)
+			}
+			var logs bytes.Buffer
+			s := NewShrinker(WithLogger(log.New(&logs, "", 0)))
+			backend := ""
+			test.sink.backend = &backend
+			name, err := tryBackends(ctx, s, "make", test.backends, &test.sink, func(backend ImageBackend) error {
+				return backend.MakeNullImage(ctx, s, &MediaInfoV2{Ext: "png"}, test.sink)
+			})
+			if name != test.want || backend != test.want || !reflect.DeepEqual(calls, test.calls) {
+				t.Errorf("got %q recorded %q with calls %q, want %q with calls %q",
+					name, backend, calls, test.want, test.calls)
+			}
+			if (err == nil) != (len(test.errs) == 0) {
+				t.Fatalf("got err %v, want %v", err, test.errs)
+			}
+			for _, target := range test.errs {
+				if !errors.Is(err, target) {
+					t.Errorf("got err %v, want %v joined", err, target)
+				}
+			}
+			// every backend but the last called logs its fallback
+			wantFallbacks := 0
+			if len(test.calls) > 0 {
+				wantFallbacks = len(test.calls) - 1
+			}
+			if fallbacks := strings.Count(logs.String(), "fallback to"); fallbacks != wantFallbacks {
+				t.Errorf("got %d fallbacks logged, want %d: %s", fallbacks, wantFallbacks, logs.String())
+			}
+		})
+	}
+}
+
+func TestWithImageBackends(t *testing.T) {
+	var calls []string
+	fake := fakeImageBackend{name: "fake", calls: &calls}
+	ffmpeg := FFmpegBackend{FFMpeg: "/opt/ffmpeg", FFProbe: "/opt/ffprobe"}
+	s := NewShrinker(WithImageBackend(fake), WithVideoBackend(ffmpeg))
+	if want := []ImageBackend{NativeImageBackend{}, fake, ffmpeg}; !reflect.DeepEqual(s.ImageBackends(), want) {
+		t.Errorf("got the default chain %#v, want %#v", s.ImageBackends(), want)
+	}
+
+	failed := fakeImageBackend{name: "failed", calls: &calls, err: errors.New("failed")}
+	s = NewShrinker(WithImageBackends(failed, fake))
+	s.ImageBackends()[0] = fake // a copy of the chain is returned
+	output := filepath.Join(t.TempDir(), "shrunk.ico")
+	if err := s.ShrinkV2(&MediaInfoV2{Width: 4, Height: 4, Signature: "abcdef", Ext: "ico"}, output); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(output); err != nil || string(data) != "fake" {
+		t.Errorf("got %q with err %v, want the image made by fake", data, err)
+	}
+	if want := []string{"make failed", "make fake"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("got calls %q, want %q", calls, want)
+	}
+}
@@ -0,0 +1,115 @@
+package mediashrink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// initialDurationCorrection ffmpeg DTS delay time, which is subtracted from the target duration
+// before the output of a (ffmpeg version, container) is measured
+const initialDurationCorrection = 11 * time.Millisecond
+
+// maxCalibrationAttempts times a media is made at most to approach its target duration
+const maxCalibrationAttempts = 3
+
+// calibrationKey media made by the same ffmpeg version in the same container drift the same
+type calibrationKey struct {
+	version string
+	kind    string
+	ext     string
+}
+
+// duration corrections measured, shared by all the Shrinkers
+var calibrations = struct {
+	sync.Mutex
+	corrections map[calibrationKey]time.Duration
+	versions    map[string]string // ffmpeg command -> version
+}{corrections: map[calibrationKey]time.Duration{}, versions: map[string]string{}}
+
+// version get the version of ffmpeg, such as "4.4.2-0ubuntu0.22.04.1", empty if unknown
+func (b FFmpegBackend) version(ctx context.Context, s *Shrinker) string {
+	calibrations.Lock()
+	version, exists := calibrations.versions[b.ffmpeg()]
+	calibrations.Unlock()
+	if exists {
+		return version
+	}
+	// ffmpeg version 4.4.2-0ubuntu0.22.04.1 Copyright (c) 2000-2021 the FFmpeg developers
+	// the version stays unknown if ffmpeg fails, which is not asked again
+	if output, err := s.command(ctx, b.ffmpeg(), "-version").Output(); err == nil {
+		firstLine := output
+		if i := bytes.IndexByte(output, '\n'); i >= 0 {
+			firstLine = output[:i]
+		}
+		if fields := strings.Fields(string(firstLine)); len(fields) >= 3 && fields[1] == "version" {
+			version = fields[2]
+		}
+	}
+	calibrations.Lock()
+	calibrations.versions[b.ffmpeg()] = version
+	calibrations.Unlock()
+	return version
+}
+
+// calibrate call makeMedia with the duration correction cached for the ffmpeg version & the container of outputPath,
+// which is trusted unless s verifies the output, see WithVerify. Otherwise the output is measured with ffprobe,
+// the correction is updated and the media is made again when the duration is off by more than the tolerance of s
+func (b FFmpegBackend) calibrate(ctx context.Context, s *Shrinker, kind, outputPath string, target time.Duration,
+	makeMedia func(correction time.Duration) error) error {
+	key := calibrationKey{
+		version: b.version(ctx, s),
+		kind:    kind,
+		ext:     strings.ToLower(strings.TrimPrefix(filepath.Ext(outputPath), ".")),
+	}
+	calibrations.Lock()
+	correction, exists := calibrations.corrections[key]
+	calibrations.Unlock()
+	if exists && !s.verify {
+		return makeMedia(correction)
+	} else if !exists {
+		correction = initialDurationCorrection
+	}
+
+	tolerance := s.durationTolerance()
+	for attempt := 1; ; attempt++ {
+		if err := makeMedia(correction); err != nil {
+			return err
+		}
+		actual, err := b.getDuration(ctx, s, outputPath)
+		if err != nil {
+			// the media is made, only its duration is unknown
+			s.logger.Printf("mediashrink: unable to calibrate duration of %s with err %s", outputPath, err)
+			return nil
+		}
+		margin := actual - target
+		if margin >= -tolerance && margin <= tolerance {
+			break
+		}
+		correction += margin
+		if attempt == maxCalibrationAttempts {
+			s.logger.Printf("mediashrink: duration of %s is still off by %s after %d attempts",
+				outputPath, margin, attempt)
+			break
+		}
+		s.logger.Printf("mediashrink: duration of %s is off by %s, make it again with correction %s",
+			outputPath, margin, correction)
+	}
+	calibrations.Lock()
+	calibrations.corrections[key] = correction
+	calibrations.Unlock()
+	return nil
+}
+
+// durationArg format target minus correction in secs for ffmpeg, which is never less than 1ms
+func durationArg(target time.Duration, correction time.Duration) string {
+	d := target - correction
+	if d < time.Millisecond {
+		d = time.Millisecond
+	}
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
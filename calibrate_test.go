@@ -0,0 +1,23 @@
+package mediashrink
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDurationArg(t *testing.T) {
+	tests := []struct {
+		target, correction time.Duration
+		want               string
+	}{
+		{5 * time.Second, initialDurationCorrection, "4.989"},
+		{5 * time.Second, -40 * time.Millisecond, "5.040"},
+		{1500*time.Millisecond + 400*time.Microsecond, 0, "1.500"},
+		{5 * time.Millisecond, initialDurationCorrection, "0.001"},
+	}
+	for _, test := range tests {
+		if got := durationArg(test.target, test.correction); got != test.want {
+			t.Errorf("got %s of %s corrected by %s, want %s", got, test.target, test.correction, test.want)
+		}
+	}
+}
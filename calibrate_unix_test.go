@@ -0,0 +1,128 @@
+//go:build unix
+
+package mediashrink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeScript write an executable shell script of name into dir
+func writeScript(t *testing.T, dir, name, script string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// fakeCalibrationBackend an ffmpeg of version & an ffprobe printing the content of the file probed,
+// which is the duration in secs written by fakeMakeMedia
+func fakeCalibrationBackend(t *testing.T, version string) FFmpegBackend {
+	lookPathOrSkip(t, "sh")
+	dir := t.TempDir()
+	return FFmpegBackend{
+		FFMpeg:  writeScript(t, dir, "ffmpeg", "echo 'ffmpeg version "+version+" Copyright (c) 2000-2021'\n"),
+		FFProbe: writeScript(t, dir, "ffprobe", "for a in \"$@\"; do last=\"$a\"; done\ncat \"$last\"\n"),
+	}
+}
+
+// fakeMakeMedia a makeMedia of calibrate writing the duration of target corrected & drifted by drift of attempts
+// into path, the corrections used are recorded
+func fakeMakeMedia(path string, target time.Duration, drift func(attempt int) time.Duration,
+	corrections *[]time.Duration) func(correction time.Duration) error {
+	return func(correction time.Duration) error {
+		*corrections = append(*corrections, correction)
+		d := target - correction + drift(len(*corrections))
+		return os.WriteFile(path, []byte(fmt.Sprintf("%.6f\n", d.Seconds())), 0644)
+	}
+}
+
+func TestCalibrate(t *testing.T) {
+	ctx, target := context.Background(), 5*time.Second
+	backend := fakeCalibrationBackend(t, "9.9-calibrate")
+	output := filepath.Join(t.TempDir(), "silence.calibrate")
+	constant := func(int) time.Duration { return 40 * time.Millisecond }
+	var logs strings.Builder
+	s := NewShrinker(WithVerify(10*time.Millisecond), WithLogger(log.New(&logs, "", 0)))
+
+	// the output is measured & made again with the margin corrected
+	var corrections []time.Duration
+	if err := backend.calibrate(ctx, s, "audio", output, target, fakeMakeMedia(output, target, constant,
+		&corrections)); err != nil {
+		t.Fatal(err)
+	}
+	if want := []time.Duration{initialDurationCorrection, 40 * time.Millisecond}; !reflect.DeepEqual(corrections, want) {
+		t.Errorf("got corrections %v, want %v", corrections, want)
+	}
+	if !strings.Contains(logs.String(), "is off by 29ms, make it again with correction 40ms") {
+		t.Errorf("got logs %s, want the margin logged", logs.String())
+	}
+
+	// the correction cached for the version & container is trusted without verifying
+	corrections = nil
+	noProbe := FFmpegBackend{FFMpeg: backend.FFMpeg, FFProbe: filepath.Join(t.TempDir(), "missing")}
+	if err := noProbe.calibrate(ctx, NewShrinker(), "audio", output, target, fakeMakeMedia(output, target, constant,
+		&corrections)); err != nil {
+		t.Fatal(err)
+	}
+	if want := []time.Duration{40 * time.Millisecond}; !reflect.DeepEqual(corrections, want) {
+		t.Errorf("got corrections %v, want %v", corrections, want)
+	}
+
+	// a media of unknown duration is kept uncalibrated
+	corrections, logs = nil, strings.Builder{}
+	s = NewShrinker(WithVerify(10*time.Millisecond), WithLogger(log.New(&logs, "", 0)))
+	if err := noProbe.calibrate(ctx, s, "video", output, target, fakeMakeMedia(output, target, constant,
+		&corrections)); err != nil {
+		t.Fatal(err)
+	}
+	if len(corrections) != 1 || !strings.Contains(logs.String(), "unable to calibrate duration of "+output) {
+		t.Errorf("got corrections %v & logs %s, want made once", corrections, logs.String())
+	}
+
+	// the attempts are limited
+	corrections, logs = nil, strings.Builder{}
+	growing := func(attempt int) time.Duration { return time.Duration(attempt) * 100 * time.Millisecond }
+	if err := backend.calibrate(ctx, s, "audio", output+"2", target, fakeMakeMedia(output+"2", target, growing,
+		&corrections)); err != nil {
+		t.Fatal(err)
+	}
+	if len(corrections) != maxCalibrationAttempts || !strings.Contains(logs.String(), "after 3 attempts") {
+		t.Errorf("got corrections %v & logs %s, want %d attempts", corrections, logs.String(), maxCalibrationAttempts)
+	}
+
+	errMake := errors.New("encoder not found")
+	err := backend.calibrate(ctx, s, "audio", output, target, func(time.Duration) error { return errMake })
+	if !errors.Is(err, errMake) {
+		t.Errorf("got err %v, want %v", err, errMake)
+	}
+}
+
+func TestFFmpegVersion(t *testing.T) {
+	ctx, s := context.Background(), NewShrinker()
+	const want = "4.4.2-0ubuntu0.22.04.1"
+	if version := fakeCalibrationBackend(t, want).version(ctx, s); version != want {
+		t.Errorf("got version %q, want %q", version, want)
+	}
+	// the version of a failed ffmpeg stays unknown
+	missing := FFmpegBackend{FFMpeg: filepath.Join(t.TempDir(), "missing")}
+	if version := missing.version(ctx, s); version != "" {
+		t.Errorf("got version %q, want it unknown", version)
+	}
+	calibrations.Lock()
+	version, exists := calibrations.versions[missing.FFMpeg]
+	calibrations.Unlock()
+	if !exists || version != "" {
+		t.Errorf("got version %q cached %t, want it cached unknown", version, exists)
+	}
+}
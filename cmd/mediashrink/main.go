@@ -0,0 +1,382 @@
+// Command mediashrink walks a directory tree and replaces every supported
+// image, audio and video file with a tiny placeholder of the same dimension & duration.
+//
+//	mediashrink -in ./fixtures -out ./fixtures-shrunk
+//	mediashrink -in ./fixtures -inplace
+//	mediashrink -in ./fixtures -out ./fixtures-shrunk -archives
+//	mediashrink -check -json
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cszichao/mediashrink"
+)
+
+// summary counts the results of a shrink run
+type summary struct {
+	shrunk     int
+	skipped    int
+	failed     int
+	bytesSaved int64
+}
+
+// fail count & report a file failed with err
+func (s *summary) fail(path string, err error) {
+	s.failed++
+	fmt.Fprintf(os.Stderr, "failed %s: %s\n", path, err)
+}
+
+func main() {
+	var (
+		inputDir  = flag.String("in", "", "input directory to walk")
+		outputDir = flag.String("out", "", "output directory mirroring the input tree")
+		inPlace   = flag.Bool("inplace", false, "shrink files in place instead of writing to -out")
+		guessExt  = flag.Bool("guess", false, "guess the media type of files without extension")
+		archives  = flag.Bool("archives", false, "shrink media inside zip, 7z and rar archives as well")
+		verbose   = flag.Bool("v", false, "print every processed file")
+		tempDir   = flag.String("tmp", "", "directory for intermediate files")
+		timeout   = flag.Duration("timeout", 0, "kill external commands running longer than this, e.g. 5m, 0 for no timeout")
+		verify    = flag.Duration("verify", 0, "probe every shrunk media again, fail if its duration is off by more than this")
+		check     = flag.Bool("check", false, "check every media type can be made & read back, exit 1 if any fails")
+		jsonOut   = flag.Bool("json", false, "print the report of -check in JSON")
+		commands  = mediashrink.DefaultCommandNames()
+
+		imageBackend = flag.String("image-backend", "auto",
+			"backend for images: auto, native, imagemagick7, imagemagick6, graphicsmagick or vips")
+		avBackend = flag.String("av-backend", "auto", "backend for audios & videos: auto, native or ffmpeg")
+		rotation  = flag.String("rotation", "metadata",
+			"how rotated videos are made: metadata keeps the rotation, prerotated makes them in the display dimension")
+		orientation = flag.String("orientation", "metadata",
+			"how images in an EXIF orientation are made: metadata keeps it, prerotated makes them in the display dimension")
+		gm       = flag.String("gm", "gm", "path of GraphicsMagick gm")
+		vips     = flag.String("vips", "vips", "path of libvips vips")
+		vipsHead = flag.String("vipsheader", "vipsheader", "path of libvips vipsheader")
+	)
+	flag.StringVar(&commands.FFMPEG.FFMpeg, "ffmpeg", commands.FFMPEG.FFMpeg, "path of ffmpeg")
+	flag.StringVar(&commands.FFMPEG.FFProbe, "ffprobe", commands.FFMPEG.FFProbe, "path of ffprobe")
+	flag.StringVar(&commands.ImageMagicK.Identify, "identify", commands.ImageMagicK.Identify, "path of ImageMagick identify")
+	flag.StringVar(&commands.ImageMagicK.Convert, "convert", commands.ImageMagicK.Convert, "path of ImageMagick convert")
+	flag.StringVar(&commands.ImageMagicK.Magick, "magick", "", "path of ImageMagick 7 magick, used instead of identify & convert")
+	flag.StringVar(&commands.P7Zip.P7z, "7z", commands.P7Zip.P7z, "path of 7z")
+	flag.Parse()
+	isSet := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { isSet[f.Name] = true })
+
+	if !*check && len(*inputDir) == 0 {
+		fmt.Fprintln(os.Stderr, "mediashrink: -in is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if !*check && *inPlace == (len(*outputDir) > 0) {
+		fmt.Fprintln(os.Stderr, "mediashrink: exactly one of -out and -inplace is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var backends []mediashrink.Option
+	switch *imageBackend {
+	case "auto":
+	case "native":
+		backends = append(backends, mediashrink.WithImageBackend(mediashrink.NativeImageBackend{}))
+	case "imagemagick7":
+		backends = append(backends, mediashrink.WithImageBackend(mediashrink.ImageMagick7Backend{
+			Magick: commands.ImageMagicK.Magick}))
+	case "imagemagick6":
+		backends = append(backends, mediashrink.WithImageBackend(mediashrink.ImageMagick6Backend{
+			Identify: commands.ImageMagicK.Identify, Convert: commands.ImageMagicK.Convert}))
+	case "graphicsmagick":
+		backends = append(backends, mediashrink.WithImageBackend(mediashrink.GraphicsMagickBackend{GM: *gm}))
+	case "vips":
+		backends = append(backends, mediashrink.WithImageBackend(mediashrink.VipsBackend{
+			Vips: *vips, VipsHeader: *vipsHead}))
+	default:
+		fmt.Fprintln(os.Stderr, "mediashrink: unknown -image-backend", *imageBackend)
+		flag.Usage()
+		os.Exit(2)
+	}
+	switch *avBackend {
+	case "auto":
+	case "native":
+		backends = append(backends, mediashrink.WithAudioBackend(mediashrink.NativeAudioBackend{}),
+			mediashrink.WithVideoBackend(mediashrink.NativeVideoBackend{}))
+	case "ffmpeg":
+		backend := mediashrink.FFmpegBackend{FFMpeg: commands.FFMPEG.FFMpeg, FFProbe: commands.FFMPEG.FFProbe}
+		backends = append(backends, mediashrink.WithAudioBackend(backend), mediashrink.WithVideoBackend(backend))
+	default:
+		fmt.Fprintln(os.Stderr, "mediashrink: unknown -av-backend", *avBackend)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	switch *rotation {
+	case "metadata":
+	case "prerotated":
+		backends = append(backends, mediashrink.WithRotationMode(mediashrink.RotationPreRotated))
+	default:
+		fmt.Fprintln(os.Stderr, "mediashrink: unknown -rotation", *rotation)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var shrinkOptions []mediashrink.ShrinkOption
+	switch *orientation {
+	case "metadata":
+	case "prerotated":
+		shrinkOptions = append(shrinkOptions, mediashrink.WithOrientationMode(mediashrink.OrientationPreRotated))
+	default:
+		fmt.Fprintln(os.Stderr, "mediashrink: unknown -orientation", *orientation)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// only the commands given are passed, so the others are detected
+	if !isSet["ffmpeg"] && !isSet["ffprobe"] {
+		commands.FFMPEG = nil
+	}
+	if !isSet["identify"] && !isSet["convert"] && !isSet["magick"] {
+		commands.ImageMagicK = nil
+	}
+	options := []mediashrink.Option{mediashrink.WithCommands(commands), mediashrink.WithTempDir(*tempDir),
+		mediashrink.WithTimeout(*timeout)}
+	if isSet["verify"] {
+		options = append(options, mediashrink.WithVerify(*verify))
+	}
+	options = append(options, backends...)
+	if *verbose {
+		options = append(options, mediashrink.WithLogger(log.New(os.Stderr, "", log.LstdFlags)))
+	}
+	shrinker := mediashrink.NewShrinker(options...)
+
+	if *check {
+		if err := checkCompatibility(shrinker, *tempDir, *jsonOut); err != nil {
+			fmt.Fprintln(os.Stderr, "mediashrink:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	s := &summary{}
+	if err := shrinkTree(shrinker, shrinkOptions, *inputDir, *outputDir, *inPlace, *guessExt, *archives, *verbose,
+		s); err != nil {
+		fmt.Fprintln(os.Stderr, "mediashrink:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("shrunk: %d, skipped: %d, failed: %d, bytes saved: %d\n",
+		s.shrunk, s.skipped, s.failed, s.bytesSaved)
+	if s.failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// checkCompatibility generates & reads back a sample of every media type in a temp dir of tempDir,
+// prints the report and returns an error if any media type fails
+func checkCompatibility(shrinker *mediashrink.Shrinker, tempDir string, jsonOut bool) error {
+	exportDir, err := ioutil.TempDir(tempDir, "mediashrink-check")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(exportDir)
+	report := shrinker.CheckCompatibility(nil, exportDir)
+	if jsonOut {
+		err = report.WriteJSON(os.Stdout)
+	} else {
+		err = report.WriteText(os.Stdout)
+	}
+	if err != nil {
+		return err
+	}
+	if failed := report.Failed(); len(failed) > 0 {
+		return fmt.Errorf("%d of %d media types failed the check", len(failed), len(report.Results))
+	}
+	return nil
+}
+
+// shrinkTree walks inputDir and shrinks every supported media into outputDir,
+// or into the file itself when inPlace is set, the other files are copied into outputDir as they are.
+// failed files are reported, counted in s & copied as well instead of stopping the walk
+func shrinkTree(shrinker *mediashrink.Shrinker, options []mediashrink.ShrinkOption, inputDir, outputDir string,
+	inPlace, guessExt, archives, verbose bool, s *summary) error {
+	var err error
+	if inputDir, err = filepath.Abs(inputDir); err != nil {
+		return err
+	}
+	if !inPlace {
+		if outputDir, err = filepath.Abs(outputDir); err != nil {
+			return err
+		}
+	}
+	return filepath.Walk(inputDir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			s.fail(path, err)
+			if fi != nil && fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fi.IsDir() {
+			// never walk into the output tree when it is nested in the input
+			if !inPlace && path == outputDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		outputPath := path
+		if !inPlace {
+			rel, err := filepath.Rel(inputDir, path)
+			if err != nil {
+				s.fail(path, err)
+				return nil
+			}
+			outputPath = filepath.Join(outputDir, rel)
+		}
+		mirrorPath := outputPath
+		var saved int64
+		if archives && isArchive(path) {
+			outputPath = writableArchivePath(outputPath)
+			saved, err = shrinkArchive(shrinker, path, outputPath, guessExt)
+		} else {
+			saved, err = shrinkFile(shrinker, options, path, outputPath, guessExt)
+		}
+		// skipped & failed files are copied as they are, so the output tree mirrors the input one
+		if err != nil && !inPlace {
+			if copyErr := copyFile(path, mirrorPath); copyErr != nil {
+				err = fmt.Errorf("%s, failed copy it with err %w", err, copyErr)
+			}
+		}
+		switch {
+		case errors.Is(err, mediashrink.ErrUnknownMediaType):
+			s.skipped++
+			if verbose {
+				fmt.Printf("skipped %s\n", path)
+			}
+		case err != nil:
+			s.fail(path, err)
+		default:
+			s.shrunk++
+			s.bytesSaved += saved
+			if verbose {
+				fmt.Printf("shrunk %s -> %s (%d bytes saved)\n", path, outputPath, saved)
+			}
+		}
+		return nil
+	})
+}
+
+// shrinkFile shrinks a single file into outputPath configured by options, returns the bytes saved
+func shrinkFile(shrinker *mediashrink.Shrinker, options []mediashrink.ShrinkOption, path, outputPath string,
+	guessExt bool) (int64, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	info, err := shrinker.GetMediaInfoV2("", guessExt, path)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return 0, err
+	}
+	if err := shrinker.ShrinkV2(info, outputPath, options...); err != nil {
+		return 0, err
+	}
+	return bytesSaved(fi, outputPath)
+}
+
+// shrinkArchive shrinks media inside a single archive into outputPath, returns the bytes saved
+func shrinkArchive(shrinker *mediashrink.Shrinker, path, outputPath string, guessExt bool) (int64, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return 0, err
+	}
+	// an archive repacked in place into another format replaces the original one,
+	// but never overwrites another file
+	repackedInPlace := outputPath != path && filepath.Dir(outputPath) == filepath.Dir(path)
+	if repackedInPlace {
+		if _, err := os.Lstat(outputPath); err == nil {
+			return 0, fmt.Errorf("unable to repack %s into %s: file exists", path, outputPath)
+		}
+	}
+	if err := shrinker.ShrinkArchive(path, outputPath, guessExt); err != nil {
+		return 0, err
+	}
+	saved, err := bytesSaved(fi, outputPath)
+	if err != nil {
+		return 0, err
+	}
+	if repackedInPlace {
+		if err := os.Remove(path); err != nil {
+			return 0, err
+		}
+	}
+	return saved, nil
+}
+
+// writableArchivePath the path of the shrunk archive, archives which can not be written by 7z
+// such as rar are repacked into zip
+func writableArchivePath(outputPath string) string {
+	ext := strings.ToLower(filepath.Ext(outputPath))
+	if _, exists := mediashrink.WritableArchiveMatchers()[strings.TrimPrefix(ext, ".")]; exists {
+		return outputPath
+	}
+	return strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + ".zip"
+}
+
+// isArchive check if path is an archive by its ext
+func isArchive(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	if len(ext) <= 1 {
+		return false
+	}
+	_, exists := mediashrink.ArchiveMatchers()[ext[1:]]
+	return exists
+}
+
+// copyFile copy path into outputPath with the same permission, the dir of outputPath is made if missing
+func copyFile(path, outputPath string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	fi, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return err
+	}
+	dst, err := os.OpenFile(outputPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// bytesSaved size of the original file minus the shrunk one
+func bytesSaved(original os.FileInfo, outputPath string) (int64, error) {
+	shrunk, err := os.Stat(outputPath)
+	if err != nil {
+		return 0, err
+	}
+	return original.Size() - shrunk.Size(), nil
+}
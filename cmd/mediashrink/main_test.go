@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cszichao/mediashrink"
+)
+
+// writeTree write files of the relative paths into dir
+func writeTree(t *testing.T, dir string, files map[string][]byte) {
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// pngOf encode an image of width x height in PNG, which has noise to be larger than its placeholder
+func pngOf(t *testing.T, width, height int) []byte {
+	m := image.NewGray(image.Rect(0, 0, width, height))
+	for i := range m.Pix {
+		m.Pix[i] = byte(i * 7919 % 251)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, m); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestShrinkTree(t *testing.T) {
+	shrinker := mediashrink.NewShrinker(mediashrink.WithImageBackends(mediashrink.NativeImageBackend{}))
+	files := map[string][]byte{
+		"a.png":          pngOf(t, 64, 48),
+		"photos/b.png":   pngOf(t, 30, 40),
+		"notes.txt":      []byte("not a media"),
+		"photos/bad.png": []byte("not a png either"),
+	}
+	probe := func(path string) *mediashrink.MediaInfoV2 {
+		info, err := shrinker.GetMediaInfoV2("123456", false, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info
+	}
+
+	t.Run("out", func(t *testing.T) {
+		inputDir := t.TempDir()
+		writeTree(t, inputDir, files)
+		// the output tree nested in the input one is never walked
+		outputDir := filepath.Join(inputDir, "shrunk")
+		s := &summary{}
+		if err := shrinkTree(shrinker, nil, inputDir, outputDir, false, false, false, false, s); err != nil {
+			t.Fatal(err)
+		}
+		if s.shrunk != 2 || s.skipped != 1 || s.failed != 1 || s.bytesSaved <= 0 {
+			t.Errorf("got %+v, want 2 shrunk, 1 skipped & 1 failed", *s)
+		}
+		for name, data := range files {
+			output, err := os.ReadFile(filepath.Join(outputDir, filepath.FromSlash(name)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if shrunk := filepath.Ext(name) == ".png" && name != "photos/bad.png"; shrunk == bytes.Equal(output, data) {
+				t.Errorf("got %s shrunk %t, want %t", name, !shrunk, shrunk)
+			}
+		}
+		if info := probe(filepath.Join(outputDir, "photos", "b.png")); info.Width != 30 || info.Height != 40 {
+			t.Errorf("got photos/b.png in %dx%d, want 30x40", info.Width, info.Height)
+		}
+	})
+
+	t.Run("inplace", func(t *testing.T) {
+		inputDir := t.TempDir()
+		writeTree(t, inputDir, files)
+		s := &summary{}
+		if err := shrinkTree(shrinker, nil, inputDir, "", true, false, false, false, s); err != nil {
+			t.Fatal(err)
+		}
+		if s.shrunk != 2 || s.skipped != 1 || s.failed != 1 {
+			t.Errorf("got %+v, want 2 shrunk, 1 skipped & 1 failed", *s)
+		}
+		fi, err := os.Stat(filepath.Join(inputDir, "a.png"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Size() >= int64(len(files["a.png"])) {
+			t.Errorf("got a.png in %d bytes, want less than %d", fi.Size(), len(files["a.png"]))
+		}
+		if info := probe(filepath.Join(inputDir, "a.png")); info.Width != 64 || info.Height != 48 {
+			t.Errorf("got a.png in %dx%d, want 64x48", info.Width, info.Height)
+		}
+	})
+}
@@ -0,0 +1,399 @@
+package mediashrink
+
+import (
+	"encoding/binary"
+	"strconv"
+	"strings"
+)
+
+// mp4VideoCodecs ffmpeg codec names of the video sample entries in MP4 / MOV
+var mp4VideoCodecs = map[string]string{
+	"avc1": "h264",
+	"avc3": "h264",
+	"hvc1": "hevc",
+	"hev1": "hevc",
+	"av01": "av1",
+	"vp09": "vp9",
+	"vp08": "vp8",
+	"mp4v": "mpeg4",
+	"s263": "h263",
+	"jpeg": "mjpeg",
+	"apco": "prores",
+	"apcs": "prores",
+	"apcn": "prores",
+	"apch": "prores",
+	"ap4h": "prores",
+	"ap4x": "prores",
+}
+
+// mp4VideoConfigs the codec configuration boxes in the video sample entries of the codecs
+var mp4VideoConfigs = map[string]string{
+	"h264": "avcC",
+	"hevc": "hvcC",
+	"av1":  "av1C",
+	"vp9":  "vpcC",
+}
+
+// mkvVideoCodecs ffmpeg codec names of the video CodecIDs in Matroska
+var mkvVideoCodecs = map[string]string{
+	"V_MPEG4/ISO/AVC":  "h264",
+	"V_MPEGH/ISO/HEVC": "hevc",
+	"V_AV1":            "av1",
+	"V_VP9":            "vp9",
+	"V_VP8":            "vp8",
+	"V_MPEG4/ISO/ASP":  "mpeg4",
+	"V_MPEG4/ISO/SP":   "mpeg4",
+	"V_MPEG2":          "mpeg2video",
+	"V_MPEG1":          "mpeg1video",
+	"V_THEORA":         "theora",
+	"V_PRORES":         "prores",
+	"V_MJPEG":          "mjpeg",
+}
+
+// videoConfigParsers get profile & pixel format from the codec configurations, which are the same in
+// the configuration boxes of MP4 and the CodecPrivate of Matroska except for VP9
+var videoConfigParsers = map[string]func(config []byte) (string, string){
+	"h264": parseAVCConfig,
+	"hevc": parseHEVCConfig,
+	"av1":  parseAV1Config,
+}
+
+// proresProfiles ffprobe names of the ProRes profiles in the fourcc of MP4 / MOV,
+// all but 4444 & 4444 XQ are in 4:2:2 10-bit
+var proresProfiles = map[string]string{
+	"apco": "Proxy",
+	"apcs": "LT",
+	"apcn": "Standard",
+	"apch": "HQ",
+	"ap4h": "4444",
+	"ap4x": "4444XQ",
+}
+
+// h264Profiles ffprobe names of the H.264 profile_idc
+var h264Profiles = map[byte]string{
+	44:  "CAVLC 4:4:4",
+	66:  "Baseline",
+	77:  "Main",
+	88:  "Extended",
+	100: "High",
+	110: "High 10",
+	122: "High 4:2:2",
+	244: "High 4:4:4 Predictive",
+}
+
+// hevcProfiles ffprobe names of the HEVC general_profile_idc
+var hevcProfiles = map[byte]string{
+	1: "Main",
+	2: "Main 10",
+	3: "Main Still Picture",
+	4: "Rext",
+	9: "SCC",
+}
+
+// av1Profiles ffprobe names of the AV1 seq_profile
+var av1Profiles = map[byte]string{
+	0: "Main",
+	1: "High",
+	2: "Professional",
+}
+
+// chromaFormats chroma subsampling of the chroma_format_idc in H.264 & HEVC
+var chromaFormats = [4]string{"gray", "420", "422", "444"}
+
+// pixelFormat the ffmpeg pixel format of planar YUV in the chroma subsampling of 420, 422, 444 or gray
+// in bitDepth, like yuv420p & yuv420p10le, empty if unknown
+func pixelFormat(chroma string, bitDepth int) string {
+	if bitDepth != 8 && bitDepth != 10 && bitDepth != 12 {
+		return ""
+	}
+	name := "yuv" + chroma + "p"
+	if chroma == "gray" {
+		name = "gray"
+	}
+	if bitDepth > 8 {
+		name += strconv.Itoa(bitDepth) + "le"
+	}
+	return name
+}
+
+// parseAVCConfig get profile & pixel format from an AVCDecoderConfigurationRecord, the pixel format is known
+// for the profiles in 4:2:0 8-bit & 10-bit only unless the chroma format & bit depth are presented
+func parseAVCConfig(config []byte) (string, string) {
+	// version(1) profile_idc(1) constraint_flags(1) level_idc(1) length_size(1)
+	// sps_count(1) sps_count & 0x1F * (length(2) sps) pps_count(1) pps_count * (length(2) pps)
+	// [chroma_format(1) bit_depth_luma_minus8(1) ...] in High profiles
+	if len(config) < 6 {
+		return "", ""
+	}
+	profileIDC, constraints := config[1], config[2]
+	profile, pixFmt := h264Profiles[profileIDC], ""
+	switch profileIDC {
+	case 66:
+		if constraints&0x40 != 0 { // constraint_set1_flag
+			profile = "Constrained Baseline"
+		}
+		pixFmt = "yuv420p"
+	case 77, 88, 100:
+		pixFmt = "yuv420p"
+	case 110, 122, 244:
+		if constraints&0x10 != 0 { // constraint_set3_flag
+			profile = map[byte]string{110: "High 10 Intra", 122: "High 4:2:2 Intra", 244: "High 4:4:4 Intra"}[profileIDC]
+		}
+		if profileIDC == 110 {
+			pixFmt = "yuv420p10le"
+		}
+	}
+
+	// skip the SPS & PPS lists
+	offset, count := 6, int(config[5]&0x1F)
+	skip := func() bool {
+		for i := 0; i < count; i++ {
+			if offset+2 > len(config) {
+				return false
+			}
+			offset += 2 + int(binary.BigEndian.Uint16(config[offset:offset+2]))
+		}
+		return offset < len(config)
+	}
+	if !skip() {
+		return profile, pixFmt
+	}
+	count = int(config[offset])
+	offset++
+	if !skip() {
+		return profile, pixFmt
+	}
+	if offset+2 <= len(config) && (profileIDC == 100 || profileIDC == 110 || profileIDC == 122 || profileIDC == 244) {
+		pixFmt = pixelFormat(chromaFormats[config[offset]&3], int(config[offset+1]&7)+8)
+	}
+	return profile, pixFmt
+}
+
+// parseHEVCConfig get profile & pixel format from a HEVCDecoderConfigurationRecord
+func parseHEVCConfig(config []byte) (string, string) {
+	// version(1) profile_space(2) tier(1) profile_idc(5) compatibility_flags(4) constraint_flags(6) level_idc(1)
+	// min_spatial_segmentation(2) parallelism_type(1) chroma_format(1) bit_depth_luma_minus8(1) ...
+	if len(config) < 18 {
+		return "", ""
+	}
+	return hevcProfiles[config[1]&0x1F], pixelFormat(chromaFormats[config[16]&3], int(config[17]&7)+8)
+}
+
+// parseAV1Config get profile & pixel format from an AV1CodecConfigurationRecord
+func parseAV1Config(config []byte) (string, string) {
+	// marker & version(1) seq_profile(3) seq_level_idx(5)
+	// tier(1) high_bitdepth(1) twelve_bit(1) monochrome(1) subsampling_x(1) subsampling_y(1) sample_position(2)
+	if len(config) < 3 {
+		return "", ""
+	}
+	seqProfile, flags := config[1]>>5, config[2]
+	bitDepth := 8
+	if flags&0x40 != 0 {
+		bitDepth = 10
+		if seqProfile == 2 && flags&0x20 != 0 {
+			bitDepth = 12
+		}
+	}
+	chroma := "444"
+	switch {
+	case flags&0x10 != 0:
+		chroma = "gray"
+	case flags&0x08 != 0 && flags&0x04 != 0:
+		chroma = "420"
+	case flags&0x08 != 0:
+		chroma = "422"
+	}
+	return av1Profiles[seqProfile], pixelFormat(chroma, bitDepth)
+}
+
+// parseVP9Config get profile & pixel format from the vpcC box of MP4
+func parseVP9Config(config []byte) (string, string) {
+	// version(1) flags(3) profile(1) level(1) bit_depth(4) chroma_subsampling(3) full_range(1)
+	if len(config) < 7 {
+		return "", ""
+	}
+	return vp9Profile(config[4]), vp9PixelFormat(int(config[6]>>4), config[6]>>1&7)
+}
+
+// parseVP9CodecPrivate get profile & pixel format from the CodecPrivate of VP9 in Matroska,
+// which is a list of id(1) length(1) value features
+func parseVP9CodecPrivate(private []byte) (string, string) {
+	features := map[byte]byte{}
+	for offset := 0; offset+2 < len(private); offset += 2 + int(private[offset+1]) {
+		if private[offset+1] == 1 {
+			features[private[offset]] = private[offset+2]
+		}
+	}
+	profile, pixFmt := "", ""
+	if p, exists := features[1]; exists {
+		profile = vp9Profile(p)
+	}
+	if bitDepth, exists := features[3]; exists {
+		pixFmt = vp9PixelFormat(int(bitDepth), features[4])
+	}
+	return profile, pixFmt
+}
+
+// vp9Profile ffprobe name of the VP9 profile
+func vp9Profile(profile byte) string {
+	if profile > 3 {
+		return ""
+	}
+	return "Profile " + strconv.Itoa(int(profile))
+}
+
+// vp9PixelFormat the pixel format of the VP9 bit depth & chroma subsampling,
+// which is 0 & 1 for 4:2:0, 2 for 4:2:2 and 3 for 4:4:4
+func vp9PixelFormat(bitDepth int, chromaSubsampling byte) string {
+	chroma := "420"
+	switch chromaSubsampling {
+	case 2:
+		chroma = "422"
+	case 3:
+		chroma = "444"
+	}
+	return pixelFormat(chroma, bitDepth)
+}
+
+// mp4AudioCodecs ffmpeg codec names of the audio sample entries in MP4 / MOV
+var mp4AudioCodecs = map[string]string{
+	"mp4a": "aac",
+	"alac": "alac",
+	"ac-3": "ac3",
+	"ec-3": "eac3",
+	"Opus": "opus",
+	"fLaC": "flac",
+	".mp3": "mp3",
+	"samr": "amr_nb",
+	"sawb": "amr_wb",
+	"ulaw": "pcm_mulaw",
+	"alaw": "pcm_alaw",
+	"sowt": "pcm_s16le",
+	"twos": "pcm_s16be",
+	"in24": "pcm_s24be",
+	"in32": "pcm_s32be",
+	"fl32": "pcm_f32be",
+	"fl64": "pcm_f64be",
+}
+
+// mkvAudioCodecs ffmpeg codec names of the audio CodecIDs in Matroska, the PCM ones are named by pcmCodec
+var mkvAudioCodecs = map[string]string{
+	"A_AAC":      "aac",
+	"A_AC3":      "ac3",
+	"A_EAC3":     "eac3",
+	"A_DTS":      "dts",
+	"A_TRUEHD":   "truehd",
+	"A_OPUS":     "opus",
+	"A_VORBIS":   "vorbis",
+	"A_FLAC":     "flac",
+	"A_ALAC":     "alac",
+	"A_MPEG/L3":  "mp3",
+	"A_MPEG/L2":  "mp2",
+	"A_MPEG/L1":  "mp1",
+	"A_TTA1":     "tta",
+	"A_WAVPACK4": "wavpack",
+}
+
+// wavFormatCodecs ffmpeg codec names of the compressed format tags in WAV, the PCM ones are named by pcmCodec
+var wavFormatCodecs = map[uint16]string{
+	0x0002: "adpcm_ms",
+	0x0006: "pcm_alaw",
+	0x0007: "pcm_mulaw",
+	0x0011: "adpcm_ima_wav",
+	0x0050: "mp2",
+	0x0055: "mp3",
+}
+
+// losslessAudioCodecs codecs whose bit depth is kept, the lossy ones are decoded in float without a bit depth
+var losslessAudioCodecs = map[string]bool{
+	"flac":    true,
+	"alac":    true,
+	"truehd":  true,
+	"tta":     true,
+	"wavpack": true,
+}
+
+// vorbisChannelLayouts ffmpeg channel layouts of 1 to 8 channels in the channel order of flac, vorbis & opus
+var vorbisChannelLayouts = []string{"mono", "stereo", "3.0", "quad", "5.0", "5.1", "6.1", "7.1"}
+
+// aacChannelLayouts ffmpeg channel layouts of the aac channel configurations by channels
+var aacChannelLayouts = map[int]string{1: "mono", 2: "stereo", 3: "3.0", 4: "4.0", 5: "5.0", 6: "5.1", 8: "7.1(wide)"}
+
+// wavChannelMasks the WAVE_FORMAT_EXTENSIBLE channel masks of the ffmpeg channel layouts
+var wavChannelMasks = map[string]uint32{
+	"mono":           0x004,
+	"stereo":         0x003,
+	"2.1":            0x00B,
+	"3.0":            0x007,
+	"3.0(back)":      0x103,
+	"3.1":            0x00F,
+	"4.0":            0x107,
+	"quad":           0x033,
+	"quad(side)":     0x603,
+	"4.1":            0x10F,
+	"5.0":            0x037,
+	"5.0(side)":      0x607,
+	"5.1":            0x03F,
+	"5.1(side)":      0x60F,
+	"6.0":            0x707,
+	"6.1":            0x70F,
+	"7.0":            0x637,
+	"7.1":            0x63F,
+	"7.1(wide)":      0x0FF,
+	"7.1(wide-side)": 0x6CF,
+}
+
+// pcmCodec the ffmpeg PCM codec of samples in bits, which are unsigned in 8 bits, empty if unknown
+func pcmCodec(bits int, float, bigEndian bool) string {
+	endian := "le"
+	if bigEndian {
+		endian = "be"
+	}
+	switch {
+	case float && (bits == 32 || bits == 64):
+		return "pcm_f" + strconv.Itoa(bits) + endian
+	case float:
+		return ""
+	case bits == 8:
+		return "pcm_u8"
+	case bits == 16 || bits == 24 || bits == 32:
+		return "pcm_s" + strconv.Itoa(bits) + endian
+	}
+	return ""
+}
+
+// hasBitDepth check if the bit depth of codec is kept, which is for PCM & the lossless codecs only
+func hasBitDepth(codec string) bool {
+	return losslessAudioCodecs[codec] || strings.HasPrefix(codec, "pcm_")
+}
+
+// defaultChannelLayout the ffmpeg channel layout of channels in the default channel order of codec,
+// empty if unknown
+func defaultChannelLayout(codec string, channels int) string {
+	switch codec {
+	case "aac":
+		return aacChannelLayouts[channels]
+	case "flac", "vorbis", "opus":
+		if channels >= 1 && channels <= len(vorbisChannelLayouts) {
+			return vorbisChannelLayouts[channels-1]
+		}
+		return ""
+	}
+	switch channels {
+	case 1:
+		return "mono"
+	case 2:
+		return "stereo"
+	}
+	return ""
+}
+
+// wavChannelLayout the ffmpeg channel layout of a WAVE_FORMAT_EXTENSIBLE channel mask, empty if unknown
+func wavChannelLayout(mask uint32) string {
+	for layout, m := range wavChannelMasks {
+		if m == mask {
+			return layout
+		}
+	}
+	return ""
+}
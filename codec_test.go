@@ -0,0 +1,148 @@
+package mediashrink
+
+import "testing"
+
+// avcConfig an AVCDecoderConfigurationRecord of profileIDC with an SPS & a PPS followed by ext
+func avcConfig(profileIDC, constraints byte, ext ...byte) []byte {
+	sps, pps := []byte{0x67, profileIDC, constraints, 40, 1, 2, 3}, []byte{0x68, 1, 2}
+	config := []byte{1, profileIDC, constraints, 40, 0xFF, 0xE1, 0, byte(len(sps))}
+	config = append(append(config, sps...), 1, 0, byte(len(pps)))
+	return append(append(config, pps...), ext...)
+}
+
+func TestParseVideoConfig(t *testing.T) {
+	hevcConfig := func(profileIDC, chroma, bitDepth byte) []byte {
+		config := make([]byte, 23)
+		config[1], config[16], config[17] = profileIDC, 0xFC|chroma, 0xF8|(bitDepth-8)
+		return config
+	}
+	tests := []struct {
+		name            string
+		parse           func(config []byte) (string, string)
+		config          []byte
+		profile, pixFmt string
+	}{
+		{"avc high", parseAVCConfig, avcConfig(100, 0), "High", "yuv420p"},
+		{"avc high with chroma", parseAVCConfig, avcConfig(100, 0, 0xFD, 0xF8, 0xF8, 0), "High", "yuv420p"},
+		{"avc high 10", parseAVCConfig, avcConfig(110, 0, 0xFD, 0xFA, 0xFA, 0), "High 10", "yuv420p10le"},
+		{"avc high 10 intra", parseAVCConfig, avcConfig(110, 0x10), "High 10 Intra", "yuv420p10le"},
+		{"avc high 4:2:2 intra", parseAVCConfig, avcConfig(122, 0x10, 0xFE, 0xFA, 0xFA, 0), "High 4:2:2 Intra",
+			"yuv422p10le"},
+		{"avc constrained baseline", parseAVCConfig, avcConfig(66, 0x40), "Constrained Baseline", "yuv420p"},
+		{"avc main", parseAVCConfig, avcConfig(77, 0), "Main", "yuv420p"},
+		// the chroma format of High 4:4:4 is unknown without the extension
+		{"avc high 4:4:4", parseAVCConfig, avcConfig(244, 0), "High 4:4:4 Predictive", ""},
+		{"avc truncated sps", parseAVCConfig, avcConfig(100, 0)[:10], "High", "yuv420p"},
+		{"avc too short", parseAVCConfig, []byte{1, 100}, "", ""},
+		{"hevc main", parseHEVCConfig, hevcConfig(1, 1, 8), "Main", "yuv420p"},
+		{"hevc main 10", parseHEVCConfig, hevcConfig(2, 1, 10), "Main 10", "yuv420p10le"},
+		{"hevc rext 4:2:2", parseHEVCConfig, hevcConfig(4, 2, 12), "Rext", "yuv422p12le"},
+		{"hevc too short", parseHEVCConfig, make([]byte, 17), "", ""},
+		{"av1 main 10-bit", parseAV1Config, []byte{0x81, 0x08, 0x4C, 0}, "Main", "yuv420p10le"},
+		{"av1 high 4:4:4", parseAV1Config, []byte{0x81, 0x28, 0x00, 0}, "High", "yuv444p"},
+		{"av1 professional 12-bit 4:2:2", parseAV1Config, []byte{0x81, 0x48, 0x68, 0}, "Professional",
+			"yuv422p12le"},
+		{"av1 monochrome", parseAV1Config, []byte{0x81, 0x08, 0x1C, 0}, "Main", "gray"},
+		{"av1 too short", parseAV1Config, []byte{0x81, 0x08}, "", ""},
+		{"vp9 profile 0", parseVP9Config, []byte{1, 0, 0, 0, 0, 10, 0x82, 0}, "Profile 0", "yuv420p"},
+		{"vp9 profile 2", parseVP9Config, []byte{1, 0, 0, 0, 2, 10, 0xA2, 0}, "Profile 2", "yuv420p10le"},
+		{"vp9 profile 1 4:4:4", parseVP9Config, []byte{1, 0, 0, 0, 1, 10, 0x86, 0}, "Profile 1", "yuv444p"},
+		{"vp9 too short", parseVP9Config, []byte{1, 0, 0, 0, 0, 10}, "", ""},
+		{"vp9 private", parseVP9CodecPrivate, []byte{1, 1, 2, 2, 1, 10, 3, 1, 10, 4, 1, 1}, "Profile 2",
+			"yuv420p10le"},
+		{"vp9 private without bit depth", parseVP9CodecPrivate, []byte{1, 1, 0}, "Profile 0", ""},
+		{"vp9 private empty", parseVP9CodecPrivate, nil, "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if profile, pixFmt := test.parse(test.config); profile != test.profile || pixFmt != test.pixFmt {
+				t.Errorf("got %q in %q, want %q in %q", profile, pixFmt, test.profile, test.pixFmt)
+			}
+		})
+	}
+}
+
+func TestPixelFormat(t *testing.T) {
+	tests := []struct {
+		chroma   string
+		bitDepth int
+		want     string
+	}{
+		{"420", 8, "yuv420p"},
+		{"422", 10, "yuv422p10le"},
+		{"444", 12, "yuv444p12le"},
+		{"gray", 8, "gray"},
+		{"gray", 10, "gray10le"},
+		{"420", 9, ""},
+		{"420", 16, ""},
+	}
+	for _, test := range tests {
+		if got := pixelFormat(test.chroma, test.bitDepth); got != test.want {
+			t.Errorf("got %q of %s in %d bits, want %q", got, test.chroma, test.bitDepth, test.want)
+		}
+	}
+}
+
+func TestPCMCodec(t *testing.T) {
+	tests := []struct {
+		bits             int
+		float, bigEndian bool
+		want             string
+	}{
+		{8, false, false, "pcm_u8"},
+		{16, false, false, "pcm_s16le"},
+		{24, false, true, "pcm_s24be"},
+		{32, false, false, "pcm_s32le"},
+		{32, true, false, "pcm_f32le"},
+		{64, true, true, "pcm_f64be"},
+		{16, true, false, ""},
+		{12, false, false, ""},
+		{0, false, false, ""},
+	}
+	for _, test := range tests {
+		if got := pcmCodec(test.bits, test.float, test.bigEndian); got != test.want {
+			t.Errorf("got %q of %d bits float %t big endian %t, want %q",
+				got, test.bits, test.float, test.bigEndian, test.want)
+		}
+	}
+	for codec, want := range map[string]bool{"pcm_s24le": true, "flac": true, "alac": true, "aac": false, "": false} {
+		if got := hasBitDepth(codec); got != want {
+			t.Errorf("got %t of %q, want %t", got, codec, want)
+		}
+	}
+}
+
+func TestDefaultChannelLayout(t *testing.T) {
+	tests := []struct {
+		codec    string
+		channels int
+		want     string
+	}{
+		{"aac", 1, "mono"},
+		{"aac", 6, "5.1"},
+		{"aac", 7, ""},
+		{"aac", 8, "7.1(wide)"},
+		{"flac", 3, "3.0"},
+		{"vorbis", 4, "quad"},
+		{"opus", 8, "7.1"},
+		{"opus", 9, ""},
+		{"opus", 0, ""},
+		{"mp3", 2, "stereo"},
+		{"pcm_s16le", 1, "mono"},
+		{"pcm_s16le", 6, ""},
+	}
+	for _, test := range tests {
+		if got := defaultChannelLayout(test.codec, test.channels); got != test.want {
+			t.Errorf("got %q of %d channels in %s, want %q", got, test.channels, test.codec, test.want)
+		}
+	}
+}
+
+func TestWAVChannelLayout(t *testing.T) {
+	for mask, want := range map[uint32]string{0x4: "mono", 0x3: "stereo", 0x3F: "5.1", 0x60F: "5.1(side)",
+		0x63F: "7.1", 0x0: "", 0x1: "", 0x3FFFF: ""} {
+		if got := wavChannelLayout(mask); got != want {
+			t.Errorf("got %q of %#x, want %q", got, mask, want)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package mediashrink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// CompatibilityReport results of generating & reading back a sample of every registered media type
+type CompatibilityReport struct {
+	Results []CompatibilityResult `json:"results"`
+}
+
+// CompatibilityResult result of a single media type in a CompatibilityReport
+type CompatibilityResult struct {
+	Ext            string       `json:"ext"`
+	Expected       *MediaInfoV2 `json:"expected"`
+	Generated      bool         `json:"generated"`
+	Backend        string       `json:"backend,omitempty"` // backend which made the sample, empty for custom generators
+	DetectedExt    string       `json:"detected_ext,omitempty"`
+	ExtMatch       bool         `json:"ext_match"` // the sample is detected as a media of Ext
+	Probed         *MediaInfoV2 `json:"probed,omitempty"`
+	DurationMargin int64        `json:"duration_margin_ms"` // probed duration minus the expected one in ms
+	DurationMatch  bool         `json:"duration_match"`     // the margin is within the verify tolerance
+	DimensionMatch bool         `json:"dimension_match"`
+	Error          string       `json:"error,omitempty"`
+	Err            error        `json:"-"`
+}
+
+// OK check if the sample is generated, detected as a media of its ext & probed in the expected dimension & duration
+func (r *CompatibilityResult) OK() bool {
+	return r.Err == nil && r.Generated && r.ExtMatch && r.DimensionMatch && r.DurationMatch
+}
+
+// OK check if all the media types are OK
+func (report *CompatibilityReport) OK() bool {
+	for i := range report.Results {
+		if !report.Results[i].OK() {
+			return false
+		}
+	}
+	return true
+}
+
+// Failed the results which are not OK
+func (report *CompatibilityReport) Failed() []CompatibilityResult {
+	var failed []CompatibilityResult
+	for _, r := range report.Results {
+		if !r.OK() {
+			failed = append(failed, r)
+		}
+	}
+	return failed
+}
+
+// WriteText print the report in lines readable by humans
+func (report *CompatibilityReport) WriteText(w io.Writer) error {
+	for _, r := range report.Results {
+		var err error
+		if r.Generated {
+			_, err = fmt.Fprintf(w, "generating %s: OKay with %s\n", r.Ext, r.Backend)
+		} else {
+			_, err = fmt.Fprintf(w, "generating %s: Failed with error %s\n", r.Ext, r.Error)
+		}
+		if err != nil {
+			return err
+		} else if !r.Generated {
+			continue
+		}
+		if _, err = fmt.Fprintf(w, "guessing ext of %s: %s, ext match: %t\n",
+			r.Ext, r.DetectedExt, r.ExtMatch); err != nil {
+			return err
+		}
+		if r.Probed == nil {
+			_, err = fmt.Fprintf(w, "reading %s: Failed with error %s\n", r.Ext, r.Error)
+		} else {
+			_, err = fmt.Fprintf(w, "reading %s: Okay: %s with %s, dimension match: %t, duration margin: %d ms, "+
+				"duration match: %t\n", r.Ext, r.Probed.ToString(), r.Probed.Backend, r.DimensionMatch,
+				r.DurationMargin, r.DurationMatch)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteJSON write the report in indented JSON
+func (report *CompatibilityReport) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// CheckCompatibility check compatibility of ImageMagicK and FFMpeg, see Shrinker.CheckCompatibility
+func CheckCompatibility(output io.Writer, exportDir string) *CompatibilityReport {
+	return defaultShrinker().CheckCompatibility(output, exportDir)
+}
+
+// CheckCompatibility check compatibility of the backends used by s, a sample of every registered media type
+// is generated into exportDir then read back, the report is printed into output in text if output is not nil,
+// failing to print is logged, call WriteText of the report to get the error instead
+func (s *Shrinker) CheckCompatibility(output io.Writer, exportDir string) *CompatibilityReport {
+	report := s.CheckCompatibilityContext(context.Background(), exportDir)
+	if output != nil {
+		if err := report.WriteText(output); err != nil {
+			s.logger.Printf("mediashrink: failed print the compatibility report with err %s", err)
+		}
+	}
+	return report
+}
+
+// CheckCompatibilityContext is like CheckCompatibility but kills the external commands when ctx is done
+func (s *Shrinker) CheckCompatibilityContext(ctx context.Context, exportDir string) *CompatibilityReport {
+	duration := 5000
+	samples := map[MediaKind]string{
+		KindImage: "32x32x0x123456.",
+		KindAudio: "0x0x" + strconv.Itoa(duration) + "x123456.",
+		KindVideo: "128x128x" + strconv.Itoa(duration) + "x123456.",
+	}
+	report := &CompatibilityReport{}
+	for _, kind := range []MediaKind{KindImage, KindAudio, KindVideo} {
+		for _, t := range mediaTypes(kind) {
+			r := CompatibilityResult{Ext: t.ext}
+			r.Expected, r.Err = ParseMediaInfo(samples[kind] + t.ext)
+			if r.Err == nil {
+				r.Err = s.checkSample(ctx, &r, exportDir)
+			}
+			if r.Err != nil {
+				r.Error = r.Err.Error()
+			}
+			report.Results = append(report.Results, r)
+		}
+	}
+	return report
+}
+
+// checkSample generate a sample of r.Expected into exportDir & read it back
+func (s *Shrinker) checkSample(ctx context.Context, r *CompatibilityResult, exportDir string) error {
+	sample := filepath.Join(exportDir, "shrink."+r.Ext)
+	defer os.Remove(sample)
+	if err := s.makeNullMedia(ctx, r.Expected, Sink{path: sample, backend: &r.Backend}); err != nil {
+		return err
+	} else if _, err := os.Stat(sample); err != nil {
+		return fmt.Errorf("%w %s", ErrUnsupportedFormat, r.Expected.ToString())
+	}
+	r.Generated = true
+	// media types sharing a container like mp4 & m4a are guessed as the first one, so the sample matches its ext
+	// if it is guessed as it or matched by the matcher of its ext
+	t, _ := lookupMediaType(r.Ext)
+	readFileHeader(sample, func(header []byte, err error) error {
+		if err == nil {
+			r.DetectedExt = guessExtFromHeader(header)
+			r.ExtMatch = r.DetectedExt == r.Ext || (t != nil && t.matcher != nil && t.matcher(header))
+		}
+		return err
+	})
+
+	info, err := s.getMediaInfo(ctx, "", false, sample, true)
+	if err != nil {
+		return err
+	}
+	r.Probed = info
+	r.DimensionMatch = info.Width == r.Expected.Width && info.Height == r.Expected.Height
+	if r.Expected.Duration == 0 {
+		r.DurationMatch = true
+	} else if info.Duration > 0 {
+		// a duration probed as 0 is unknown, which leaves the margin unset & never matches
+		margin := info.Duration - r.Expected.Duration
+		r.DurationMargin = margin.Milliseconds()
+		r.DurationMatch = margin >= -s.durationTolerance() && margin <= s.durationTolerance()
+	}
+	return nil
+}
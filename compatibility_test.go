@@ -0,0 +1,74 @@
+package mediashrink
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+)
+
+// failingWriter fails every write
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestCheckCompatibility(t *testing.T) {
+	var logs bytes.Buffer
+	s := NewShrinker(WithImageBackends(NativeImageBackend{}), WithAudioBackends(NativeAudioBackend{}),
+		WithVideoBackends(NativeVideoBackend{}), WithLogger(log.New(&logs, "", 0)))
+	var text bytes.Buffer
+	report := s.CheckCompatibility(&text, t.TempDir())
+	results := map[string]CompatibilityResult{}
+	for _, r := range report.Results {
+		results[r.Ext] = r
+	}
+
+	// images & audios made natively are read back, videos can not be made natively
+	for _, ext := range []string{"png", "jpg", "gif", "bmp", "tiff", "wav", "flac"} {
+		if r := results[ext]; !r.OK() || r.Backend != "native" {
+			t.Errorf("got %s %+v, want it OK with native", ext, r)
+		}
+	}
+	if r := results["wav"]; r.Probed == nil || r.Probed.Duration.Milliseconds() != 5000 || r.DurationMargin != 0 {
+		t.Errorf("got wav %+v, want it probed in 5000 ms", r)
+	}
+	for _, ext := range []string{"ico", "mp4", "mp3"} {
+		if r := results[ext]; r.OK() || r.Generated || !errors.Is(r.Err, ErrUnsupportedFormat) || len(r.Error) == 0 {
+			t.Errorf("got %s %+v, want it not generated", ext, r)
+		}
+	}
+	if report.OK() || len(report.Failed()) == 0 || len(report.Failed()) >= len(report.Results) {
+		t.Errorf("got %d of %d failed, want some of them", len(report.Failed()), len(report.Results))
+	}
+
+	for _, line := range []string{
+		"generating png: OKay with native\n",
+		"guessing ext of png: png, ext match: true\n",
+		"generating mp4: Failed with error ",
+	} {
+		if !strings.Contains(text.String(), line) {
+			t.Errorf("got report %s, want %q in it", text.String(), line)
+		}
+	}
+	var buf bytes.Buffer
+	if err := report.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded CompatibilityReport
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil || len(decoded.Results) != len(report.Results) {
+		t.Errorf("got %d results with err %v, want %d", len(decoded.Results), err, len(report.Results))
+	}
+
+	// failing to print is logged, & returned by WriteText
+	s.CheckCompatibility(failingWriter{}, t.TempDir())
+	if !strings.Contains(logs.String(), "failed print the compatibility report with err disk full") {
+		t.Errorf("got logs %s, want the failure of printing", logs.String())
+	}
+	if err := report.WriteText(failingWriter{}); err == nil {
+		t.Error("got no error of WriteText")
+	}
+}
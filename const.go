@@ -1,12 +1,10 @@
 package mediashrink
 
 import (
-	"errors"
-
 	"github.com/haxii/filetype/matchers"
 )
 
-// support media types
+// built-in media types, registered by registerBuiltinMediaTypes
 var (
 	image = map[string]matchers.Matcher{
 		matchers.TypeJpeg.Extension: matchers.Jpeg,
@@ -19,7 +17,29 @@ var (
 		matchers.TypeBmp.Extension:  matchers.Bmp,
 		matchers.TypeIco.Extension:  matchers.Ico,
 	}
-	imagePNG = map[string]matchers.Matcher{matchers.TypePng.Extension: matchers.Png}
+	// images whose dimension can be parsed from file header without identify
+	imageHeaderParsers = map[string]headerParser{
+		matchers.TypeJpeg.Extension: getImageJPEGInfo,
+		matchers.TypeJpe.Extension:  getImageJPEGInfo,
+		matchers.TypeJpg.Extension:  getImageJPEGInfo,
+		matchers.TypePng.Extension:  getImagePNGInfo,
+		matchers.TypeGif.Extension:  getImageGIFInfo,
+		matchers.TypeTif.Extension:  getImageTIFFInfo,
+		matchers.TypeTiff.Extension: getImageTIFFInfo,
+		matchers.TypeBmp.Extension:  getImageBMPInfo,
+		matchers.TypeIco.Extension:  getImageICOInfo,
+	}
+	// images which can be generated natively without convert
+	imageGenerators = map[string]imageGenerator{
+		matchers.TypeJpeg.Extension: makeNullJPEG,
+		matchers.TypeJpe.Extension:  makeNullJPEG,
+		matchers.TypeJpg.Extension:  makeNullJPEG,
+		matchers.TypePng.Extension:  makeNullPNG,
+		matchers.TypeGif.Extension:  makeNullGIF,
+		matchers.TypeTif.Extension:  makeNullTIFF,
+		matchers.TypeTiff.Extension: makeNullTIFF,
+		matchers.TypeBmp.Extension:  makeNullBMP,
+	}
 
 	audio = map[string]matchers.Matcher{
 		matchers.TypeMp3.Extension:  matchers.Mp3,
@@ -31,11 +51,26 @@ var (
 		matchers.TypeWma.Extension:  matchers.Wma,
 		matchers.TypeCaf.Extension:  matchers.Caf,
 	}
+	// audios whose duration can be parsed natively without ffprobe
+	audioHeaderParsers = map[string]headerParser{
+		matchers.TypeMp3.Extension:  getMP3Info,
+		matchers.TypeM4a.Extension:  getMP4Info,
+		matchers.TypeOgg.Extension:  getOGGInfo,
+		matchers.TypeFlac.Extension: getFLACInfo,
+		matchers.TypeWav.Extension:  getWAVInfo,
+		matchers.TypeAac.Extension:  getAACInfo,
+	}
+	// audios which can be generated natively without ffmpeg
+	audioGenerators = map[string]audioGenerator{
+		matchers.TypeWav.Extension:  makeNullWAV,
+		matchers.TypeFlac.Extension: makeNullFLAC,
+	}
 
 	video = map[string]matchers.Matcher{
 		matchers.TypeMp4.Extension:  matchers.Mp4,
 		matchers.TypeM4v.Extension:  matchers.M4v,
 		matchers.TypeMkv.Extension:  matchers.Mkv,
+		matchers.TypeWebm.Extension: matchers.Webm,
 		matchers.TypeMov.Extension:  matchers.Mov,
 		matchers.TypeAvi.Extension:  matchers.Avi,
 		matchers.TypeWmv.Extension:  matchers.Wmv,
@@ -44,16 +79,42 @@ var (
 		matchers.TypeFlv.Extension:  matchers.Flv,
 		matchers.TypeAsf.Extension:  matchers.Asf,
 	}
-)
+	// videos whose dimension & duration can be parsed natively without ffprobe
+	videoHeaderParsers = map[string]headerParser{
+		matchers.TypeMp4.Extension:  getMP4Info,
+		matchers.TypeM4v.Extension:  getMP4Info,
+		matchers.TypeMov.Extension:  getMP4Info,
+		matchers.TypeMkv.Extension:  getMKVInfo,
+		matchers.TypeWebm.Extension: getMKVInfo,
+	}
+	// videos which can hold text subtitles, which are made in the default subtitle codec of the container
+	videoSubtitleContainers = map[string]bool{
+		matchers.TypeMp4.Extension:  true,
+		matchers.TypeM4v.Extension:  true,
+		matchers.TypeMov.Extension:  true,
+		matchers.TypeMkv.Extension:  true,
+		matchers.TypeWebm.Extension: true,
+	}
+	// videos which can hold a video stream & an audio stream at most
+	videoSingleStreamContainers = map[string]bool{
+		matchers.TypeFlv.Extension: true,
+	}
 
-var (
-	// ErrUnknownMediaType error message when the given file is not recognized as a shrinkable media type
-	ErrUnknownMediaType = errors.New("unknown media type")
+	archive = map[string]matchers.Matcher{
+		matchers.TypeZip.Extension: matchers.Zip,
+		matchers.Type7z.Extension:  matchers.SevenZ,
+		matchers.TypeRar.Extension: matchers.Rar,
+	}
+	// archives can be created by 7z
+	archiveWritable = map[string]matchers.Matcher{
+		matchers.TypeZip.Extension: matchers.Zip,
+		matchers.Type7z.Extension:  matchers.SevenZ,
+	}
 )
 
-var (
-	// commands used for exec by golang
-	commands = &CommandNames{
+// DefaultCommandNames commands used for exec by default, which are looked up in $PATH
+func DefaultCommandNames() *CommandNames {
+	return &CommandNames{
 		ImageMagicK: &ImageMagicKExec{
 			Identify: "identify",
 			Convert:  "convert",
@@ -66,7 +127,7 @@ var (
 			P7z: "7z",
 		},
 	}
-)
+}
 
 // CommandNames for exec
 type CommandNames struct {
@@ -85,6 +146,9 @@ type FFMPEGExec struct {
 type ImageMagicKExec struct {
 	Identify string
 	Convert  string
+	// Magick the single binary of ImageMagick 7, when set, it is run as `magick identify` & `magick`
+	// instead of Identify & Convert
+	Magick string
 }
 
 // P7ZipExec ...
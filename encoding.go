@@ -0,0 +1,263 @@
+package mediashrink
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mediaInfoV2Prefix prefix of the versioned encoding of media info, followed by key=value fields in url query
+// encoding sorted by key, like v2:d=10.231s&ext=mp4&h=480&sig=123456&w=640
+const mediaInfoV2Prefix = "v2:"
+
+// keys of the fields in the v2 encoding, fields in zero value are omitted & unknown keys are ignored,
+// streams & chapters are repeated in order
+const (
+	mediaInfoKeyWidth       = "w"
+	mediaInfoKeyHeight      = "h"
+	mediaInfoKeyDuration    = "d"
+	mediaInfoKeySize        = "size"
+	mediaInfoKeyFrameRate   = "fps"
+	mediaInfoKeySampleRate  = "sr"
+	mediaInfoKeySignature   = "sig"
+	mediaInfoKeyExt         = "ext"
+	mediaInfoKeyRotation    = "rot"
+	mediaInfoKeyOrientation = "ori"
+	mediaInfoKeyStream      = "st"
+	mediaInfoKeyChapter     = "ch"
+	mediaInfoKeyVideoCodec  = "vc"
+	mediaInfoKeyProfile     = "vp"
+	mediaInfoKeyPixelFormat = "pix"
+	mediaInfoKeyAudioCodec  = "ac"
+	mediaInfoKeyChannels    = "chn"
+	mediaInfoKeyLayout      = "cl"
+	mediaInfoKeyBitDepth    = "bits"
+)
+
+// mediaInfoV2Unescaper restore the separators of rates, streams & chapters and the parentheses of channel layouts
+// for readability, which are valid in query values
+var mediaInfoV2Unescaper = strings.NewReplacer("%2F", "/", "%3A", ":", "%2C", ",", "%28", "(", "%29", ")")
+
+// ToString encode info in the versioned v2 encoding, which is stable to be stored as a key,
+// parse it with ParseMediaInfo, the backend is not encoded
+func (info *MediaInfoV2) ToString() string {
+	fields := url.Values{}
+	setUint := func(key string, v int64) {
+		if v != 0 {
+			fields.Set(key, strconv.FormatInt(v, 10))
+		}
+	}
+	setString := func(key, v string) {
+		if len(v) > 0 {
+			fields.Set(key, v)
+		}
+	}
+	setUint(mediaInfoKeyWidth, int64(info.Width))
+	setUint(mediaInfoKeyHeight, int64(info.Height))
+	if info.Duration != 0 {
+		fields.Set(mediaInfoKeyDuration, info.Duration.String())
+	}
+	setUint(mediaInfoKeySize, info.Size)
+	if !info.FrameRate.IsZero() {
+		fields.Set(mediaInfoKeyFrameRate, info.FrameRate.String())
+	}
+	if !info.SampleRate.IsZero() {
+		fields.Set(mediaInfoKeySampleRate, info.SampleRate.String())
+	}
+	setUint(mediaInfoKeyRotation, int64(info.Rotation))
+	setUint(mediaInfoKeyOrientation, int64(info.Orientation))
+	setUint(mediaInfoKeyChannels, int64(info.Channels))
+	setUint(mediaInfoKeyBitDepth, int64(info.BitDepth))
+	setString(mediaInfoKeySignature, info.Signature)
+	setString(mediaInfoKeyExt, info.Ext)
+	setString(mediaInfoKeyVideoCodec, info.VideoCodec)
+	setString(mediaInfoKeyProfile, info.VideoProfile)
+	setString(mediaInfoKeyPixelFormat, info.PixelFormat)
+	setString(mediaInfoKeyAudioCodec, info.AudioCodec)
+	setString(mediaInfoKeyLayout, info.ChannelLayout)
+	for _, stream := range info.Streams {
+		fields.Add(mediaInfoKeyStream, formatStream(stream))
+	}
+	for _, chapter := range info.Chapters {
+		fields.Add(mediaInfoKeyChapter, formatChapter(chapter))
+	}
+	return mediaInfoV2Prefix + mediaInfoV2Unescaper.Replace(fields.Encode())
+}
+
+// ParseMediaInfo parse str in the v2 encoding of MediaInfoV2.ToString,
+// or in the legacy width[x]height[x]duration[x]signature[.]ext of MediaInfo.ToString
+func ParseMediaInfo(str string) (*MediaInfoV2, error) {
+	if !strings.HasPrefix(str, mediaInfoV2Prefix) {
+		info, err := MediaInfoFromString(str)
+		if err != nil {
+			return nil, err
+		}
+		return info.V2(), nil
+	}
+	fields, err := url.ParseQuery(str[len(mediaInfoV2Prefix):])
+	if err != nil {
+		return nil, &ParseError{Output: []byte(str), Err: err}
+	}
+	info := &MediaInfoV2{}
+	parseUint := func(key string, bitSize int) uint64 {
+		v := fields.Get(key)
+		if len(v) == 0 || err != nil {
+			return 0
+		}
+		var i uint64
+		i, err = strconv.ParseUint(v, 10, bitSize)
+		return i
+	}
+	parseRational := func(key string) Rational {
+		v := fields.Get(key)
+		if len(v) == 0 || err != nil {
+			return Rational{}
+		}
+		var r Rational
+		r, err = ParseRational(v)
+		return r
+	}
+	info.Width = uint32(parseUint(mediaInfoKeyWidth, 32))
+	info.Height = uint32(parseUint(mediaInfoKeyHeight, 32))
+	info.Size = int64(parseUint(mediaInfoKeySize, 63))
+	info.FrameRate = parseRational(mediaInfoKeyFrameRate)
+	info.SampleRate = parseRational(mediaInfoKeySampleRate)
+	info.Rotation = normalizeRotation(float64(parseUint(mediaInfoKeyRotation, 16)))
+	if info.Orientation = int(parseUint(mediaInfoKeyOrientation, 8)); info.Orientation > 8 {
+		info.Orientation = 0
+	}
+	info.Channels = int(parseUint(mediaInfoKeyChannels, 16))
+	info.BitDepth = int(parseUint(mediaInfoKeyBitDepth, 8))
+	if v := fields.Get(mediaInfoKeyDuration); len(v) > 0 && err == nil {
+		info.Duration, err = time.ParseDuration(v)
+	}
+	for _, v := range fields[mediaInfoKeyStream] {
+		if err != nil {
+			break
+		}
+		var stream Stream
+		stream, err = parseStream(v)
+		info.Streams = append(info.Streams, stream)
+	}
+	for _, v := range fields[mediaInfoKeyChapter] {
+		if err != nil {
+			break
+		}
+		var chapter Chapter
+		chapter, err = parseChapter(v)
+		info.Chapters = append(info.Chapters, chapter)
+	}
+	if err != nil {
+		return nil, &ParseError{Output: []byte(str), Err: err}
+	}
+	if v := fields.Get(mediaInfoKeySignature); len(v) > 0 {
+		if info.Signature = validateSignature(v); len(info.Signature) == 0 {
+			return nil, &ParseError{Output: []byte(str), Err: ErrInvalidSignature}
+		}
+	}
+	info.VideoCodec = fields.Get(mediaInfoKeyVideoCodec)
+	info.VideoProfile = fields.Get(mediaInfoKeyProfile)
+	info.PixelFormat = fields.Get(mediaInfoKeyPixelFormat)
+	info.AudioCodec = fields.Get(mediaInfoKeyAudioCodec)
+	info.ChannelLayout = fields.Get(mediaInfoKeyLayout)
+	if info.Ext = fields.Get(mediaInfoKeyExt); len(info.Ext) == 0 {
+		return nil, &ParseError{Output: []byte(str), Err: errors.New("no ext")}
+	}
+	return info, nil
+}
+
+// MarshalText implements encoding.TextMarshaler in the v2 encoding
+func (info *MediaInfoV2) MarshalText() ([]byte, error) {
+	return []byte(info.ToString()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, the legacy encoding is accepted as well
+func (info *MediaInfoV2) UnmarshalText(text []byte) error {
+	parsed, err := ParseMediaInfo(string(text))
+	if err != nil {
+		return err
+	}
+	*info = *parsed
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, which is the same as MarshalText
+func (info *MediaInfoV2) MarshalBinary() ([]byte, error) {
+	return info.MarshalText()
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler
+func (info *MediaInfoV2) UnmarshalBinary(data []byte) error {
+	return info.UnmarshalText(data)
+}
+
+// MarshalJSON encode info in a JSON object instead of the text of MarshalText
+func (info *MediaInfoV2) MarshalJSON() ([]byte, error) {
+	type plain MediaInfoV2
+	return json.Marshal((*plain)(info))
+}
+
+// UnmarshalJSON decode a JSON object of MarshalJSON
+func (info *MediaInfoV2) UnmarshalJSON(data []byte) error {
+	type plain MediaInfoV2
+	return json.Unmarshal(data, (*plain)(info))
+}
+
+// MarshalText implements encoding.TextMarshaler in the v2 encoding
+func (info *MediaInfo) MarshalText() ([]byte, error) {
+	return info.V2().MarshalText()
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, the legacy encoding is accepted as well
+func (info *MediaInfo) UnmarshalText(text []byte) error {
+	parsed, err := ParseMediaInfo(string(text))
+	if err != nil {
+		return err
+	}
+	v1, err := parsed.V1()
+	if err != nil {
+		return err
+	}
+	*info = *v1
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, which is the same as MarshalText
+func (info *MediaInfo) MarshalBinary() ([]byte, error) {
+	return info.MarshalText()
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler
+func (info *MediaInfo) UnmarshalBinary(data []byte) error {
+	return info.UnmarshalText(data)
+}
+
+// MarshalJSON encode info in a JSON object instead of the text of MarshalText
+func (info *MediaInfo) MarshalJSON() ([]byte, error) {
+	type plain MediaInfo
+	return json.Marshal((*plain)(info))
+}
+
+// UnmarshalJSON decode a JSON object of MarshalJSON
+func (info *MediaInfo) UnmarshalJSON(data []byte) error {
+	type plain MediaInfo
+	return json.Unmarshal(data, (*plain)(info))
+}
+
+// MarshalText implements encoding.TextMarshaler in num/den
+func (r Rational) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, see ParseRational
+func (r *Rational) UnmarshalText(text []byte) error {
+	parsed, err := ParseRational(string(text))
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
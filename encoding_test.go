@@ -0,0 +1,121 @@
+package mediashrink
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseMediaInfoRoundTrip(t *testing.T) {
+	tests := []*MediaInfoV2{
+		{Ext: "mp4"},
+		{Width: 4000, Height: 3000, Signature: "0a1b2c", Ext: "jpg", Orientation: 6},
+		{
+			Duration:      10*time.Minute + 231*time.Millisecond,
+			Size:          123456789,
+			SampleRate:    Rational{Num: 44100, Den: 1},
+			Signature:     "abcdef",
+			Ext:           "flac",
+			AudioCodec:    "flac",
+			Channels:      6,
+			ChannelLayout: "5.1(side)",
+			BitDepth:      24,
+		},
+		{
+			Width:        1920,
+			Height:       1080,
+			Duration:     1*time.Hour + 30*time.Second + 1,
+			FrameRate:    Rational{Num: 24000, Den: 1001},
+			SampleRate:   Rational{Num: 48000, Den: 1},
+			Signature:    "123456",
+			Ext:          "mkv",
+			Rotation:     270,
+			VideoCodec:   "hevc",
+			VideoProfile: "Main 10",
+			PixelFormat:  "yuv420p10le",
+			AudioCodec:   "ac3",
+			Channels:     2,
+			Streams: []Stream{
+				{Type: StreamVideo, Disposition: []string{"default"}},
+				{Type: StreamAudio, Language: "eng", Title: "Director: cut, take 2 & more",
+					Disposition: []string{"comment", "default"}},
+				{Type: StreamAudio, Language: "jpn", Title: "100% = a+b; c/d (e)"},
+				{Type: StreamSubtitle, Title: "a:b:c"},
+				{Type: StreamSubtitle, Language: "fre", Disposition: []string{"forced", "hearing_impaired"}},
+			},
+			Chapters: []Chapter{
+				{Start: 0, End: 90 * time.Second, Title: "Intro: part 1, 2 & 3"},
+				{Start: 90 * time.Second, End: 10 * time.Minute},
+				{Start: 10 * time.Minute, End: 1*time.Hour + 30*time.Second + 1, Title: "1-2:3"},
+			},
+		},
+	}
+	for _, info := range tests {
+		str := info.ToString()
+		parsed, err := ParseMediaInfo(str)
+		if err != nil {
+			t.Errorf("%s: %v", str, err)
+			continue
+		}
+		if !reflect.DeepEqual(parsed, info) {
+			t.Errorf("got %+v of %s, want %+v", parsed, str, info)
+		}
+		if again := parsed.ToString(); again != str {
+			t.Errorf("got %s, want %s", again, str)
+		}
+	}
+}
+
+func TestParseMediaInfoLegacy(t *testing.T) {
+	tests := []struct {
+		str  string
+		want *MediaInfo
+	}{
+		{"640x480x10231x123456.mp4", &MediaInfo{Width: 640, Height: 480, Duration: 10231, Signature: "123456", Ext: "mp4"}},
+		{"0x0x5000xd41d8cd98f00b204e9800998ecf8427e.mp3",
+			&MediaInfo{Duration: 5000, Signature: "d41d8c", Ext: "mp3"}},
+		{"32x32x0xabcdef.tar.gz", &MediaInfo{Width: 32, Height: 32, Signature: "abcdef", Ext: "tar.gz"}},
+		{"v2:d=10.231s&ext=mp4&h=480&sig=123456&w=640",
+			&MediaInfo{Width: 640, Height: 480, Duration: 10231, Signature: "123456", Ext: "mp4"}},
+	}
+	for _, test := range tests {
+		info, err := MediaInfoFromString(test.str)
+		if err != nil {
+			t.Errorf("%s: %v", test.str, err)
+			continue
+		}
+		if !reflect.DeepEqual(info, test.want) {
+			t.Errorf("got %+v of %s, want %+v", info, test.str, test.want)
+		}
+		v2, err := ParseMediaInfo(test.str)
+		if err != nil {
+			t.Errorf("%s: %v", test.str, err)
+			continue
+		}
+		if want := test.want.V2(); !reflect.DeepEqual(v2, want) {
+			t.Errorf("got %+v of %s, want %+v", v2, test.str, want)
+		}
+	}
+}
+
+func TestParseMediaInfoInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"640x480.mp4",
+		"640x480x10231x123456",
+		"640x480x10231xnothex.mp4",
+		"axbx10231x123456.mp4",
+		"v2:w=640&h=480",
+		"v2:ext=mp4&sig=zz",
+		"v2:ext=mp4&w=-1",
+		"v2:ext=mp4&d=10",
+		"v2:ext=mp4&st=x:eng",
+		"v2:ext=mp4&ch=1s:Intro",
+		"v2:ext=mp4&fps=24/0",
+	}
+	for _, str := range tests {
+		if info, err := ParseMediaInfo(str); err == nil {
+			t.Errorf("got %+v of %q, want an error", info, str)
+		}
+	}
+}
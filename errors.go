@@ -0,0 +1,119 @@
+package mediashrink
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrUnknownMediaType error message when the given file is not recognized as a shrinkable media type
+	ErrUnknownMediaType = errors.New("unknown media type")
+	// ErrUnsupportedFormat the media or archive is recognized but can not be made in its format
+	ErrUnsupportedFormat = errors.New("unsupported media format")
+	// ErrInvalidSignature the signature is not a hex string in min length of 6
+	ErrInvalidSignature = errors.New("invalid signature")
+	// ErrToolNotFound an external command like ffmpeg or identify is not installed,
+	// *ExecError of a missing command matches it with errors.Is
+	ErrToolNotFound = errors.New("tool not found")
+	// ErrVerifyFailed a shrunk media does not match its media info, *VerifyError matches it with errors.Is
+	ErrVerifyFailed = errors.New("verify failed")
+	// ErrDurationOverflow the duration of a media does not fit in the uint32 ms of MediaInfo, use MediaInfoV2 instead
+	ErrDurationOverflow = errors.New("duration overflows MediaInfo")
+)
+
+// ExecError is returned when an external command fails to start or exits with an error
+type ExecError struct {
+	Tool     string
+	Args     []string
+	ExitCode int    // -1 if the command is not started or killed by a signal
+	Stderr   []byte // stderr of the command, combined with stdout for the commands whose stdout is not needed
+	Err      error
+}
+
+// Error implements error
+func (e *ExecError) Error() string {
+	msg := fmt.Sprintf("exec %s %s with err: %s", e.Tool, strings.Join(e.Args, " "), e.Err)
+	if stderr := strings.TrimSpace(string(e.Stderr)); len(stderr) > 0 {
+		msg += ", info: " + stderr
+	}
+	return msg
+}
+
+// Unwrap returns the underlying error like *exec.ExitError
+func (e *ExecError) Unwrap() error {
+	return e.Err
+}
+
+// Is make errors.Is(err, ErrToolNotFound) true when the command is not installed
+func (e *ExecError) Is(target error) bool {
+	return target == ErrToolNotFound && e.notFound()
+}
+
+// notFound check if the command is missing in $PATH or at its path
+func (e *ExecError) notFound() bool {
+	return errors.Is(e.Err, exec.ErrNotFound) || errors.Is(e.Err, os.ErrNotExist)
+}
+
+// Temporary reports whether the command may succeed when retried, which is when it is killed by a signal
+// like the OOM killer, a missing command or a non-zero exit status is permanent
+func (e *ExecError) Temporary() bool {
+	var exitErr *exec.ExitError
+	return errors.As(e.Err, &exitErr) && exitErr.ExitCode() == -1
+}
+
+// Temporary reports the command may succeed when retried with more time
+func (e *TimeoutError) Temporary() bool {
+	return true
+}
+
+// VerifyError is returned when a shrunk media is probed in another dimension or duration than expected
+type VerifyError struct {
+	Path      string
+	Expected  *MediaInfoV2
+	Actual    *MediaInfoV2
+	Tolerance time.Duration
+}
+
+// Error implements error
+func (e *VerifyError) Error() string {
+	expectedWidth, expectedHeight := e.Expected.DisplaySize()
+	actualWidth, actualHeight := e.Actual.DisplaySize()
+	return fmt.Sprintf("%s %s: expected %dx%d in %s, got %dx%d in %s with tolerance %s", ErrVerifyFailed, e.Path,
+		expectedWidth, expectedHeight, e.Expected.Duration, actualWidth, actualHeight, e.Actual.Duration, e.Tolerance)
+}
+
+// Is make errors.Is(err, ErrVerifyFailed) true
+func (e *VerifyError) Is(target error) bool {
+	return target == ErrVerifyFailed
+}
+
+// ParseError is returned when the output of a command or a media string is not understood
+type ParseError struct {
+	Tool   string // empty for the strings not produced by a command
+	Output []byte
+	Err    error
+}
+
+// Error implements error
+func (e *ParseError) Error() string {
+	if len(e.Tool) > 0 {
+		return fmt.Sprintf("failed to parse output %q of %s with err %s", e.Output, e.Tool, e.Err)
+	}
+	return fmt.Sprintf("failed to parse %q with err %s", e.Output, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// IsTemporary reports whether the operation failed with err may succeed when retried,
+// which is true for timeouts and commands killed by a signal, see ExecError.Temporary
+func IsTemporary(err error) bool {
+	var temporary interface{ Temporary() bool }
+	return errors.As(err, &temporary) && temporary.Temporary()
+}
@@ -0,0 +1,89 @@
+package mediashrink
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExecError(t *testing.T) {
+	sh := lookPathOrSkip(t, "sh")
+	s := NewShrinker()
+	tests := []struct {
+		name      string
+		tool      string
+		args      []string
+		exitCode  int
+		stderr    string
+		notFound  bool
+		temporary bool
+	}{
+		{name: "not found", tool: filepath.Join(t.TempDir(), "missing"), exitCode: -1, notFound: true},
+		{name: "exit status", tool: sh, args: []string{"-c", "echo oops >&2; exit 3"}, exitCode: 3, stderr: "oops\n"},
+		{name: "killed", tool: sh, args: []string{"-c", "kill -9 $$"}, exitCode: -1, temporary: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := s.command(context.Background(), test.tool, test.args...).Run()
+			var execErr *ExecError
+			if !errors.As(err, &execErr) {
+				t.Fatalf("got err %v, want an *ExecError", err)
+			}
+			if execErr.Tool != test.tool || execErr.ExitCode != test.exitCode || string(execErr.Stderr) != test.stderr {
+				t.Errorf("got %#v, want exit code %d & stderr %q", execErr, test.exitCode, test.stderr)
+			}
+			if errors.Is(err, ErrToolNotFound) != test.notFound || IsTemporary(err) != test.temporary {
+				t.Errorf("got err %v, want not found %t & temporary %t", err, test.notFound, test.temporary)
+			}
+			if len(test.stderr) > 0 && !strings.HasSuffix(err.Error(), ", info: "+strings.TrimSpace(test.stderr)) {
+				t.Errorf("got err %v, want stderr in it", err)
+			}
+		})
+	}
+}
+
+func TestErrorsWrapped(t *testing.T) {
+	input := writePNG(t, t.TempDir(), 4, 3)
+	garbage := []byte("neither an image, an audio nor a video")
+	s := NewShrinker()
+	_, unknownExt := s.GetMediaInfo("123456", false, filepath.Join(t.TempDir(), "media.unknown"))
+	_, unknownReader := s.ProbeReader(bytes.NewReader(garbage), int64(len(garbage)))
+	_, invalidSignature := s.GetMediaInfo("xyz", false, input)
+	_, archiveExt := s.GetMediaInfo("123456", false, input+".rar")
+	tests := []struct {
+		name   string
+		err    error
+		target error
+	}{
+		{"unknown ext", unknownExt, ErrUnknownMediaType},
+		{"unknown reader", unknownReader, ErrUnknownMediaType},
+		{"invalid signature", invalidSignature, ErrInvalidSignature},
+		{"archive ext", archiveExt, ErrUnknownMediaType},
+		{"timeout", &TimeoutError{Command: "ffmpeg"}, context.DeadlineExceeded},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if !errors.Is(test.err, test.target) {
+				t.Errorf("got err %v, want %v", test.err, test.target)
+			}
+		})
+	}
+	if !IsTemporary(&TimeoutError{Command: "ffmpeg"}) || IsTemporary(unknownExt) || IsTemporary(nil) {
+		t.Error("got timeouts permanent or others temporary")
+	}
+}
+
+func TestParseError(t *testing.T) {
+	_, err := MediaInfoFromString("1024x768")
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || string(parseErr.Output) != "1024x768" || len(parseErr.Tool) > 0 {
+		t.Fatalf("got err %v, want a *ParseError of the string", err)
+	}
+	err = &ParseError{Tool: "identify", Output: []byte("12"), Err: errors.New("no height")}
+	if want := `failed to parse output "12" of identify with err no height`; err.Error() != want {
+		t.Errorf("got %q, want %q", err.Error(), want)
+	}
+}
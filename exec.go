@@ -0,0 +1,111 @@
+package mediashrink
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// TimeoutError is returned when an external command is killed since the deadline of its context
+// or the timeout of the Shrinker is exceeded
+type TimeoutError struct {
+	Command string
+	Args    []string
+	Timeout time.Duration // timeout of the Shrinker, 0 if the deadline is set by the context
+}
+
+// Error implements error
+func (e *TimeoutError) Error() string {
+	if e.Timeout > 0 {
+		return fmt.Sprintf("exec %s %s timed out after %s", e.Command, strings.Join(e.Args, " "), e.Timeout)
+	}
+	return fmt.Sprintf("exec %s %s timed out", e.Command, strings.Join(e.Args, " "))
+}
+
+// Unwrap make errors.Is(err, context.DeadlineExceeded) true
+func (e *TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// command an external command bound to a context, the whole process group is killed on cancellation
+type command struct {
+	*exec.Cmd
+	parent  context.Context
+	ctx     context.Context
+	cancel  context.CancelFunc
+	timeout time.Duration
+	name    string
+	args    []string
+}
+
+// commandWaitDelay time to wait for the pipes to be closed after the process group is killed
+const commandWaitDelay = time.Second
+
+// command make a command of name with args bound to ctx and the timeout of s, logging it
+func (s *Shrinker) command(ctx context.Context, name string, args ...string) *command {
+	s.logger.Printf("mediashrink: exec %s %q", name, args)
+	parent, cancel := ctx, context.CancelFunc(func() {})
+	if s.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+	}
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.WaitDelay = commandWaitDelay
+	killProcessGroupOnCancel(cmd)
+	return &command{Cmd: cmd, parent: parent, ctx: ctx, cancel: cancel, timeout: s.timeout, name: name, args: args}
+}
+
+// Run runs the command and waits for it to complete, stderr is kept for the error if not redirected
+func (c *command) Run() error {
+	defer c.cancel()
+	var stderr bytes.Buffer
+	if c.Stderr == nil {
+		c.Stderr = &stderr
+	}
+	return c.error(c.Cmd.Run(), stderr.Bytes())
+}
+
+// CombinedOutput runs the command and returns its combined stdout & stderr
+func (c *command) CombinedOutput() ([]byte, error) {
+	defer c.cancel()
+	output, err := c.Cmd.CombinedOutput()
+	return output, c.error(err, output)
+}
+
+// Output runs the command and returns its stdout, stderr is kept for the error if not redirected
+func (c *command) Output() ([]byte, error) {
+	defer c.cancel()
+	var stderr bytes.Buffer
+	if c.Stderr == nil {
+		c.Stderr = &stderr
+	}
+	output, err := c.Cmd.Output()
+	return output, c.error(err, stderr.Bytes())
+}
+
+// error convert err of the command into a TimeoutError or the context error if it is killed for its context,
+// or an ExecError otherwise
+func (c *command) error(err error, stderr []byte) error {
+	if err == nil {
+		return nil
+	}
+	switch c.ctx.Err() {
+	case context.DeadlineExceeded:
+		timeout := time.Duration(0)
+		if c.parent.Err() == nil { // killed by the timeout of the Shrinker
+			timeout = c.timeout
+		}
+		return &TimeoutError{Command: c.name, Args: c.args, Timeout: timeout}
+	case context.Canceled:
+		return fmt.Errorf("exec %s canceled: %w", c.name, context.Canceled)
+	}
+	exitCode := -1
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		exitCode = exitErr.ExitCode()
+	}
+	return &ExecError{Tool: c.name, Args: c.args, ExitCode: exitCode, Stderr: stderr, Err: err}
+}
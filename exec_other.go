@@ -0,0 +1,10 @@
+//go:build !unix
+
+package mediashrink
+
+import (
+	"os/exec"
+)
+
+// killProcessGroupOnCancel process groups are not supported, only the command itself is killed on cancellation
+func killProcessGroupOnCancel(cmd *exec.Cmd) {}
@@ -0,0 +1,53 @@
+package mediashrink
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// lookPathOrSkip skip the test if name is not found in $PATH
+func lookPathOrSkip(t *testing.T, name string) string {
+	t.Helper()
+	path, err := exec.LookPath(name)
+	if err != nil {
+		t.Skipf("%s not found: %s", name, err)
+	}
+	return path
+}
+
+func TestCommandTimeout(t *testing.T) {
+	sleep := lookPathOrSkip(t, "sleep")
+	s := NewShrinker(WithTimeout(50 * time.Millisecond))
+	err := s.command(context.Background(), sleep, "10").Run()
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("got err %v, want a *TimeoutError", err)
+	}
+	if timeoutErr.Command != sleep || timeoutErr.Timeout != 50*time.Millisecond ||
+		!errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %#v, want the timeout of the Shrinker", timeoutErr)
+	}
+
+	// the deadline of the context is reported without the timeout of the Shrinker
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = NewShrinker(WithTimeout(time.Minute)).command(ctx, sleep, "10").Output()
+	if !errors.As(err, &timeoutErr) || timeoutErr.Timeout != 0 {
+		t.Errorf("got err %v, want a *TimeoutError of the context", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, err = NewShrinker().command(ctx, sleep, "10").CombinedOutput()
+	if !errors.Is(err, context.Canceled) || errors.As(err, &timeoutErr) {
+		t.Errorf("got err %v, want context.Canceled", err)
+	}
+
+	// commands finished in time are not affected
+	if err := s.command(context.Background(), sleep, "0").Run(); err != nil {
+		t.Errorf("got err %v", err)
+	}
+}
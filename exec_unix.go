@@ -0,0 +1,17 @@
+//go:build unix
+
+package mediashrink
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// killProcessGroupOnCancel run cmd in a new process group and kill the whole group on cancellation,
+// so the children forked by the command (e.g. by ImageMagick delegates) are killed as well
+func killProcessGroupOnCancel(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
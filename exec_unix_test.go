@@ -0,0 +1,25 @@
+//go:build unix
+
+package mediashrink
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCommandKillProcessGroup(t *testing.T) {
+	sh := lookPathOrSkip(t, "sh")
+	// the background sleep holds stdout, Output waits for it until commandWaitDelay if it is not killed with sh
+	s := NewShrinker(WithTimeout(50 * time.Millisecond))
+	start := time.Now()
+	_, err := s.command(context.Background(), sh, "-c", "sleep 10 & sleep 10").Output()
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("got err %v, want a *TimeoutError", err)
+	}
+	if elapsed := time.Since(start); elapsed >= commandWaitDelay {
+		t.Errorf("got killed in %s, want the process group killed before %s", elapsed, commandWaitDelay)
+	}
+}
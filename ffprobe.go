@@ -0,0 +1,189 @@
+package mediashrink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ffprobeEntries everything kept of a media, which is probed by a single ffprobe
+const ffprobeEntries = "format=duration:" +
+	"stream=codec_type,codec_name,profile,pix_fmt,r_frame_rate,width,height," +
+	"channels,channel_layout,sample_rate,bits_per_sample,bits_per_raw_sample:" +
+	"stream_tags=language,title,rotate:stream_disposition:stream_side_data=rotation:" +
+	"chapter=start_time,end_time:chapter_tags=title"
+
+// ffprobeOutput the format, streams & chapters printed by ffprobe in json,
+// the numbers printed in strings are N/A if unknown
+type ffprobeOutput struct {
+	Format struct {
+		Duration string
+	}
+	Streams  []ffprobeStream
+	Chapters []struct {
+		StartTime string `json:"start_time"`
+		EndTime   string `json:"end_time"`
+		Tags      struct{ Title string }
+	}
+	output []byte
+}
+
+// ffprobeStream a stream printed by ffprobe
+type ffprobeStream struct {
+	CodecType        string `json:"codec_type"`
+	CodecName        string `json:"codec_name"`
+	Profile          string
+	PixFmt           string `json:"pix_fmt"`
+	RFrameRate       string `json:"r_frame_rate"`
+	Width            uint32
+	Height           uint32
+	Channels         int
+	ChannelLayout    string `json:"channel_layout"`
+	SampleRate       string `json:"sample_rate"`
+	BitsPerSample    int    `json:"bits_per_sample"`
+	BitsPerRawSample string `json:"bits_per_raw_sample"`
+	Disposition      map[string]int
+	Tags             struct{ Language, Title, Rotate string }
+	SideDataList     []struct{ Rotation float64 } `json:"side_data_list"`
+}
+
+// probe probe the duration, streams & chapters of filePath in a single ffprobe
+func (b FFmpegBackend) probe(ctx context.Context, s *Shrinker, filePath string) (*ffprobeOutput, error) {
+	// ffprobe -v quiet -show_entries format=duration:stream=...:stream_tags=...:stream_disposition:chapter=... -of json
+	output, err := s.command(ctx,
+		b.ffprobe(),
+		"-v", "quiet",
+		"-show_entries", ffprobeEntries,
+		"-of", "json",
+		filePath).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed probe %s: %w", filePath, err)
+	}
+	probed := &ffprobeOutput{output: output}
+	if err := json.Unmarshal(output, probed); err != nil {
+		return nil, &ParseError{Tool: "ffprobe", Output: output, Err: err}
+	}
+	return probed, nil
+}
+
+// durationInfo a MediaInfoV2 of the duration of the format
+func (probed *ffprobeOutput) durationInfo() (*MediaInfoV2, error) {
+	secs, err := strconv.ParseFloat(probed.Format.Duration, 64)
+	if err != nil {
+		return nil, &ParseError{Tool: "ffprobe", Output: probed.output, Err: err}
+	}
+	return durationInfo(secondsToDuration(secs)), nil
+}
+
+// firstStream the first stream of codecType, cover arts attached as video streams are skipped, nil if none
+func (probed *ffprobeOutput) firstStream(codecType StreamType) *ffprobeStream {
+	for i := range probed.Streams {
+		if stream := &probed.Streams[i]; StreamType(stream.CodecType) == codecType &&
+			stream.Disposition["attached_pic"] == 0 {
+			return stream
+		}
+	}
+	return nil
+}
+
+// setVideoStream set coded width & height, codec, profile, pixel format & r_frame_rate of the first video stream
+// into info, with the clockwise rotation of its display matrix, or of the rotate tag written by ffmpeg before 6.0
+func (probed *ffprobeOutput) setVideoStream(info *MediaInfoV2) error {
+	stream := probed.firstStream(StreamVideo)
+	if stream == nil || stream.Width == 0 || stream.Height == 0 {
+		return &ParseError{Tool: "ffprobe", Output: probed.output, Err: errors.New("no video stream")}
+	}
+	info.Width, info.Height = stream.Width, stream.Height
+	info.VideoCodec, info.VideoProfile, info.PixelFormat = stream.CodecName, stream.Profile, stream.PixFmt
+	// ffprobe prints unknown for the profiles & pixel formats it can not name
+	if info.VideoProfile == "unknown" {
+		info.VideoProfile = ""
+	}
+	if info.PixelFormat == "unknown" {
+		info.PixelFormat = ""
+	}
+	if frameRate, err := ParseRational(stream.RFrameRate); err == nil {
+		info.FrameRate = frameRate
+	}
+	for _, sideData := range stream.SideDataList {
+		// the display matrix rotates counter-clockwise
+		if sideData.Rotation != 0 {
+			info.Rotation = normalizeRotation(-sideData.Rotation)
+		}
+	}
+	if rotate, err := strconv.ParseFloat(stream.Tags.Rotate, 64); err == nil && info.Rotation == 0 {
+		info.Rotation = normalizeRotation(rotate)
+	}
+	return nil
+}
+
+// setAudioStream set codec, channels, channel layout, sample rate & bit depth of the first audio stream into info,
+// nothing is set if there is no audio stream
+func (probed *ffprobeOutput) setAudioStream(info *MediaInfoV2) {
+	stream := probed.firstStream(StreamAudio)
+	if stream == nil {
+		return
+	}
+	info.AudioCodec, info.Channels = stream.CodecName, stream.Channels
+	// layouts in an unknown or custom order are printed like "unknown" & "6 channels (FL+FR+...)"
+	if stream.ChannelLayout != "unknown" && !strings.Contains(stream.ChannelLayout, " ") {
+		info.ChannelLayout = stream.ChannelLayout
+	}
+	if sampleRate, err := strconv.ParseInt(stream.SampleRate, 10, 32); err == nil && sampleRate > 0 {
+		info.SampleRate = Rational{Num: sampleRate, Den: 1}
+	}
+	if hasBitDepth(info.AudioCodec) {
+		// bits_per_raw_sample of lossless codecs, bits_per_sample of PCM
+		if bitDepth, err := strconv.Atoi(stream.BitsPerRawSample); err == nil && bitDepth > 0 {
+			info.BitDepth = bitDepth
+		} else if stream.BitsPerSample > 0 {
+			info.BitDepth = stream.BitsPerSample
+		}
+	}
+}
+
+// setStreamLayout set the video, audio & subtitle streams with the chapters into info,
+// cover arts attached as video streams are not kept, the missing end of the last chapter is the duration of info
+func (probed *ffprobeOutput) setStreamLayout(info *MediaInfoV2) error {
+	info.Streams = nil
+	for _, probedStream := range probed.Streams {
+		stream := Stream{
+			Type:     StreamType(probedStream.CodecType),
+			Language: normalizeLanguage(probedStream.Tags.Language),
+			Title:    probedStream.Tags.Title,
+		}
+		if _, exists := streamTypeKeys[stream.Type]; !exists || probedStream.Disposition["attached_pic"] != 0 {
+			continue
+		}
+		var names []string
+		for name, set := range probedStream.Disposition {
+			if set != 0 {
+				names = append(names, name)
+			}
+		}
+		stream.Disposition = newDisposition(names...)
+		info.Streams = append(info.Streams, stream)
+	}
+	info.Chapters = nil
+	for _, probedChapter := range probed.Chapters {
+		start, err := strconv.ParseFloat(probedChapter.StartTime, 64)
+		if err != nil {
+			return &ParseError{Tool: "ffprobe", Output: probed.output, Err: err}
+		}
+		// the end is the start of the next chapter if missing, times before the start of the media like the ones
+		// shifted by an edit list are clamped at 0, which can not be encoded in start-end otherwise
+		end, _ := strconv.ParseFloat(probedChapter.EndTime, 64)
+		start, end = math.Max(start, 0), math.Max(end, 0)
+		info.Chapters = append(info.Chapters, Chapter{
+			Start: secondsToDuration(start),
+			End:   secondsToDuration(end),
+			Title: probedChapter.Tags.Title,
+		})
+	}
+	fillChapterEnds(info.Chapters, info.Duration)
+	return nil
+}
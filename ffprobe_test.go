@@ -0,0 +1,230 @@
+package mediashrink
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parseFFprobeOutput parse the json printed by ffprobe like probe
+func parseFFprobeOutput(t *testing.T, output string) *ffprobeOutput {
+	t.Helper()
+	probed := &ffprobeOutput{output: []byte(output)}
+	if err := json.Unmarshal(probed.output, probed); err != nil {
+		t.Fatal(err)
+	}
+	return probed
+}
+
+func TestSetVideoStreamRotation(t *testing.T) {
+	tests := []struct {
+		name     string
+		streams  string
+		rotation int
+	}{
+		{"none", `[{"codec_type":"video","width":1920,"height":1080}]`, 0},
+		{
+			"display matrix counter-clockwise",
+			`[{"codec_type":"video","width":1920,"height":1080,"side_data_list":[{"rotation":-90}]}]`,
+			90,
+		},
+		{
+			"display matrix clockwise",
+			`[{"codec_type":"video","width":1920,"height":1080,"side_data_list":[{"rotation":90}]}]`,
+			270,
+		},
+		{"rotate tag", `[{"codec_type":"video","width":1920,"height":1080,"tags":{"rotate":"90"}}]`, 90},
+		{
+			"display matrix over rotate tag",
+			`[{"codec_type":"video","width":1920,"height":1080,"side_data_list":[{"rotation":180}],` +
+				`"tags":{"rotate":"90"}}]`,
+			180,
+		},
+		{
+			"cover art skipped",
+			`[{"codec_type":"video","width":600,"height":600,"disposition":{"attached_pic":1},"tags":{"rotate":"90"}},` +
+				`{"codec_type":"video","width":1920,"height":1080,"side_data_list":[{"rotation":-270}]}]`,
+			270,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info := &MediaInfoV2{}
+			if err := parseFFprobeOutput(t, `{"streams":`+test.streams+`}`).setVideoStream(info); err != nil {
+				t.Fatal(err)
+			}
+			if info.Width != 1920 || info.Height != 1080 || info.Rotation != test.rotation {
+				t.Errorf("got %dx%d rotated by %d, want 1920x1080 rotated by %d",
+					info.Width, info.Height, info.Rotation, test.rotation)
+			}
+		})
+	}
+}
+
+func TestSetVideoStreamInvalid(t *testing.T) {
+	for _, streams := range []string{
+		`[]`,
+		`[{"codec_type":"audio","channels":2}]`,
+		`[{"codec_type":"video","width":600,"height":600,"disposition":{"attached_pic":1}}]`,
+		`[{"codec_type":"video","width":0,"height":0}]`,
+	} {
+		var parseErr *ParseError
+		if err := parseFFprobeOutput(t, `{"streams":`+streams+`}`).setVideoStream(&MediaInfoV2{}); !errors.As(err,
+			&parseErr) || parseErr.Tool != "ffprobe" {
+			t.Errorf("got error %v of %s, want a ParseError of ffprobe", err, streams)
+		}
+	}
+}
+
+func TestSetStreamLayout(t *testing.T) {
+	probed := parseFFprobeOutput(t, `{
+		"streams": [
+			{"codec_type": "video", "disposition": {"default": 1, "forced": 0}, "tags": {"language": "und"}},
+			{"codec_type": "video", "disposition": {"default": 0, "attached_pic": 1}},
+			{"codec_type": "audio", "disposition": {"default": 0, "comment": 1, "original": 1},
+				"tags": {"language": "jpn", "title": "Director: cut"}},
+			{"codec_type": "subtitle", "disposition": {"forced": 1}, "tags": {"language": "fre"}},
+			{"codec_type": "data", "tags": {"title": "timecode"}},
+			{"codec_type": "attachment"}
+		],
+		"chapters": [
+			{"start_time": "-0.040000", "end_time": "10.500000", "tags": {"title": "Intro"}},
+			{"start_time": "10.500000", "end_time": "N/A"},
+			{"start_time": "30.000000", "end_time": "30.000000", "tags": {"title": "End"}}
+		]
+	}`)
+	info := &MediaInfoV2{Duration: time.Minute, Streams: defaultStreams, Chapters: []Chapter{{Title: "kept"}}}
+	if err := probed.setStreamLayout(info); err != nil {
+		t.Fatal(err)
+	}
+	streams := []Stream{
+		{Type: StreamVideo, Disposition: []string{"default"}},
+		{Type: StreamAudio, Language: "jpn", Title: "Director: cut", Disposition: []string{"comment", "original"}},
+		{Type: StreamSubtitle, Language: "fre", Disposition: []string{"forced"}},
+	}
+	chapters := []Chapter{
+		{Start: 0, End: 10500 * time.Millisecond, Title: "Intro"},
+		{Start: 10500 * time.Millisecond, End: 30 * time.Second},
+		{Start: 30 * time.Second, End: time.Minute, Title: "End"},
+	}
+	if !reflect.DeepEqual(info.Streams, streams) {
+		t.Errorf("got streams %v, want %v", info.Streams, streams)
+	}
+	if !reflect.DeepEqual(info.Chapters, chapters) {
+		t.Errorf("got chapters %v, want %v", info.Chapters, chapters)
+	}
+
+	var parseErr *ParseError
+	probed = parseFFprobeOutput(t, `{"chapters": [{"start_time": "N/A", "end_time": "1.000000"}]}`)
+	if err := probed.setStreamLayout(&MediaInfoV2{}); !errors.As(err, &parseErr) {
+		t.Errorf("got error %v of a chapter without start, want a ParseError", err)
+	}
+}
+
+func TestSetVideoStreamCodec(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		want   MediaInfoV2
+	}{
+		{
+			"h264",
+			`{"codec_type":"video","codec_name":"h264","profile":"High","pix_fmt":"yuv420p","r_frame_rate":"30000/1001"}`,
+			MediaInfoV2{VideoCodec: "h264", VideoProfile: "High", PixelFormat: "yuv420p",
+				FrameRate: Rational{Num: 30000, Den: 1001}},
+		},
+		{
+			"unknown profile & pixel format",
+			`{"codec_type":"video","codec_name":"mpeg4","profile":"unknown","pix_fmt":"unknown","r_frame_rate":"0/0"}`,
+			MediaInfoV2{VideoCodec: "mpeg4"},
+		},
+		{
+			"variable frame rate",
+			`{"codec_type":"video","codec_name":"vp9","profile":"Profile 0","r_frame_rate":"90000/1"}`,
+			MediaInfoV2{VideoCodec: "vp9", VideoProfile: "Profile 0", FrameRate: Rational{Num: 90000, Den: 1}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			probed := parseFFprobeOutput(t, `{"streams":[`+strings.TrimSuffix(test.stream, "}")+
+				`,"width":64,"height":48}]}`)
+			info := &MediaInfoV2{}
+			if err := probed.setVideoStream(info); err != nil {
+				t.Fatal(err)
+			}
+			test.want.Width, test.want.Height = 64, 48
+			if !reflect.DeepEqual(info, &test.want) {
+				t.Errorf("got %s, want %s", info.ToString(), test.want.ToString())
+			}
+		})
+	}
+}
+
+func TestSetAudioStream(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		want   MediaInfoV2
+	}{
+		{
+			"aac",
+			`{"codec_type":"audio","codec_name":"aac","channels":2,"channel_layout":"stereo","sample_rate":"48000",` +
+				`"bits_per_sample":0}`,
+			MediaInfoV2{AudioCodec: "aac", Channels: 2, ChannelLayout: "stereo",
+				SampleRate: Rational{Num: 48000, Den: 1}},
+		},
+		{
+			"flac in 24-bit",
+			`{"codec_type":"audio","codec_name":"flac","channels":6,"channel_layout":"5.1(side)",` +
+				`"sample_rate":"96000","bits_per_sample":0,"bits_per_raw_sample":"24"}`,
+			MediaInfoV2{AudioCodec: "flac", Channels: 6, ChannelLayout: "5.1(side)",
+				SampleRate: Rational{Num: 96000, Den: 1}, BitDepth: 24},
+		},
+		{
+			"pcm",
+			`{"codec_type":"audio","codec_name":"pcm_s16le","channels":1,"channel_layout":"mono",` +
+				`"sample_rate":"8000","bits_per_sample":16,"bits_per_raw_sample":"N/A"}`,
+			MediaInfoV2{AudioCodec: "pcm_s16le", Channels: 1, ChannelLayout: "mono",
+				SampleRate: Rational{Num: 8000, Den: 1}, BitDepth: 16},
+		},
+		{
+			"lossy bit depth dropped",
+			`{"codec_type":"audio","codec_name":"mp3","channels":2,"channel_layout":"stereo","sample_rate":"44100",` +
+				`"bits_per_raw_sample":"16"}`,
+			MediaInfoV2{AudioCodec: "mp3", Channels: 2, ChannelLayout: "stereo",
+				SampleRate: Rational{Num: 44100, Den: 1}},
+		},
+		{
+			"unknown layout",
+			`{"codec_type":"audio","codec_name":"pcm_s24le","channels":4,"channel_layout":"unknown",` +
+				`"sample_rate":"N/A","bits_per_sample":24}`,
+			MediaInfoV2{AudioCodec: "pcm_s24le", Channels: 4, BitDepth: 24},
+		},
+		{
+			"custom layout",
+			`{"codec_type":"audio","codec_name":"opus","channels":3,"channel_layout":"3 channels (FL+FR+LFE)",` +
+				`"sample_rate":"48000"}`,
+			MediaInfoV2{AudioCodec: "opus", Channels: 3, SampleRate: Rational{Num: 48000, Den: 1}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			probed := parseFFprobeOutput(t, `{"streams":[{"codec_type":"video","width":64,"height":48},`+
+				test.stream+`]}`)
+			info := &MediaInfoV2{}
+			probed.setAudioStream(info)
+			if !reflect.DeepEqual(info, &test.want) {
+				t.Errorf("got %s, want %s", info.ToString(), test.want.ToString())
+			}
+		})
+	}
+	// nothing is set without an audio stream
+	info := &MediaInfoV2{}
+	parseFFprobeOutput(t, `{"streams":[{"codec_type":"video"}]}`).setAudioStream(info)
+	if !reflect.DeepEqual(info, &MediaInfoV2{}) {
+		t.Errorf("got %s, want nothing", info.ToString())
+	}
+}
@@ -0,0 +1,140 @@
+//go:build unix
+
+package mediashrink
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeFFprobe an ffprobe printing output, whose arguments are written into the file returned
+func fakeFFprobe(t *testing.T, output string) (FFmpegBackend, string) {
+	t.Helper()
+	lookPathOrSkip(t, "sh")
+	dir := t.TempDir()
+	outputPath, argsPath := filepath.Join(dir, "output.json"), filepath.Join(dir, "args")
+	if err := os.WriteFile(outputPath, []byte(output), 0644); err != nil {
+		t.Fatal(err)
+	}
+	script := "printf '%s\\n' \"$@\" > '" + argsPath + "'\ncat '" + outputPath + "'\n"
+	return FFmpegBackend{FFProbe: writeScript(t, dir, "ffprobe", script)}, argsPath
+}
+
+func TestFFprobeProbeVideo(t *testing.T) {
+	b, argsPath := fakeFFprobe(t, `{
+		"programs": [],
+		"streams": [
+			{"codec_type": "video", "codec_name": "h264", "profile": "High", "pix_fmt": "yuv420p",
+				"r_frame_rate": "30000/1001", "width": 1920, "height": 1080,
+				"disposition": {"default": 1}, "side_data_list": [{"side_data_type": "Display Matrix", "rotation": -90}]},
+			{"codec_type": "audio", "codec_name": "aac", "profile": "LC", "channels": 2, "channel_layout": "stereo",
+				"sample_rate": "48000", "bits_per_sample": 0, "disposition": {"default": 1}, "tags": {"language": "eng"}}
+		],
+		"chapters": [{"start_time": "0.000000", "end_time": "2.500000", "tags": {"title": "Intro"}}],
+		"format": {"duration": "5.005000"}
+	}`)
+	info, err := b.ProbeVideo(context.Background(), NewShrinker(), NewFileSource("video.mp4", "mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &MediaInfoV2{
+		Width:         1920,
+		Height:        1080,
+		Duration:      5005 * time.Millisecond,
+		FrameRate:     Rational{Num: 30000, Den: 1001},
+		SampleRate:    Rational{Num: 48000, Den: 1},
+		Rotation:      90,
+		VideoCodec:    "h264",
+		VideoProfile:  "High",
+		PixelFormat:   "yuv420p",
+		AudioCodec:    "aac",
+		Channels:      2,
+		ChannelLayout: "stereo",
+		Streams: []Stream{
+			{Type: StreamVideo, Disposition: []string{"default"}},
+			{Type: StreamAudio, Language: "eng", Disposition: []string{"default"}},
+		},
+		Chapters: []Chapter{{Start: 0, End: 2500 * time.Millisecond, Title: "Intro"}},
+	}
+	if !reflect.DeepEqual(info, want) {
+		t.Errorf("got %s, want %s", info.ToString(), want.ToString())
+	}
+	args, err := os.ReadFile(argsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantArgs := []string{"-v", "quiet", "-show_entries", ffprobeEntries, "-of", "json", "video.mp4"}
+	if got := strings.Split(strings.TrimSuffix(string(args), "\n"), "\n"); !reflect.DeepEqual(got, wantArgs) {
+		t.Errorf("got args %q, want %q", got, wantArgs)
+	}
+}
+
+func TestFFprobeProbeInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+	}{
+		{"not json", "[FORMAT]\nduration=5.000000\n[/FORMAT]\n"},
+		{"no duration", `{"streams": [{"codec_type": "video", "width": 64, "height": 48}], "format": {}}`},
+		{"no video stream", `{"streams": [{"codec_type": "audio", "channels": 2}], "format": {"duration": "5.0"}}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b, _ := fakeFFprobe(t, test.output)
+			_, err := b.ProbeVideo(context.Background(), NewShrinker(), NewFileSource("video.mp4", "mp4"))
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) || parseErr.Tool != "ffprobe" || string(parseErr.Output) != test.output {
+				t.Errorf("got error %v, want a ParseError of ffprobe with the output", err)
+			}
+		})
+	}
+	// the error of a failed ffprobe is kept
+	missing := FFmpegBackend{FFProbe: filepath.Join(t.TempDir(), "missing")}
+	_, err := missing.ProbeVideo(context.Background(), NewShrinker(), NewFileSource("video.mp4", "mp4"))
+	if !errors.Is(err, ErrToolNotFound) {
+		t.Errorf("got error %v, want %v", err, ErrToolNotFound)
+	}
+}
+
+func TestFFprobeReadChapters(t *testing.T) {
+	// the chapters in a text track are read by ffprobe, the end of the last one is the duration
+	b, _ := fakeFFprobe(t, `{"chapters": [
+		{"start_time": "0.000000", "end_time": "N/A", "tags": {"title": "Intro"}},
+		{"start_time": "2.500000", "end_time": "N/A", "tags": {"title": "Main"}}
+	], "format": {"duration": "5.000000"}}`)
+	info := &MediaInfoV2{Duration: 4 * time.Second, Streams: defaultStreams}
+	if err := b.readChapters(context.Background(), NewShrinker(), NewFileSource("video.mov", "mov"), info); err != nil {
+		t.Fatal(err)
+	}
+	chapters := []Chapter{
+		{Start: 0, End: 2500 * time.Millisecond, Title: "Intro"},
+		{Start: 2500 * time.Millisecond, End: 4 * time.Second, Title: "Main"},
+	}
+	if !reflect.DeepEqual(info.Chapters, chapters) || !reflect.DeepEqual(info.Streams, defaultStreams) {
+		t.Errorf("got chapters %v & streams %v, want %v & %v", info.Chapters, info.Streams, chapters, defaultStreams)
+	}
+}
+
+func TestFFprobeProbeAudio(t *testing.T) {
+	// the cover art is a video stream, which is skipped
+	b, _ := fakeFFprobe(t, `{"streams": [
+		{"codec_type": "video", "codec_name": "mjpeg", "width": 600, "height": 600, "disposition": {"attached_pic": 1}},
+		{"codec_type": "audio", "codec_name": "alac", "channels": 2, "channel_layout": "stereo",
+			"sample_rate": "44100", "bits_per_sample": 0, "bits_per_raw_sample": "24"}
+	], "format": {"duration": "180.025000"}}`)
+	info, err := b.ProbeAudio(context.Background(), NewShrinker(), NewFileSource("audio.m4a", "m4a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &MediaInfoV2{Duration: 180025 * time.Millisecond, SampleRate: Rational{Num: 44100, Den: 1},
+		AudioCodec: "alac", Channels: 2, ChannelLayout: "stereo", BitDepth: 24}
+	if !reflect.DeepEqual(info, want) {
+		t.Errorf("got %s, want %s", info.ToString(), want.ToString())
+	}
+}
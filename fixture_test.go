@@ -0,0 +1,17 @@
+package mediashrink
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// readFixture read a media of testdata, which are hand-crafted independently of the parsers
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,7 @@
+module github.com/cszichao/mediashrink
+
+go 1.20
+
+// github.com/haxii/filetype is not served by proxy.golang.org,
+// add it with `go get github.com/haxii/filetype` from a host which can fetch it
+require golang.org/x/image v0.18.0
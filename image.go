@@ -1,76 +1,80 @@
 package mediashrink
 
 import (
-	"bytes"
-	"errors"
+	"context"
 	"fmt"
-	"os/exec"
 )
 
-// getImageInfo get image size with width x height
-func getImageInfo(imagePath string) (*MediaInfo, error) {
-	info := &MediaInfo{0, 0, 0, "", ""}
-	if output, err := exec.Command(
-		commands.ImageMagicK.Identify,
-		"-format", "%[fx:w]\n%[fx:h]\n", imagePath,
-	).CombinedOutput(); err != nil {
-		return nil, fmt.Errorf("exec identify %s with err: %s, info: %s", imagePath, err, output)
-	} else if info.Width, info.Height, err = getWidthAndHeightFromBytes(output); err != nil {
-		return nil, fmt.Errorf("failed get width & height from %s with err %s", output, err)
+// getImageInfo get image size with width x height, the image backends are tried in order,
+// the pure Go one first to avoid exec the image backend for every image
+func (s *Shrinker) getImageInfo(ctx context.Context, src Source) (*MediaInfoV2, error) {
+	var info *MediaInfoV2
+	name, err := tryBackends(ctx, s, "probe "+src.Name(), s.imageChain, nil, func(backend ImageBackend) (err error) {
+		info, err = backend.ProbeImage(ctx, s, src)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
+	info.Backend = name
 	return info, nil
 }
 
-// makeNullImage make a null image using imgInfo
-func (imgInfo *MediaInfo) makeNullImage(outputPath string) error {
-	// convert -size 1024x768 xc:white canvas.jpg
-	imageSize := fmt.Sprintf("%dx%d", imgInfo.Width, imgInfo.Height)
-	if info, err := exec.Command(
-		commands.ImageMagicK.Convert,
-		"-size", imageSize,
-		"xc:#"+imgInfo.Signature, outputPath,
-	).CombinedOutput(); err != nil {
-		return fmt.Errorf("exec convert %s with err: %s, info: %s", outputPath, err, info)
-	}
-	return nil
+// makeNullImage make a null image using imgInfo with the first image backend which can make it
+func (s *Shrinker) makeNullImage(ctx context.Context, imgInfo *MediaInfoV2, sink Sink) error {
+	_, err := tryBackends(ctx, s, "make "+imgInfo.ToString(), s.imageChain, &sink, func(backend ImageBackend) error {
+		return backend.MakeNullImage(ctx, s, imgInfo, sink)
+	})
+	return err
 }
 
-var (
-	errNotPNG = errors.New("not a PNG file")
-	pngIHDR   = []byte{0x49, 0x48, 0x44, 0x52}
-)
-
-// getImagePNGInfo optimized info getter for png, compatible with apple's CgBI file format
-func getImagePNGInfo(imagePath string) (*MediaInfo, error) {
-	isPNG := func(header []byte) bool {
-		return len(header) > 3 &&
-			header[0] == 0x89 && header[1] == 0x50 &&
-			header[2] == 0x4E && header[3] == 0x47
+// ProbeImage implements ImageBackend, get image dimension using ffprobe
+func (b FFmpegBackend) ProbeImage(ctx context.Context, s *Shrinker, src Source) (*MediaInfoV2, error) {
+	imagePath, release, err := src.file(s.tempDir)
+	if err != nil {
+		return nil, err
 	}
-	var width, height int
-	if err := readFileHeader(imagePath, func(header []byte, err error) error {
-		if err != nil {
-			return err
-		}
-		if !isPNG(header) {
-			return errNotPNG
-		}
-
-		// get index of "IHDR", image width and height are followed with it
-		ihdrIndex := bytes.Index(header, pngIHDR)
-		if ihdrIndex <= 0 || ihdrIndex+len(pngIHDR)+8 > len(header) {
-			return errNotPNG
-		}
-		widthIndex := ihdrIndex + len(pngIHDR)
-		widthBytes := header[widthIndex : widthIndex+4]
-		heightBytes := header[widthIndex+4 : widthIndex+8]
-
-		// big endian
-		width = int(widthBytes[3]) + int(widthBytes[2])<<8 + int(widthBytes[1])<<16 + int(widthBytes[0])<<24
-		height = int(heightBytes[3]) + int(heightBytes[2])<<8 + int(heightBytes[1])<<16 + int(heightBytes[0])<<24
-		return nil
-	}); err != nil {
+	defer release()
+	info := &MediaInfoV2{}
+	if info.Width, info.Height, err = b.getDimension(ctx, s, imagePath); err != nil {
 		return nil, err
 	}
-	return &MediaInfo{uint32(width), uint32(height), 0, "", ""}, nil
+	return info, nil
 }
+
+// MakeNullImage implements ImageBackend, make a null image of a single frame using ffmpeg,
+// which can not write the EXIF orientation, see WithOrientationMode
+func (b FFmpegBackend) MakeNullImage(ctx context.Context, s *Shrinker, imgInfo *MediaInfoV2, sink Sink) error {
+	// ffmpeg -f lavfi -i color=#123456:s=1024x768 -frames:v 1 canvas.jpg
+	if imgInfo.Orientation > 1 {
+		return fmt.Errorf("%w %s in EXIF orientation %d by ffmpeg",
+			ErrUnsupportedFormat, imgInfo.Ext, imgInfo.Orientation)
+	}
+	imageSize := fmt.Sprintf("%dx%d", imgInfo.Width, imgInfo.Height)
+	outputPath, done, err := sink.file(s.tempDir, imgInfo.Ext)
+	if err != nil {
+		return err
+	}
+	if _, err := s.command(ctx,
+		b.ffmpeg(),
+		"-loglevel", "fatal",
+		"-y", "-f", "lavfi", "-i", "color=#"+imgInfo.Signature+":s="+imageSize,
+		"-frames:v", "1",
+		outputPath,
+	).CombinedOutput(); err != nil {
+		return done(fmt.Errorf("failed make %s: %w", outputPath, err))
+	}
+	return done(nil)
+}
+
+// OrientationMode how images in an EXIF orientation are made, see WithOrientationMode
+type OrientationMode int
+
+// orientation modes
+const (
+	// OrientationMetadata make the image in the stored dimension with the same orientation in metadata,
+	// by the pure Go generator for JPEG & TIFF or ImageMagick & GraphicsMagick, ffmpeg & libvips can not write it
+	OrientationMetadata OrientationMode = iota
+	// OrientationPreRotated make the image in the display dimension without orientation
+	OrientationPreRotated
+)
@@ -0,0 +1,244 @@
+package mediashrink
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ImageMagick6Backend probes images with identify & makes them with convert of ImageMagick 6,
+// empty commands are looked up in $PATH
+type ImageMagick6Backend struct {
+	Identify string
+	Convert  string
+}
+
+// Name implements ImageBackend
+func (b ImageMagick6Backend) Name() string { return "imagemagick6" }
+
+// Available implements ImageBackend
+func (b ImageMagick6Backend) Available() bool {
+	return lookPath(orDefault(b.Identify, "identify"), orDefault(b.Convert, "convert"))
+}
+
+// ProbeImage implements ImageBackend
+func (b ImageMagick6Backend) ProbeImage(ctx context.Context, s *Shrinker, src Source) (*MediaInfoV2, error) {
+	return magickProbe(ctx, s, "identify", orDefault(b.Identify, "identify"), nil,
+		"%[fx:w]\n%[fx:h]\n%[orientation]\n", src)
+}
+
+// MakeNullImage implements ImageBackend
+func (b ImageMagick6Backend) MakeNullImage(ctx context.Context, s *Shrinker, info *MediaInfoV2, sink Sink) error {
+	return magickNullImage(ctx, s, "convert", orDefault(b.Convert, "convert"), nil, info, sink)
+}
+
+// ImageMagick7Backend probes & makes images with the single binary magick of ImageMagick 7,
+// an empty command is looked up in $PATH
+type ImageMagick7Backend struct {
+	Magick string
+}
+
+// Name implements ImageBackend
+func (b ImageMagick7Backend) Name() string { return "imagemagick7" }
+
+// Available implements ImageBackend
+func (b ImageMagick7Backend) Available() bool { return lookPath(orDefault(b.Magick, "magick")) }
+
+// ProbeImage implements ImageBackend
+func (b ImageMagick7Backend) ProbeImage(ctx context.Context, s *Shrinker, src Source) (*MediaInfoV2, error) {
+	return magickProbe(ctx, s, "magick identify", orDefault(b.Magick, "magick"), []string{"identify"},
+		"%[fx:w]\n%[fx:h]\n%[orientation]\n", src)
+}
+
+// MakeNullImage implements ImageBackend
+func (b ImageMagick7Backend) MakeNullImage(ctx context.Context, s *Shrinker, info *MediaInfoV2, sink Sink) error {
+	return magickNullImage(ctx, s, "magick", orDefault(b.Magick, "magick"), nil, info, sink)
+}
+
+// GraphicsMagickBackend probes & makes images with gm of GraphicsMagick, an empty command is looked up in $PATH
+type GraphicsMagickBackend struct {
+	GM string
+}
+
+// Name implements ImageBackend
+func (b GraphicsMagickBackend) Name() string { return "graphicsmagick" }
+
+// Available implements ImageBackend
+func (b GraphicsMagickBackend) Available() bool { return lookPath(orDefault(b.GM, "gm")) }
+
+// ProbeImage implements ImageBackend
+func (b GraphicsMagickBackend) ProbeImage(ctx context.Context, s *Shrinker, src Source) (*MediaInfoV2, error) {
+	return magickProbe(ctx, s, "gm identify", orDefault(b.GM, "gm"), []string{"identify"},
+		"%w\n%h\n%[EXIF:Orientation]\n", src)
+}
+
+// MakeNullImage implements ImageBackend
+func (b GraphicsMagickBackend) MakeNullImage(ctx context.Context, s *Shrinker, info *MediaInfoV2, sink Sink) error {
+	return magickNullImage(ctx, s, "gm convert", orDefault(b.GM, "gm"), []string{"convert"}, info, sink)
+}
+
+// magickProbe get width & height with the EXIF orientation of src with an identify of ImageMagick or GraphicsMagick,
+// which are printed in lines by format, readers are piped into stdin
+func magickProbe(ctx context.Context, s *Shrinker, tool, name string, args []string,
+	format string, src Source) (*MediaInfoV2, error) {
+	// identify -format "%[fx:w]\n%[fx:h]\n%[orientation]\n" image.jpg
+	info := &MediaInfoV2{}
+	input, stdin := src.input()
+	args = append(append(args[:len(args):len(args)], "-format", format), input)
+	cmd := s.command(ctx, name, args...)
+	cmd.Stdin = stdin
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("failed identify %s: %w", src.Name(), err)
+	} else if info.Width, info.Height, err = getWidthAndHeightFromBytes(output); err != nil {
+		return nil, &ParseError{Tool: tool, Output: output, Err: err}
+	}
+	// the 3rd line is the orientation of the 1st frame, frames after it are printed again
+	if lines := strings.SplitN(string(output), "\n", 4); len(lines) >= 3 {
+		info.Orientation = parseMagickOrientation(strings.TrimSpace(lines[2]))
+	}
+	return info, nil
+}
+
+// parseMagickOrientation parse the EXIF orientation printed by ImageMagick like RightTop, or by GraphicsMagick in
+// number, 0 if undefined or unknown
+func parseMagickOrientation(orientation string) int {
+	for i, name := range magickOrientations {
+		if strings.EqualFold(orientation, name) {
+			return i
+		}
+	}
+	if i, err := strconv.Atoi(orientation); err == nil && i > 0 && i < len(magickOrientations) {
+		return i
+	}
+	return 0
+}
+
+// magickNullImage make a null image with a convert of ImageMagick or GraphicsMagick,
+// writers are fed from stdout
+func magickNullImage(ctx context.Context, s *Shrinker, tool, name string, args []string,
+	info *MediaInfoV2, sink Sink) error {
+	// convert -size 1024x768 xc:white -orient RightTop canvas.jpg
+	imageSize := fmt.Sprintf("%dx%d", info.Width, info.Height)
+	output, stdout := sink.output(info.Ext)
+	args = append(args[:len(args):len(args)], "-size", imageSize, "xc:#"+info.Signature)
+	if info.Orientation > 1 && info.Orientation < len(magickOrientations) {
+		args = append(args, "-orient", magickOrientations[info.Orientation])
+	}
+	args = append(args, output)
+	cmd := s.command(ctx, name, args...)
+	var err error
+	if stdout == nil {
+		_, err = cmd.CombinedOutput()
+	} else {
+		cmd.Stdout = stdout
+		err = cmd.Run()
+	}
+	if err != nil {
+		return fmt.Errorf("failed %s %s: %w", tool, output, err)
+	}
+	return nil
+}
+
+// magickOrientations names of the EXIF orientations in ImageMagick & GraphicsMagick
+var magickOrientations = []string{"Undefined", "TopLeft", "TopRight", "BottomRight", "BottomLeft",
+	"LeftTop", "RightTop", "RightBottom", "LeftBottom"}
+
+// VipsBackend probes images with vipsheader & makes them with vips of libvips,
+// empty commands are looked up in $PATH, the savers of libvips decide which formats can be made
+type VipsBackend struct {
+	Vips       string
+	VipsHeader string
+}
+
+// Name implements ImageBackend
+func (b VipsBackend) Name() string { return "vips" }
+
+// Available implements ImageBackend
+func (b VipsBackend) Available() bool {
+	return lookPath(orDefault(b.Vips, "vips"), orDefault(b.VipsHeader, "vipsheader"))
+}
+
+// ProbeImage implements ImageBackend
+func (b VipsBackend) ProbeImage(ctx context.Context, s *Shrinker, src Source) (*MediaInfoV2, error) {
+	// vipsheader -a image.jpg
+	imagePath, release, err := src.file(s.tempDir)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	output, err := s.command(ctx, orDefault(b.VipsHeader, "vipsheader"), "-a", imagePath).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed probe %s: %w", src.Name(), err)
+	}
+	info := &MediaInfoV2{}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		keyValue := strings.SplitN(scanner.Text(), ":", 2)
+		if len(keyValue) != 2 {
+			continue
+		}
+		var field *uint32
+		switch strings.TrimSpace(keyValue[0]) {
+		case "width":
+			field = &info.Width
+		case "height":
+			field = &info.Height
+		default:
+			continue
+		}
+		v, err := strconv.ParseUint(strings.TrimSpace(keyValue[1]), 10, 32)
+		if err != nil {
+			return nil, &ParseError{Tool: "vipsheader", Output: output, Err: err}
+		}
+		*field = uint32(v)
+	}
+	if info.Width == 0 || info.Height == 0 {
+		return nil, &ParseError{Tool: "vipsheader", Output: output, Err: errors.New("no width & height")}
+	}
+	return info, nil
+}
+
+// MakeNullImage implements ImageBackend, the EXIF orientation can not be written, see WithOrientationMode
+func (b VipsBackend) MakeNullImage(ctx context.Context, s *Shrinker, info *MediaInfoV2, sink Sink) error {
+	// vips black black.v 1024 768 --bands 3
+	// vips linear black.v canvas.jpg "0 0 0" "255 255 255" --uchar
+	if info.Orientation > 1 {
+		return fmt.Errorf("%w %s in EXIF orientation %d by vips", ErrUnsupportedFormat, info.Ext, info.Orientation)
+	}
+	c, err := signatureColor(info.Signature)
+	if err != nil {
+		return err
+	}
+	workDir, err := ioutil.TempDir(s.tempDir, "mediashrink")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+	black := filepath.Join(workDir, "black.v")
+	vips := orDefault(b.Vips, "vips")
+	if _, err := s.command(ctx, vips, "black", black,
+		strconv.FormatUint(uint64(info.Width), 10), strconv.FormatUint(uint64(info.Height), 10),
+		"--bands", "3",
+	).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed make %s: %w", black, err)
+	}
+
+	outputPath, done, err := sink.file(s.tempDir, info.Ext)
+	if err != nil {
+		return err
+	}
+	if _, err := s.command(ctx, vips, "linear", black, outputPath,
+		"0 0 0", fmt.Sprintf("%d %d %d", c.R, c.G, c.B), "--uchar",
+	).CombinedOutput(); err != nil {
+		return done(fmt.Errorf("failed make %s: %w", outputPath, err))
+	}
+	return done(nil)
+}
@@ -0,0 +1,29 @@
+package mediashrink
+
+import "testing"
+
+func TestParseMagickOrientation(t *testing.T) {
+	tests := []struct {
+		orientation string
+		want        int
+	}{
+		{"", 0},
+		{"Undefined", 0},
+		{"TopLeft", 1},
+		{"BottomRight", 3},
+		{"RightTop", 6},
+		{"lefttop", 5},
+		{"LeftBottom", 8},
+		{"8", 8}, // GraphicsMagick prints the EXIF value
+		{"1", 1},
+		{"0", 0},
+		{"9", 0},
+		{"-1", 0},
+		{"Unknown", 0},
+	}
+	for _, test := range tests {
+		if got := parseMagickOrientation(test.orientation); got != test.want {
+			t.Errorf("got %d of %q, want %d", got, test.orientation, test.want)
+		}
+	}
+}
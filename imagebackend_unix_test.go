@@ -0,0 +1,69 @@
+//go:build unix
+
+package mediashrink
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMagickProbeOrientation(t *testing.T) {
+	lookPathOrSkip(t, "sh")
+	dir := t.TempDir()
+	tests := []struct {
+		backend     ImageBackend
+		orientation int
+	}{
+		{ImageMagick6Backend{Identify: writeScript(t, dir, "identify", `printf '4000\n3000\nRightTop\n'`)}, 6},
+		{ImageMagick7Backend{Magick: writeScript(t, dir, "magick", `printf '4000\n3000\nUndefined\n'`)}, 0},
+		// the orientation of every frame is printed
+		{GraphicsMagickBackend{GM: writeScript(t, dir, "gm", `printf '4000\n3000\n8\n4000\n3000\n8\n'`)}, 8},
+	}
+	for _, test := range tests {
+		t.Run(test.backend.Name(), func(t *testing.T) {
+			info, err := test.backend.ProbeImage(context.Background(), NewShrinker(), NewFileSource("image.jpg", "jpg"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Width != 4000 || info.Height != 3000 || info.Orientation != test.orientation {
+				t.Errorf("got %dx%d in orientation %d, want 4000x3000 in orientation %d",
+					info.Width, info.Height, info.Orientation, test.orientation)
+			}
+		})
+	}
+}
+
+func TestMagickNullImageOrientation(t *testing.T) {
+	lookPathOrSkip(t, "sh")
+	dir := t.TempDir()
+	// a convert writing its arguments into the output, which is the last one
+	convert := writeScript(t, dir, "convert", "for a in \"$@\"; do last=\"$a\"; done\necho \"$@\" > \"$last\"\n")
+	tests := []struct {
+		orientation int
+		want        string
+	}{
+		{0, "-size 40x30 xc:#abcdef "},
+		{1, "-size 40x30 xc:#abcdef "},
+		{6, "-size 40x30 xc:#abcdef -orient RightTop "},
+		{8, "-size 40x30 xc:#abcdef -orient LeftBottom "},
+	}
+	for _, test := range tests {
+		info := &MediaInfoV2{Width: 40, Height: 30, Signature: "abcdef", Ext: "jpg", Orientation: test.orientation}
+		output := filepath.Join(dir, "canvas.jpg")
+		err := ImageMagick6Backend{Convert: convert}.MakeNullImage(context.Background(), NewShrinker(), info,
+			NewFileSink(output))
+		if err != nil {
+			t.Fatal(err)
+		}
+		args, err := os.ReadFile(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := test.want + output + "\n"; string(args) != want {
+			t.Errorf("got %q in orientation %d, want %q", strings.TrimSpace(string(args)), test.orientation, want)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package mediashrink
+
+import (
+	"bytes"
+	"compress/lzw"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	goimage "image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+)
+
+// imageGenerator write a null image of info into w natively without ImageMagick
+type imageGenerator func(w io.Writer, info *MediaInfoV2) error
+
+// maxUint16Dimension max width & height of formats storing dimension in 16 bits like JPEG & GIF
+const maxUint16Dimension = 0xFFFF
+
+var errImageTooLarge = errors.New("image is too large to generate natively")
+
+// solidImage an image in a single color, pixels are never allocated,
+// so the encoders which read pixels row by row write images in any size with little memory
+type solidImage struct {
+	color color.RGBA
+	rect  goimage.Rectangle
+}
+
+// ColorModel implements image.Image
+func (m *solidImage) ColorModel() color.Model { return color.RGBAModel }
+
+// Bounds implements image.Image
+func (m *solidImage) Bounds() goimage.Rectangle { return m.rect }
+
+// At implements image.Image
+func (m *solidImage) At(x, y int) color.Color { return m.color }
+
+// Opaque let the png encoder skip checking every pixel
+func (m *solidImage) Opaque() bool { return true }
+
+// signatureColor convert the 6 hex signature into a color
+func signatureColor(signature string) (color.RGBA, error) {
+	rgb, err := hex.DecodeString(validateSignature(signature))
+	if err != nil || len(rgb) != 3 {
+		return color.RGBA{}, fmt.Errorf("%w %s for color", ErrInvalidSignature, signature)
+	}
+	return color.RGBA{rgb[0], rgb[1], rgb[2], 0xFF}, nil
+}
+
+// newSolidImage make a solid image of info's dimension in info's signature color
+func newSolidImage(info *MediaInfoV2) (*solidImage, error) {
+	c, err := signatureColor(info.Signature)
+	if err != nil {
+		return nil, err
+	}
+	return &solidImage{color: c, rect: goimage.Rect(0, 0, int(info.Width), int(info.Height))}, nil
+}
+
+// makeNullPNG write a null png into w
+func makeNullPNG(w io.Writer, info *MediaInfoV2) error {
+	m, err := newSolidImage(info)
+	if err != nil {
+		return err
+	}
+	encoder := &png.Encoder{CompressionLevel: png.BestCompression}
+	return encoder.Encode(w, m)
+}
+
+// makeNullJPEG write a null jpeg into w
+func makeNullJPEG(w io.Writer, info *MediaInfoV2) error {
+	if info.Width > maxUint16Dimension || info.Height > maxUint16Dimension {
+		return errImageTooLarge
+	}
+	m, err := newSolidImage(info)
+	if err != nil {
+		return err
+	}
+	if info.Orientation > 1 {
+		w = &jpegEXIFWriter{w: w, soi: 2, segment: exifOrientationSegment(info.Orientation)}
+	}
+	return jpeg.Encode(w, m, nil)
+}
+
+// jpegEXIFWriter insert an APP1 segment right after the SOI marker written by image/jpeg
+type jpegEXIFWriter struct {
+	w       io.Writer
+	soi     int // bytes of the SOI marker not written yet
+	segment []byte
+}
+
+func (e *jpegEXIFWriter) Write(p []byte) (int, error) {
+	n := 0
+	if e.segment != nil {
+		n = e.soi
+		if n > len(p) {
+			n = len(p)
+		}
+		if _, err := e.w.Write(p[:n]); err != nil {
+			return 0, err
+		}
+		if e.soi -= n; e.soi > 0 {
+			return n, nil
+		}
+		if _, err := e.w.Write(e.segment); err != nil {
+			return n, err
+		}
+		e.segment = nil
+	}
+	m, err := e.w.Write(p[n:])
+	return n + m, err
+}
+
+// exifOrientationSegment make an Exif APP1 segment with the orientation tag only
+func exifOrientationSegment(orientation int) []byte {
+	// FF E1 length(2) "Exif\0\0", then a big endian TIFF: "MM" 42 IFD offset(4),
+	// IFD of entry count(2) a SHORT entry of tag(2) type(2) count(4) value(4), next IFD offset(4)
+	tiffHeader := []byte{'M', 'M', 0, 42, 0, 0, 0, 8}
+	ifd := []byte{0, 1, tiffTagOrientation >> 8, tiffTagOrientation & 0xFF, 0, tiffTypeShort, 0, 0, 0, 1,
+		0, byte(orientation), 0, 0, 0, 0, 0, 0}
+	length := 2 + len(exifHeader) + len(tiffHeader) + len(ifd)
+	segment := []byte{0xFF, 0xE1, byte(length >> 8), byte(length)}
+	segment = append(segment, exifHeader...)
+	segment = append(segment, tiffHeader...)
+	return append(segment, ifd...)
+}
+
+// makeNullBMP write a null bmp into w
+func makeNullBMP(w io.Writer, info *MediaInfoV2) error {
+	m, err := newSolidImage(info)
+	if err != nil {
+		return err
+	}
+	return bmp.Encode(w, m)
+}
+
+// makeNullTIFF write a null tiff into w, the deflate compressed pixels are buffered in memory,
+// which is tiny for a single color image
+func makeNullTIFF(w io.Writer, info *MediaInfoV2) error {
+	m, err := newSolidImage(info)
+	if err != nil {
+		return err
+	}
+	if info.Orientation <= 1 {
+		return tiff.Encode(w, m, &tiff.Options{Compression: tiff.Deflate})
+	}
+	var buf bytes.Buffer
+	if err := tiff.Encode(&buf, m, &tiff.Options{Compression: tiff.Deflate}); err != nil {
+		return err
+	}
+	data, err := addTIFFOrientation(buf.Bytes(), info.Orientation)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// addTIFFOrientation append a copy of the 1st IFD of a classic TIFF with an orientation entry inserted in order
+// of tags & point the header at it, the values & pixels stay where they are, so every offset in the file is kept
+func addTIFFOrientation(data []byte, orientation int) ([]byte, error) {
+	var order binary.ByteOrder
+	switch {
+	case len(data) < 8:
+		return nil, errNotTIFF
+	case data[0] == 'I' && data[1] == 'I':
+		order = binary.LittleEndian
+	case data[0] == 'M' && data[1] == 'M':
+		order = binary.BigEndian
+	default:
+		return nil, errNotTIFF
+	}
+	ifdOffset := order.Uint32(data[4:8])
+	if uint64(ifdOffset)+2 > uint64(len(data)) {
+		return nil, errNotTIFF
+	}
+	count := uint32(order.Uint16(data[ifdOffset:]))
+	entriesEnd := ifdOffset + 2 + count*12
+	if uint64(entriesEnd)+4 > uint64(len(data)) || uint64(len(data))+uint64(count+1)*12+7 > 0xFFFFFFFF {
+		return nil, errNotTIFF
+	}
+
+	const entrySize = 12
+	out := make([]byte, 0, len(data)+1+2+int(count+1)*entrySize+4)
+	out = append(out, data...)
+	if len(out)%2 != 0 { // IFDs begin on a word boundary
+		out = append(out, 0)
+	}
+	order.PutUint32(out[4:8], uint32(len(out)))
+	out = append(out, 0, 0)
+	order.PutUint16(out[len(out)-2:], uint16(count+1))
+	entry := make([]byte, entrySize)
+	order.PutUint16(entry[0:2], tiffTagOrientation)
+	order.PutUint16(entry[2:4], tiffTypeShort)
+	order.PutUint32(entry[4:8], 1)
+	order.PutUint16(entry[8:10], uint16(orientation))
+	inserted := false
+	for i := uint32(0); i < count; i++ {
+		e := data[ifdOffset+2+i*entrySize : ifdOffset+2+(i+1)*entrySize]
+		tag := order.Uint16(e[0:2])
+		if tag == tiffTagOrientation {
+			return nil, errors.New("orientation is presented in the tiff")
+		}
+		if !inserted && tag > tiffTagOrientation {
+			out, inserted = append(out, entry...), true
+		}
+		out = append(out, e...)
+	}
+	if !inserted {
+		out = append(out, entry...)
+	}
+	// the next IFD
+	return append(out, data[entriesEnd:entriesEnd+4]...), nil
+}
+
+// makeNullGIF write a null gif into w, image/gif is not used since it converts the image to a paletted one
+// in full size, pixels are LZW compressed row by row here instead
+func makeNullGIF(w io.Writer, info *MediaInfoV2) error {
+	if info.Width > maxUint16Dimension || info.Height > maxUint16Dimension {
+		return errImageTooLarge
+	}
+	c, err := signatureColor(info.Signature)
+	if err != nil {
+		return err
+	}
+
+	// header, logical screen descriptor with a 2 colors global color table
+	header := make([]byte, 0, 32)
+	header = append(header, "GIF89a"...)
+	header = appendUint16LE(header, uint16(info.Width))
+	header = appendUint16LE(header, uint16(info.Height))
+	header = append(header, 0xF0, 0x00, 0x00)       // global color table in 2 colors, background index, aspect
+	header = append(header, c.R, c.G, c.B, 0, 0, 0) // global color table
+	// image descriptor: separator, left, top, width, height, no local color table
+	header = append(header, 0x2C, 0, 0, 0, 0)
+	header = appendUint16LE(header, uint16(info.Width))
+	header = appendUint16LE(header, uint16(info.Height))
+	header = append(header, 0x00)
+	// LZW minimum code size
+	const litWidth = 2
+	header = append(header, litWidth)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	blocks := &gifBlockWriter{w: w}
+	compressor := lzw.NewWriter(blocks, lzw.LSB, litWidth)
+	row := make([]byte, info.Width) // every pixel is the color index 0
+	for y := uint32(0); y < info.Height; y++ {
+		if _, err := compressor.Write(row); err != nil {
+			return err
+		}
+	}
+	if err := compressor.Close(); err != nil {
+		return err
+	}
+	if err := blocks.Close(); err != nil {
+		return err
+	}
+	// trailer
+	_, err = w.Write([]byte{0x3B})
+	return err
+}
+
+// gifBlockWriter split data into GIF sub-blocks of 255 bytes at most
+type gifBlockWriter struct {
+	w   io.Writer
+	buf [256]byte
+	n   int
+}
+
+// Write implements io.Writer
+func (b *gifBlockWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(b.buf[1+b.n:], p)
+		b.n += n
+		p = p[n:]
+		written += n
+		if b.n == 255 {
+			if err := b.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// flush write the buffered sub-block
+func (b *gifBlockWriter) flush() error {
+	if b.n == 0 {
+		return nil
+	}
+	b.buf[0] = byte(b.n)
+	_, err := b.w.Write(b.buf[:1+b.n])
+	b.n = 0
+	return err
+}
+
+// Close flush the last sub-block and write the block terminator
+func (b *gifBlockWriter) Close() error {
+	if err := b.flush(); err != nil {
+		return err
+	}
+	_, err := b.w.Write([]byte{0x00})
+	return err
+}
+
+// appendUint16LE append v in little endian
+func appendUint16LE(b []byte, v uint16) []byte {
+	var buf [2]byte
+	binary.LittleEndian.PutUint16(buf[:], v)
+	return append(b, buf[:]...)
+}
@@ -0,0 +1,156 @@
+package mediashrink
+
+import (
+	"bytes"
+	"errors"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"testing"
+
+	"golang.org/x/image/tiff"
+)
+
+func TestAddTIFFOrientation(t *testing.T) {
+	tests := []struct {
+		fixture     string
+		orientation int
+	}{
+		{"rgb.tiff", 6}, // BitsPerSample & the strip after the IFD
+		{"rgb.tiff", 8},
+		{"gray.tiff", 3}, // the strip before the IFD in big endian
+		{"gray.tiff", 5},
+	}
+	for _, test := range tests {
+		t.Run(test.fixture, func(t *testing.T) {
+			data := readFixture(t, test.fixture)
+			original, err := tiff.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			oriented, err := addTIFFOrientation(data, test.orientation)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			info, err := getImageTIFFInfo(bytes.NewReader(oriented), int64(len(oriented)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			bounds := original.Bounds()
+			if info.Width != uint32(bounds.Dx()) || info.Height != uint32(bounds.Dy()) ||
+				info.Orientation != test.orientation {
+				t.Errorf("got %dx%d in orientation %d, want %dx%d in orientation %d", info.Width, info.Height,
+					info.Orientation, bounds.Dx(), bounds.Dy(), test.orientation)
+			}
+			decoded, err := tiff.Decode(bytes.NewReader(oriented))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+				for x := bounds.Min.X; x < bounds.Max.X; x++ {
+					if got, want := color.RGBAModel.Convert(decoded.At(x, y)),
+						color.RGBAModel.Convert(original.At(x, y)); got != want {
+						t.Errorf("got %v at (%d, %d), want %v", got, x, y, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestAddTIFFOrientationInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"orientation presented", nil},
+		{"not tiff", []byte("GIF89a\x01\x00\x01\x00")},
+		{"IFD out of the file", []byte("II\x2A\x00\xFF\x00\x00\x00")},
+		{"entries out of the file", []byte("MM\x00\x2A\x00\x00\x00\x08\x00\x02\x01\x00")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := test.data
+			if data == nil {
+				data = readFixture(t, "oriented.tiff")
+			}
+			if _, err := addTIFFOrientation(data, 2); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
+
+func TestMakeNullGIF(t *testing.T) {
+	tests := []struct {
+		width, height uint32
+	}{
+		{1, 1},
+		{7, 3},
+		{255, 1},    // pixels in a single full sub-block
+		{300, 300},  // codes beyond the 4096 of the LZW table, which is cleared
+		{4097, 2},   // a row longer than the LZW table
+		{1, 5000},   // rows shorter than the LZW minimum code size
+		{2000, 100}, // many sub-blocks
+	}
+	want := color.RGBA{0x12, 0x34, 0x56, 0xFF}
+	for _, test := range tests {
+		info := &MediaInfoV2{Width: test.width, Height: test.height, Signature: "123456"}
+		var buf bytes.Buffer
+		if err := makeNullGIF(&buf, info); err != nil {
+			t.Fatalf("%dx%d: %v", test.width, test.height, err)
+		}
+		m, err := gif.Decode(&buf)
+		if err != nil {
+			t.Fatalf("%dx%d: %v", test.width, test.height, err)
+		}
+		if bounds := m.Bounds(); bounds.Dx() != int(test.width) || bounds.Dy() != int(test.height) {
+			t.Errorf("got %dx%d, want %dx%d", bounds.Dx(), bounds.Dy(), test.width, test.height)
+			continue
+		}
+		for y := 0; y < int(test.height); y++ {
+			for x := 0; x < int(test.width); x++ {
+				if got := color.RGBAModel.Convert(m.At(x, y)); got != want {
+					t.Fatalf("%dx%d: got %v at (%d, %d), want %v", test.width, test.height, got, x, y, want)
+				}
+			}
+		}
+	}
+	err := makeNullGIF(&bytes.Buffer{}, &MediaInfoV2{Width: 1 << 16, Height: 1, Signature: "123456"})
+	if !errors.Is(err, errImageTooLarge) {
+		t.Errorf("got error %v, want %v", err, errImageTooLarge)
+	}
+}
+
+func TestMakeNullImageOrientation(t *testing.T) {
+	for _, ext := range []string{"jpg", "tif"} {
+		for _, orientation := range []int{0, 1, 3, 6, 8} {
+			info := &MediaInfoV2{Width: 40, Height: 30, Signature: "abcdef", Ext: ext, Orientation: orientation}
+			var buf bytes.Buffer
+			if err := imageGenerators[ext](&buf, info); err != nil {
+				t.Fatalf("%s in orientation %d: %v", ext, orientation, err)
+			}
+			decode := jpeg.Decode
+			if ext == "tif" {
+				decode = tiff.Decode
+			}
+			if _, err := decode(bytes.NewReader(buf.Bytes())); err != nil {
+				t.Fatalf("%s in orientation %d: %v", ext, orientation, err)
+			}
+			probed, err := imageHeaderParsers[ext](bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatalf("%s in orientation %d: %v", ext, orientation, err)
+			}
+			// the top-left orientation 1 is not written, which is the same as none
+			want := orientation
+			if want == 1 {
+				want = 0
+			}
+			if probed.Width != 40 || probed.Height != 30 || probed.Orientation != want {
+				t.Errorf("%s: got %dx%d in orientation %d, want 40x30 in orientation %d",
+					ext, probed.Width, probed.Height, probed.Orientation, want)
+			}
+		}
+	}
+}
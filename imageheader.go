@@ -0,0 +1,273 @@
+package mediashrink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+var (
+	errNotPNG  = errors.New("not a PNG file")
+	errNotJPEG = errors.New("not a JPEG file")
+	errNotGIF  = errors.New("not a GIF file")
+	errNotBMP  = errors.New("not a BMP file")
+	errNotTIFF = errors.New("not a TIFF file")
+	errNotICO  = errors.New("not an ICO file")
+
+	pngIHDR    = []byte{0x49, 0x48, 0x44, 0x52}
+	exifHeader = []byte("Exif\x00\x00")
+)
+
+// getImagePNGInfo optimized info getter for png, compatible with apple's CgBI file format
+func getImagePNGInfo(r io.ReaderAt, size int64) (*MediaInfoV2, error) {
+	isPNG := func(header []byte) bool {
+		return len(header) > 3 &&
+			header[0] == 0x89 && header[1] == 0x50 &&
+			header[2] == 0x4E && header[3] == 0x47
+	}
+	headerSize := int64(maxFileHeaderSize)
+	if headerSize > size {
+		headerSize = size
+	}
+	header := make([]byte, headerSize)
+	if err := readAt(r, header, 0); err != nil {
+		return nil, err
+	}
+	if !isPNG(header) {
+		return nil, errNotPNG
+	}
+
+	// get index of "IHDR", image width and height are followed with it
+	ihdrIndex := bytes.Index(header, pngIHDR)
+	if ihdrIndex <= 0 || ihdrIndex+len(pngIHDR)+8 > len(header) {
+		return nil, errNotPNG
+	}
+	widthIndex := ihdrIndex + len(pngIHDR)
+	// big endian
+	width := binary.BigEndian.Uint32(header[widthIndex : widthIndex+4])
+	height := binary.BigEndian.Uint32(header[widthIndex+4 : widthIndex+8])
+	return &MediaInfoV2{Width: width, Height: height}, nil
+}
+
+// getImageJPEGInfo get jpeg dimension from its SOFn (start of frame) segment,
+// and the orientation from the Exif APP1 segment before it
+func getImageJPEGInfo(r io.ReaderAt, size int64) (*MediaInfoV2, error) {
+	orientation := 0
+	buf := make([]byte, 9)
+	if err := readAt(r, buf[:2], 0); err != nil || buf[0] != 0xFF || buf[1] != 0xD8 {
+		return nil, errNotJPEG
+	}
+	// every segment is 0xFF, marker, 2 bytes big endian length including itself, payload
+	for offset := int64(2); offset+4 <= size; {
+		if err := readAt(r, buf[:4], offset); err != nil {
+			return nil, err
+		}
+		if buf[0] != 0xFF {
+			return nil, errNotJPEG
+		}
+		marker := buf[1]
+		switch {
+		case marker == 0xFF: // fill byte
+			offset++
+			continue
+		case marker == 0x01 || (0xD0 <= marker && marker <= 0xD7): // standalone markers without length
+			offset += 2
+			continue
+		case marker == 0xD9 || marker == 0xDA: // EOI or SOS before any SOFn
+			return nil, errNotJPEG
+		case 0xC0 <= marker && marker <= 0xCF && marker != 0xC4 && marker != 0xC8 && marker != 0xCC:
+			// SOFn: length(2), precision(1), height(2), width(2)
+			if err := readAt(r, buf, offset+2); err != nil {
+				return nil, err
+			}
+			height := uint32(binary.BigEndian.Uint16(buf[3:5]))
+			width := uint32(binary.BigEndian.Uint16(buf[5:7]))
+			if height == 0 { // height defined later by the DNL marker
+				return nil, errNotJPEG
+			}
+			return &MediaInfoV2{Width: width, Height: height, Orientation: orientation}, nil
+		}
+		segmentLength := int64(binary.BigEndian.Uint16(buf[2:4]))
+		if segmentLength < 2 {
+			return nil, errNotJPEG
+		}
+		if marker == 0xE1 && orientation == 0 {
+			orientation = readJPEGOrientation(r, offset+4, segmentLength-2)
+		}
+		offset += 2 + segmentLength
+	}
+	return nil, errNotJPEG
+}
+
+// readJPEGOrientation read the orientation from the APP1 payload at offset, which is "Exif\0\0" followed by a TIFF,
+// 0 if it is not presented
+func readJPEGOrientation(r io.ReaderAt, offset, size int64) int {
+	header := make([]byte, len(exifHeader))
+	if size <= int64(len(exifHeader)) || readAt(r, header, offset) != nil || !bytes.Equal(header, exifHeader) {
+		return 0
+	}
+	tiffSize := size - int64(len(exifHeader))
+	info, err := getImageTIFFInfo(io.NewSectionReader(r, offset+int64(len(exifHeader)), tiffSize), tiffSize)
+	if err != nil {
+		return 0
+	}
+	return info.Orientation
+}
+
+// getImageGIFInfo get gif dimension from its logical screen descriptor
+func getImageGIFInfo(r io.ReaderAt, size int64) (*MediaInfoV2, error) {
+	header := make([]byte, 10)
+	if err := readAt(r, header, 0); err != nil {
+		return nil, errNotGIF
+	}
+	if !bytes.Equal(header[:6], []byte("GIF87a")) && !bytes.Equal(header[:6], []byte("GIF89a")) {
+		return nil, errNotGIF
+	}
+	width := uint32(binary.LittleEndian.Uint16(header[6:8]))
+	height := uint32(binary.LittleEndian.Uint16(header[8:10]))
+	return &MediaInfoV2{Width: width, Height: height}, nil
+}
+
+// getImageBMPInfo get bmp dimension from its DIB header
+func getImageBMPInfo(r io.ReaderAt, size int64) (*MediaInfoV2, error) {
+	// file header (14 bytes), DIB header size (4 bytes), then width & height
+	header := make([]byte, 26)
+	if err := readAt(r, header, 0); err != nil || header[0] != 'B' || header[1] != 'M' {
+		return nil, errNotBMP
+	}
+	if dibSize := binary.LittleEndian.Uint32(header[14:18]); dibSize == 12 {
+		// BITMAPCOREHEADER with 16 bits unsigned width & height
+		width := uint32(binary.LittleEndian.Uint16(header[18:20]))
+		height := uint32(binary.LittleEndian.Uint16(header[20:22]))
+		return &MediaInfoV2{Width: width, Height: height}, nil
+	} else if dibSize < 40 {
+		return nil, errNotBMP
+	}
+	// BITMAPINFOHEADER and later with 32 bits signed width & height, negative height for top-down bitmaps
+	width := int32(binary.LittleEndian.Uint32(header[18:22]))
+	height := int32(binary.LittleEndian.Uint32(header[22:26]))
+	if width < 0 || height == math.MinInt32 {
+		return nil, errNotBMP
+	} else if height < 0 {
+		height = -height
+	}
+	return &MediaInfoV2{Width: uint32(width), Height: uint32(height)}, nil
+}
+
+const (
+	tiffTagImageWidth  = 256
+	tiffTagImageLength = 257
+	tiffTagOrientation = 274
+
+	tiffTypeShort = 3
+	tiffTypeLong  = 4
+	tiffTypeLong8 = 16
+)
+
+// getImageTIFFInfo get tiff (and BigTIFF) dimension & orientation from tags of its 1st IFD (image file directory)
+func getImageTIFFInfo(r io.ReaderAt, size int64) (*MediaInfoV2, error) {
+	header := make([]byte, 16)
+	if err := readAt(r, header[:8], 0); err != nil {
+		return nil, errNotTIFF
+	}
+	var order binary.ByteOrder
+	switch string(header[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, errNotTIFF
+	}
+
+	// classic TIFF: 2 bytes entry count, 12 bytes entries: tag(2) type(2) count(4) value(4)
+	// BigTIFF: 8 bytes entry count, 20 bytes entries: tag(2) type(2) count(8) value(8)
+	var ifdOffset, entryCount int64
+	countSize, entrySize, valueOffset := int64(2), int64(12), int64(8)
+	switch order.Uint16(header[2:4]) {
+	case 42:
+		ifdOffset = int64(order.Uint32(header[4:8]))
+	case 43:
+		if err := readAt(r, header, 0); err != nil {
+			return nil, errNotTIFF
+		}
+		ifdOffset = int64(order.Uint64(header[8:16]))
+		countSize, entrySize, valueOffset = 8, 20, 12
+	default:
+		return nil, errNotTIFF
+	}
+	if ifdOffset <= 0 || ifdOffset+countSize > size {
+		return nil, errNotTIFF
+	}
+	if err := readAt(r, header[:countSize], ifdOffset); err != nil {
+		return nil, err
+	}
+	if countSize == 2 {
+		entryCount = int64(order.Uint16(header[:2]))
+	} else {
+		entryCount = int64(order.Uint64(header[:8]))
+	}
+	if entryCount > (size-ifdOffset-countSize)/entrySize {
+		return nil, errNotTIFF
+	}
+
+	info := &MediaInfoV2{}
+	entry := make([]byte, entrySize)
+	for i := int64(0); i < entryCount; i++ {
+		if err := readAt(r, entry, ifdOffset+countSize+i*entrySize); err != nil {
+			return nil, err
+		}
+		tag := order.Uint16(entry[0:2])
+		if tag != tiffTagImageWidth && tag != tiffTagImageLength && tag != tiffTagOrientation {
+			continue
+		}
+		value := entry[valueOffset:]
+		var v uint32
+		switch order.Uint16(entry[2:4]) {
+		case tiffTypeShort:
+			v = uint32(order.Uint16(value))
+		case tiffTypeLong:
+			v = order.Uint32(value)
+		case tiffTypeLong8:
+			v = uint32(order.Uint64(value))
+		default:
+			return nil, errNotTIFF
+		}
+		switch tag {
+		case tiffTagImageWidth:
+			info.Width = v
+		case tiffTagImageLength:
+			info.Height = v
+		case tiffTagOrientation:
+			if v >= 1 && v <= 8 {
+				info.Orientation = int(v)
+			}
+		}
+	}
+	return info, nil
+}
+
+// getImageICOInfo get ico dimension from its 1st directory entry
+func getImageICOInfo(r io.ReaderAt, size int64) (*MediaInfoV2, error) {
+	// reserved(2) type(2) count(2), then 16 bytes entries: width(1) height(1) ...
+	header := make([]byte, 8)
+	if err := readAt(r, header, 0); err != nil {
+		return nil, errNotICO
+	}
+	if binary.LittleEndian.Uint16(header[0:2]) != 0 ||
+		(binary.LittleEndian.Uint16(header[2:4]) != 1 && binary.LittleEndian.Uint16(header[2:4]) != 2) ||
+		binary.LittleEndian.Uint16(header[4:6]) == 0 {
+		return nil, errNotICO
+	}
+	// 0 means 256 pixels
+	width, height := uint32(header[6]), uint32(header[7])
+	if width == 0 {
+		width = 256
+	}
+	if height == 0 {
+		height = 256
+	}
+	return &MediaInfoV2{Width: width, Height: height}, nil
+}
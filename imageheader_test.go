@@ -0,0 +1,260 @@
+package mediashrink
+
+import (
+	"bytes"
+	"encoding/binary"
+	goimage "image"
+	"image/jpeg"
+	"reflect"
+	"testing"
+)
+
+// jpegSegment a JPEG segment of marker with the payload
+func jpegSegment(marker byte, payload string) string {
+	length := make([]byte, 2)
+	binary.BigEndian.PutUint16(length, uint16(len(payload)+2))
+	return "\xFF" + string([]byte{marker}) + string(length) + payload
+}
+
+// jpegSOF the payload of a SOFn segment of 8 bits precision with a single component
+func jpegSOF(width, height uint16) string {
+	payload := make([]byte, 9)
+	payload[0] = 8
+	binary.BigEndian.PutUint16(payload[1:3], height)
+	binary.BigEndian.PutUint16(payload[3:5], width)
+	payload[5], payload[6], payload[7] = 1, 1, 0x11
+	return string(payload)
+}
+
+// exifOrientation the payload of an Exif APP1 segment of a big endian TIFF with the orientation only
+func exifOrientation(orientation byte) string {
+	return "Exif\x00\x00MM\x00\x2A\x00\x00\x00\x08\x00\x01" +
+		"\x01\x12\x00\x03\x00\x00\x00\x01\x00" + string([]byte{orientation}) + "\x00\x00\x00\x00\x00\x00"
+}
+
+func TestGetImageJPEGInfo(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, goimage.NewGray(goimage.Rect(0, 0, 33, 17)), nil); err != nil {
+		t.Fatal(err)
+	}
+	jfif := jpegSegment(0xE0, "JFIF\x00\x01\x02\x00\x00\x01\x00\x01\x00\x00")
+	tests := []struct {
+		name string
+		data string
+		want *MediaInfoV2
+	}{
+		{"encoded", encoded.String(), &MediaInfoV2{Width: 33, Height: 17}},
+		{"baseline", "\xFF\xD8" + jfif + jpegSegment(0xC0, jpegSOF(640, 480)), &MediaInfoV2{Width: 640, Height: 480}},
+		{"progressive after DHT", "\xFF\xD8" + jpegSegment(0xC4, "\x00") + jpegSegment(0xC2, jpegSOF(4000, 3000)),
+			&MediaInfoV2{Width: 4000, Height: 3000}},
+		{"fill bytes & RST", "\xFF\xD8\xFF\xFF\xFF\xD0" + jpegSegment(0xC1, jpegSOF(1, 65535)),
+			&MediaInfoV2{Width: 1, Height: 65535}},
+		{"orientation", "\xFF\xD8" + jpegSegment(0xE1, exifOrientation(6)) + jpegSegment(0xC0, jpegSOF(640, 480)),
+			&MediaInfoV2{Width: 640, Height: 480, Orientation: 6}},
+		{"orientation after JFIF", "\xFF\xD8" + jfif + jpegSegment(0xE1, exifOrientation(8)) +
+			jpegSegment(0xC0, jpegSOF(64, 48)), &MediaInfoV2{Width: 64, Height: 48, Orientation: 8}},
+		{"XMP in APP1", "\xFF\xD8" + jpegSegment(0xE1, "http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>") +
+			jpegSegment(0xC0, jpegSOF(64, 48)), &MediaInfoV2{Width: 64, Height: 48}},
+		{"invalid orientation", "\xFF\xD8" + jpegSegment(0xE1, exifOrientation(9)) +
+			jpegSegment(0xC0, jpegSOF(64, 48)), &MediaInfoV2{Width: 64, Height: 48}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info, err := getImageJPEGInfo(bytes.NewReader([]byte(test.data)), int64(len(test.data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(info, test.want) {
+				t.Errorf("got %+v, want %+v", info, test.want)
+			}
+		})
+	}
+}
+
+func TestGetImageJPEGInfoInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"no SOI", "\x89PNG\r\n\x1a\n"},
+		{"SOS before SOF", "\xFF\xD8" + jpegSegment(0xDA, "\x00") + jpegSegment(0xC0, jpegSOF(64, 48))},
+		{"EOI before SOF", "\xFF\xD8\xFF\xD9"},
+		{"height in DNL", "\xFF\xD8" + jpegSegment(0xC0, jpegSOF(64, 0))},
+		{"no marker", "\xFF\xD8\x00\x00\x00\x00"},
+		{"invalid segment length", "\xFF\xD8\xFF\xE0\x00\x01"},
+		{"truncated SOF", "\xFF\xD8\xFF\xC0\x00\x11\x08\x00"},
+		{"no SOF", "\xFF\xD8" + jpegSegment(0xE0, "JFIF\x00")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if info, err := getImageJPEGInfo(bytes.NewReader([]byte(test.data)), int64(len(test.data))); err == nil {
+				t.Errorf("got %+v, want an error", info)
+			}
+		})
+	}
+}
+
+func TestGetImageGIFInfo(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want *MediaInfoV2
+	}{
+		{"GIF89a", "GIF89a\x40\x01\xF0\x00\x80\x00\x00", &MediaInfoV2{Width: 320, Height: 240}},
+		{"GIF87a", "GIF87a\xFF\xFF\x01\x00", &MediaInfoV2{Width: 65535, Height: 1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info, err := getImageGIFInfo(bytes.NewReader([]byte(test.data)), int64(len(test.data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(info, test.want) {
+				t.Errorf("got %+v, want %+v", info, test.want)
+			}
+		})
+	}
+	for _, data := range []string{"GIF90a\x40\x01\xF0\x00", "GIF89a\x40\x01"} {
+		if info, err := getImageGIFInfo(bytes.NewReader([]byte(data)), int64(len(data))); err == nil {
+			t.Errorf("got %+v of %q, want an error", info, data)
+		}
+	}
+}
+
+// bmpHeader the file header & the start of a DIB header of dibSize with width & height
+func bmpHeader(dibSize uint32, width, height int32) string {
+	header := make([]byte, 26)
+	copy(header, "BM")
+	binary.LittleEndian.PutUint32(header[14:18], dibSize)
+	if dibSize == 12 {
+		binary.LittleEndian.PutUint16(header[18:20], uint16(width))
+		binary.LittleEndian.PutUint16(header[20:22], uint16(height))
+	} else {
+		binary.LittleEndian.PutUint32(header[18:22], uint32(width))
+		binary.LittleEndian.PutUint32(header[22:26], uint32(height))
+	}
+	return string(header)
+}
+
+func TestGetImageBMPInfo(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want *MediaInfoV2
+	}{
+		{"BITMAPINFOHEADER", bmpHeader(40, 640, 480), &MediaInfoV2{Width: 640, Height: 480}},
+		{"top-down", bmpHeader(40, 640, -480), &MediaInfoV2{Width: 640, Height: 480}},
+		{"BITMAPV5HEADER", bmpHeader(124, 1, 1), &MediaInfoV2{Width: 1, Height: 1}},
+		{"largest top-down", bmpHeader(108, 3, -2147483647), &MediaInfoV2{Width: 3, Height: 2147483647}},
+		{"BITMAPCOREHEADER", bmpHeader(12, 65535, 2), &MediaInfoV2{Width: 65535, Height: 2}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info, err := getImageBMPInfo(bytes.NewReader([]byte(test.data)), int64(len(test.data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(info, test.want) {
+				t.Errorf("got %+v, want %+v", info, test.want)
+			}
+		})
+	}
+}
+
+func TestGetImageBMPInfoInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"not bmp", "GIF89a" + bmpHeader(40, 1, 1)[6:]},
+		{"unknown DIB header", bmpHeader(16, 640, 480)},
+		{"negative width", bmpHeader(40, -640, 480)},
+		{"height of MinInt32", bmpHeader(40, 640, -2147483648)},
+		{"truncated", bmpHeader(40, 640, 480)[:20]},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if info, err := getImageBMPInfo(bytes.NewReader([]byte(test.data)), int64(len(test.data))); err == nil {
+				t.Errorf("got %+v, want an error", info)
+			}
+		})
+	}
+}
+
+func TestGetImageICOInfo(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want *MediaInfoV2
+	}{
+		{"icon", "\x00\x00\x01\x00\x02\x00\x10\x20", &MediaInfoV2{Width: 16, Height: 32}},
+		{"cursor", "\x00\x00\x02\x00\x01\x00\x30\x30", &MediaInfoV2{Width: 48, Height: 48}},
+		{"256 pixels", "\x00\x00\x01\x00\x01\x00\x00\x00", &MediaInfoV2{Width: 256, Height: 256}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info, err := getImageICOInfo(bytes.NewReader([]byte(test.data)), int64(len(test.data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(info, test.want) {
+				t.Errorf("got %+v, want %+v", info, test.want)
+			}
+		})
+	}
+	for _, data := range []string{
+		"\x01\x00\x01\x00\x01\x00\x10\x10", // reserved
+		"\x00\x00\x03\x00\x01\x00\x10\x10", // type
+		"\x00\x00\x01\x00\x00\x00\x10\x10", // no images
+		"\x00\x00\x01\x00\x01\x00",         // truncated
+	} {
+		if info, err := getImageICOInfo(bytes.NewReader([]byte(data)), int64(len(data))); err == nil {
+			t.Errorf("got %+v of %q, want an error", info, data)
+		}
+	}
+}
+
+func TestGetImageTIFFInfo(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    *MediaInfoV2
+	}{
+		{"rgb.tiff", &MediaInfoV2{Width: 2, Height: 2}},                      // little endian in SHORT
+		{"gray.tiff", &MediaInfoV2{Width: 3, Height: 1}},                     // big endian in LONG
+		{"oriented.tiff", &MediaInfoV2{Width: 5, Height: 7, Orientation: 6}}, // LONG width & SHORT height
+		{"big.tiff", &MediaInfoV2{Width: 70000, Height: 3, Orientation: 3}},  // BigTIFF in LONG8, LONG & SHORT
+	}
+	for _, test := range tests {
+		t.Run(test.fixture, func(t *testing.T) {
+			r := bytes.NewReader(readFixture(t, test.fixture))
+			info, err := getImageTIFFInfo(r, r.Size())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(info, test.want) {
+				t.Errorf("got %+v, want %+v", info, test.want)
+			}
+		})
+	}
+}
+
+func TestGetImageTIFFInfoInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"no byte order", []byte("XX\x2A\x00\x08\x00\x00\x00")},
+		{"unknown version", []byte("II\x2C\x00\x08\x00\x00\x00")},
+		{"IFD out of the file", []byte("II\x2A\x00\xFF\x00\x00\x00")},
+		{"entries out of the file", []byte("II\x2A\x00\x08\x00\x00\x00\x02\x00\x00\x01\x03\x00")},
+		{"dimension in RATIONAL", []byte("MM\x00\x2A\x00\x00\x00\x08\x00\x01" +
+			"\x01\x00\x00\x05\x00\x00\x00\x01\x00\x00\x00\x00")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := getImageTIFFInfo(bytes.NewReader(test.data), int64(len(test.data))); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package mediashrink
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// StreamType type of a stream in a container
+type StreamType string
+
+// stream types, the other streams like data & attachments are not kept
+const (
+	StreamVideo    StreamType = "video"
+	StreamAudio    StreamType = "audio"
+	StreamSubtitle StreamType = "subtitle"
+)
+
+// Stream a stream in the layout of a container, see MediaInfoV2.Streams
+type Stream struct {
+	Type     StreamType `json:"type"`
+	Language string     `json:"language,omitempty"` // ISO 639-2 code like eng, empty if undetermined
+	Title    string     `json:"title,omitempty"`
+	// Disposition names of the disposition flags set in ffmpeg like default & forced, sorted
+	Disposition []string `json:"disposition,omitempty"`
+}
+
+// Chapter a chapter marker of a media
+type Chapter struct {
+	Start time.Duration `json:"start"` // in ns in JSON
+	End   time.Duration `json:"end"`
+	Title string        `json:"title,omitempty"`
+}
+
+// defaultStreams the layout of videos made when the stream layout is unknown, a video with an audio
+var defaultStreams = []Stream{{Type: StreamVideo}, {Type: StreamAudio}}
+
+// streamTypeKeys short keys of the stream types in the v2 encoding
+var streamTypeKeys = map[StreamType]string{
+	StreamVideo:    "v",
+	StreamAudio:    "a",
+	StreamSubtitle: "s",
+}
+
+// normalizeLanguage language of a stream, the undetermined "und" is empty
+func normalizeLanguage(language string) string {
+	if language = strings.TrimSpace(language); language == "und" {
+		return ""
+	}
+	return language
+}
+
+// newDisposition sort & dedupe the disposition names set
+func newDisposition(names ...string) []string {
+	var disposition []string
+	for _, name := range names {
+		if len(name) > 0 && !containsString(disposition, name) {
+			disposition = append(disposition, name)
+		}
+	}
+	sort.Strings(disposition)
+	return disposition
+}
+
+// containsString check if s is in list
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// fillChapterEnds set the missing ends of chapters to the start of the next one, or to duration for the last one
+func fillChapterEnds(chapters []Chapter, duration time.Duration) {
+	for i := range chapters {
+		if chapters[i].End > chapters[i].Start {
+			continue
+		}
+		if i+1 < len(chapters) {
+			chapters[i].End = chapters[i+1].Start
+		} else {
+			chapters[i].End = duration
+		}
+	}
+}
+
+// formatStream encode stream in type[:language[:disposition[:title]]] for the v2 encoding,
+// like a:eng:default,forced:Commentary, the title is the last one as it may contain colons
+func formatStream(stream Stream) string {
+	fields := []string{streamTypeKeys[stream.Type], stream.Language, strings.Join(stream.Disposition, ","), stream.Title}
+	for len(fields) > 1 && len(fields[len(fields)-1]) == 0 {
+		fields = fields[:len(fields)-1]
+	}
+	return strings.Join(fields, ":")
+}
+
+// parseStream parse a stream encoded by formatStream
+func parseStream(str string) (Stream, error) {
+	fields := strings.SplitN(str, ":", 4)
+	stream := Stream{}
+	for t, key := range streamTypeKeys {
+		if key == fields[0] {
+			stream.Type = t
+		}
+	}
+	if len(stream.Type) == 0 {
+		return Stream{}, fmt.Errorf("unknown stream type in %q", str)
+	}
+	if len(fields) > 1 {
+		stream.Language = fields[1]
+	}
+	if len(fields) > 2 && len(fields[2]) > 0 {
+		stream.Disposition = newDisposition(strings.Split(fields[2], ",")...)
+	}
+	if len(fields) > 3 {
+		stream.Title = fields[3]
+	}
+	return stream, nil
+}
+
+// formatChapter encode chapter in start-end[:title] for the v2 encoding, like 0s-1m30s:Opening
+func formatChapter(chapter Chapter) string {
+	str := chapter.Start.String() + "-" + chapter.End.String()
+	if len(chapter.Title) > 0 {
+		str += ":" + chapter.Title
+	}
+	return str
+}
+
+// parseChapter parse a chapter encoded by formatChapter
+func parseChapter(str string) (Chapter, error) {
+	fields := strings.SplitN(str, ":", 2)
+	times := strings.SplitN(fields[0], "-", 2)
+	if len(times) != 2 {
+		return Chapter{}, fmt.Errorf("no start-end in chapter %q", str)
+	}
+	chapter := Chapter{}
+	var err error
+	if chapter.Start, err = time.ParseDuration(times[0]); err != nil {
+		return Chapter{}, err
+	}
+	if chapter.End, err = time.ParseDuration(times[1]); err != nil {
+		return Chapter{}, err
+	}
+	if len(fields) > 1 {
+		chapter.Title = fields[1]
+	}
+	return chapter, nil
+}
@@ -0,0 +1,155 @@
+package mediashrink
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFillChapterEnds(t *testing.T) {
+	chapters := []Chapter{
+		{Start: 0, End: 0, Title: "Intro"},
+		{Start: 10 * time.Second, End: 20 * time.Second},
+		{Start: 30 * time.Second, End: 30 * time.Second},
+		{Start: 40 * time.Second},
+	}
+	fillChapterEnds(chapters, time.Minute)
+	want := []Chapter{
+		{Start: 0, End: 10 * time.Second, Title: "Intro"},
+		{Start: 10 * time.Second, End: 20 * time.Second},
+		{Start: 30 * time.Second, End: 40 * time.Second},
+		{Start: 40 * time.Second, End: time.Minute},
+	}
+	if !reflect.DeepEqual(chapters, want) {
+		t.Errorf("got %v, want %v", chapters, want)
+	}
+}
+
+func TestFFmetadataChapters(t *testing.T) {
+	chapters := []Chapter{
+		{Start: 0, End: 1500 * time.Millisecond, Title: "Op: a=b; #1\\2\nend"},
+		{Start: 1500 * time.Millisecond, End: time.Minute},
+	}
+	want := ";FFMETADATA1\n" +
+		"[CHAPTER]\nTIMEBASE=1/1000000000\nSTART=0\nEND=1500000000\ntitle=Op: a\\=b\\; \\#1\\\\2\\\nend\n" +
+		"[CHAPTER]\nTIMEBASE=1/1000000000\nSTART=1500000000\nEND=60000000000\n"
+	if got := string(ffmetadataChapters(chapters)); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestNewVideoLayout(t *testing.T) {
+	video, audio := []string{"-f", "lavfi", "-i", "color"}, []string{"-f", "lavfi", "-i", "anullsrc"}
+	streams := []Stream{
+		{Type: StreamVideo, Disposition: []string{"default"}},
+		{Type: StreamAudio, Language: "jpn", Title: "Director", Disposition: []string{"comment", "default"}},
+		{Type: StreamAudio, Language: "eng"},
+		{Type: StreamSubtitle, Language: "fre", Disposition: []string{"forced"}},
+	}
+	chapters := []Chapter{{Start: 0, End: time.Second, Title: "Intro"}, {Start: time.Second, End: 2 * time.Second}}
+	tests := []struct {
+		name     string
+		ext      string
+		streams  []Stream
+		chapters []Chapter
+		want     string // the args with the paths of the subtitle & chapters replaced by SRT & FFMETADATA
+		dropped  int
+	}{
+		{name: "default", ext: "mp4", want: "-f lavfi -i color -f lavfi -i anullsrc"},
+		{
+			name:    "streams",
+			ext:     "mkv",
+			streams: streams,
+			want: "-f lavfi -i color -f lavfi -i anullsrc -f srt -i SRT " +
+				"-map 0:0 -disposition:0 default " +
+				"-map 1:0 -metadata:s:1 language=jpn -metadata:s:1 title=Director -disposition:1 comment+default " +
+				"-map 1:0 -metadata:s:2 language=eng -disposition:2 0 " +
+				"-map 2:0 -metadata:s:3 language=fre -disposition:3 forced",
+		},
+		{
+			name:    "subtitles dropped",
+			ext:     "avi",
+			streams: streams,
+			want: "-f lavfi -i color -f lavfi -i anullsrc " +
+				"-map 0:0 -disposition:0 default " +
+				"-map 1:0 -metadata:s:1 language=jpn -metadata:s:1 title=Director -disposition:1 comment+default " +
+				"-map 1:0 -metadata:s:2 language=eng -disposition:2 0",
+			dropped: 1,
+		},
+		{
+			name:    "single stream of each type",
+			ext:     "FLV",
+			streams: streams,
+			want: "-f lavfi -i color -f lavfi -i anullsrc " +
+				"-map 0:0 -disposition:0 default " +
+				"-map 1:0 -metadata:s:1 language=jpn -metadata:s:1 title=Director -disposition:1 comment+default",
+			dropped: 2,
+		},
+		{
+			name:     "video only with chapters",
+			ext:      "mp4",
+			streams:  []Stream{{Type: StreamVideo}},
+			chapters: chapters,
+			want:     "-f lavfi -i color -f ffmetadata -i FFMETADATA -map 0:0 -disposition:0 0 -map_chapters 1",
+		},
+		{
+			name:     "default streams with chapters",
+			ext:      "mkv",
+			chapters: chapters,
+			want: "-f lavfi -i color -f lavfi -i anullsrc -f ffmetadata -i FFMETADATA " +
+				"-map 0:0 -disposition:0 0 -map 1:0 -disposition:1 0 -map_chapters 2",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			s := NewShrinker(WithTempDir(t.TempDir()), WithLogger(log.New(&logs, "", 0)))
+			vInfo := &MediaInfoV2{Width: 64, Height: 48, Duration: 2 * time.Second, Signature: "abcdef", Ext: test.ext,
+				Streams: test.streams, Chapters: test.chapters}
+			layout, err := newVideoLayout(s, vInfo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			args := strings.Join(layout.args(video, audio), " ")
+			if len(layout.subtitlePath) > 0 {
+				subtitle, err := os.ReadFile(layout.subtitlePath)
+				if err != nil || string(subtitle) != "1\n00:00:00,000 --> 00:00:00,001\nabcdef\n" {
+					t.Errorf("got subtitle %q with error %v", subtitle, err)
+				}
+				args = strings.Replace(args, layout.subtitlePath, "SRT", 1)
+			}
+			if len(layout.chaptersPath) > 0 {
+				metadata, err := os.ReadFile(layout.chaptersPath)
+				if err != nil || string(metadata) != string(ffmetadataChapters(chapters)) {
+					t.Errorf("got chapters %q with error %v", metadata, err)
+				}
+				args = strings.Replace(args, layout.chaptersPath, "FFMETADATA", 1)
+			}
+			if args != test.want {
+				t.Errorf("got args %q, want %q", args, test.want)
+			}
+			if dropped := strings.Count(logs.String(), "is dropped"); dropped != test.dropped {
+				t.Errorf("got %d streams dropped, want %d", dropped, test.dropped)
+			}
+			layout.release()
+			if entries, err := os.ReadDir(s.tempDir); err != nil || len(entries) != 0 {
+				t.Errorf("got %d files left in the temp dir with error %v", len(entries), err)
+			}
+		})
+	}
+}
+
+func TestReadChapterTrackWithoutFFmpeg(t *testing.T) {
+	var logs bytes.Buffer
+	s := NewShrinker(WithVideoBackends(NativeVideoBackend{}), WithLogger(log.New(&logs, "", 0)))
+	info := &MediaInfoV2{Duration: time.Minute, chapterTrack: true}
+	s.readChapterTrack(context.Background(), NewFileSource("video.mov", "mov"), info)
+	if len(info.Chapters) != 0 || !strings.Contains(logs.String(), "chapters of video.mov are dropped") {
+		t.Errorf("got chapters %v with logs %q, want them dropped with a warning", info.Chapters, logs.String())
+	}
+}
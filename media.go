@@ -1,8 +1,11 @@
 package mediashrink
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"io"
+	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -11,33 +14,45 @@ import (
 	"github.com/haxii/filetype/matchers"
 )
 
-func isImage(ext string) bool {
-	_, exists := image[ext]
-	return exists
+func isKind(ext string, kind MediaKind) bool {
+	t, exists := lookupMediaType(ext)
+	return exists && t.kind == kind
 }
 
-func isPNG(ext string) bool {
-	_, exists := imagePNG[ext]
-	return exists
+func isImage(ext string) bool {
+	return isKind(ext, KindImage)
 }
 
 func isVideo(ext string) bool {
-	_, exists := video[ext]
-	return exists
+	return isKind(ext, KindVideo)
 }
 
 func isAudio(ext string) bool {
-	_, exists := audio[ext]
+	return isKind(ext, KindAudio)
+}
+
+func isArchive(ext string) bool {
+	_, exists := archive[ext]
+	return exists
+}
+
+func isWritableArchive(ext string) bool {
+	_, exists := archiveWritable[ext]
 	return exists
 }
 
-// MediaInfo shows the media's dimension & duration
+// MediaInfo shows the media's dimension & duration, see MediaInfoV2 for the media info in full
 type MediaInfo struct {
-	Width     uint32
-	Height    uint32
-	Duration  uint32 // in ms
-	Signature string
-	Ext       string
+	Width     uint32 `json:"width"`
+	Height    uint32 `json:"height"`
+	Duration  uint32 `json:"duration"` // in ms
+	Signature string `json:"signature"`
+	Ext       string `json:"ext"`
+}
+
+// normalizeRotation convert degrees in any direction into the clockwise 0, 90, 180 or 270
+func normalizeRotation(degrees float64) int {
+	return (int(math.Round(degrees/90))%4 + 4) % 4 * 90
 }
 
 // ToString convert MediaInfo To String width[x]height[x]duration[x]signature[.]ext
@@ -49,7 +64,42 @@ func (info *MediaInfo) ToString() string {
 // GetMediaInfo return the MediaInfo if path is a valid media, otherwise return null.
 // sig: hex string in min length of 6, should be a MD5 string normally,
 // set guessMissingExt to true to guess the media type when no ext presented in path.
+// media longer than ~49 days fail with ErrDurationOverflow, probe them with GetMediaInfoV2.
 func GetMediaInfo(sig string, guessMissingExt bool, path string) (*MediaInfo, error) {
+	return defaultShrinker().GetMediaInfoContext(context.Background(), sig, guessMissingExt, path)
+}
+
+// GetMediaInfoContext is like GetMediaInfo but kills identify & ffprobe when ctx is done,
+// a *TimeoutError is returned if the deadline of ctx is exceeded.
+func GetMediaInfoContext(ctx context.Context, sig string, guessMissingExt bool, path string) (*MediaInfo, error) {
+	return defaultShrinker().GetMediaInfoContext(ctx, sig, guessMissingExt, path)
+}
+
+// GetMediaInfo return the MediaInfo if path is a valid media, otherwise return null,
+// the signature is made by the SignatureFunc of s when sig is empty, see GetMediaInfo.
+func (s *Shrinker) GetMediaInfo(sig string, guessMissingExt bool, path string) (*MediaInfo, error) {
+	return s.GetMediaInfoContext(context.Background(), sig, guessMissingExt, path)
+}
+
+// GetMediaInfoContext is like GetMediaInfo but kills identify & ffprobe when ctx is done,
+// a *TimeoutError is returned if the deadline of ctx or the timeout of s is exceeded.
+func (s *Shrinker) GetMediaInfoContext(ctx context.Context, sig string, guessMissingExt bool, path string) (*MediaInfo, error) {
+	info, err := s.getMediaInfo(ctx, sig, guessMissingExt, path, false)
+	if err != nil {
+		return nil, err
+	}
+	// longer media fail instead of being capped, which are probed by GetMediaInfoV2
+	v1, err := info.V1()
+	if err != nil {
+		return nil, fmt.Errorf("failed get media info of %s with err %w", path, err)
+	}
+	return v1, nil
+}
+
+// getMediaInfo get the media info of path in full precision with its stream layout if layout is set,
+// see GetMediaInfo
+func (s *Shrinker) getMediaInfo(ctx context.Context, sig string, guessMissingExt bool,
+	path string, layout bool) (*MediaInfoV2, error) {
 	ext := filepath.Ext(path)
 	if len(ext) > 1 {
 		ext = strings.ToLower(ext[1:])
@@ -57,73 +107,116 @@ func GetMediaInfo(sig string, guessMissingExt bool, path string) (*MediaInfo, er
 		ext = guessExt(path)
 	}
 	if len(ext) == 0 {
-		return nil, ErrUnknownMediaType
+		return nil, fmt.Errorf("%w: %s", ErrUnknownMediaType, path)
 	}
 
 	if len(sig) == 0 {
-		if md5, err := fileMD5(path); err == nil {
-			sig = md5
+		if fileSig, err := s.signatureFunc(path); err == nil {
+			sig = fileSig
 		} else {
 			return nil, err
 		}
 	}
 	signature := validateSignature(sig)
 	if len(signature) == 0 {
-		return nil, fmt.Errorf("wrong signature %s for file %s", sig, path)
+		return nil, fmt.Errorf("%w %s for file %s", ErrInvalidSignature, sig, path)
 	}
 
-	var mediaInfo *MediaInfo
-	var err error
-	if isPNG(ext) { //test image in a more fast and compatible way
-		if mediaInfo, err = getImagePNGInfo(path); err != nil {
-			return nil, err
-		} else if mediaInfo.Width <= 0 || mediaInfo.Height <= 0 {
-			return nil, ErrUnknownMediaType
-		}
-	} else if isImage(ext) {
-		if mediaInfo, err = getImageInfo(path); err != nil {
-			return nil, err
-		} else if mediaInfo.Width <= 0 || mediaInfo.Height <= 0 {
-			return nil, ErrUnknownMediaType
+	return s.probe(ctx, signature, Source{path: path, ext: ext, layout: layout})
+}
+
+// probe get the media info of src using the prober registered for its ext
+func (s *Shrinker) probe(ctx context.Context, signature string, src Source) (*MediaInfoV2, error) {
+	t, exists := lookupMediaType(src.ext)
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownMediaType, src.Name())
+	}
+	mediaInfo, err := t.prober(ctx, s, src)
+	if err != nil {
+		return nil, err
+	}
+	switch t.kind {
+	case KindImage:
+		if mediaInfo.Width <= 0 || mediaInfo.Height <= 0 {
+			return nil, fmt.Errorf("%w: no dimension in %s", ErrUnknownMediaType, src.Name())
 		}
-	} else if isVideo(ext) {
-		if mediaInfo, err = getVideoInfo(path); err != nil {
-			return nil, err
-		} else if mediaInfo.Width <= 0 || mediaInfo.Height <= 0 || mediaInfo.Duration <= 0 {
-			return nil, ErrUnknownMediaType
+	case KindVideo:
+		if mediaInfo.Width <= 0 || mediaInfo.Height <= 0 || mediaInfo.Duration <= 0 {
+			return nil, fmt.Errorf("%w: no dimension or duration in %s", ErrUnknownMediaType, src.Name())
 		}
-	} else if isAudio(ext) {
-		if mediaInfo, err = getAudioInfo(path); err != nil {
-			return nil, err
-		} else if mediaInfo.Duration <= 0 {
-			return nil, ErrUnknownMediaType
+	case KindAudio:
+		if mediaInfo.Duration <= 0 {
+			return nil, fmt.Errorf("%w: no duration in %s", ErrUnknownMediaType, src.Name())
 		}
-	} else {
-		return nil, ErrUnknownMediaType
 	}
-	mediaInfo.Ext = ext
+	mediaInfo.Ext = src.ext
 	mediaInfo.Signature = signature
 	return mediaInfo, nil
 }
 
 // Shrink makes a shrink media using info
 func (info *MediaInfo) Shrink(outputPath string) error {
-	var err error
+	return defaultShrinker().ShrinkContext(context.Background(), info, outputPath)
+}
+
+// ShrinkContext is like Shrink but kills convert & ffmpeg when ctx is done,
+// a *TimeoutError is returned if the deadline of ctx is exceeded.
+func (info *MediaInfo) ShrinkContext(ctx context.Context, outputPath string) error {
+	return defaultShrinker().ShrinkContext(ctx, info, outputPath)
+}
+
+// Shrink makes a shrink media using info, the media is made next to outputPath
+// or in the temp dir of s before moving to outputPath
+func (s *Shrinker) Shrink(info *MediaInfo, outputPath string) error {
+	return s.ShrinkContext(context.Background(), info, outputPath)
+}
+
+// ShrinkContext is like Shrink but kills convert & ffmpeg when ctx is done,
+// a *TimeoutError is returned if the deadline of ctx or the timeout of s is exceeded.
+func (s *Shrinker) ShrinkContext(ctx context.Context, info *MediaInfo, outputPath string) error {
+	return s.ShrinkV2Context(ctx, info.V2(), outputPath)
+}
+
+// ShrinkV2 is like Shrink but makes the media of info in full precision, with the rotation, orientation,
+// stream layout, chapters & codecs of info kept unless configured otherwise by options
+func (s *Shrinker) ShrinkV2(info *MediaInfoV2, outputPath string, options ...ShrinkOption) error {
+	return s.ShrinkV2Context(context.Background(), info, outputPath, options...)
+}
+
+// ShrinkV2Context is like ShrinkV2 but kills convert & ffmpeg when ctx is done.
+func (s *Shrinker) ShrinkV2Context(ctx context.Context, info *MediaInfoV2, outputPath string,
+	options ...ShrinkOption) error {
+	info = applyShrinkOptions(info, options)
 	safeOutputPath := outputPath + "." + info.Ext
-	if isImage(info.Ext) {
-		err = info.makeNullImage(safeOutputPath)
-	} else if isVideo(info.Ext) {
-		err = info.makeNullVideo(safeOutputPath)
-	} else if isAudio(info.Ext) {
-		err = info.makeNullAudio(safeOutputPath)
+	if len(s.tempDir) > 0 {
+		workDir, err := ioutil.TempDir(s.tempDir, "mediashrink")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(workDir)
+		safeOutputPath = filepath.Join(workDir, "shrink."+info.Ext)
 	}
-	if err != nil {
+	if err := s.makeNullMedia(ctx, info, Sink{path: safeOutputPath}); err != nil {
 		return err
 	}
-	if _, err := os.Stat(safeOutputPath); err == nil {
-		return os.Rename(safeOutputPath, outputPath)
+	if _, err := os.Stat(safeOutputPath); err != nil {
+		return fmt.Errorf("%w %s", ErrUnsupportedFormat, info.ToString())
+	}
+	if s.verify {
+		if err := s.verifyMedia(ctx, safeOutputPath, info); err != nil {
+			os.Remove(safeOutputPath)
+			return err
+		}
+	}
+	return moveFile(safeOutputPath, outputPath)
+}
+
+// makeNullMedia make a null media using info into sink with the generator registered for its ext
+func (s *Shrinker) makeNullMedia(ctx context.Context, info *MediaInfoV2, sink Sink) error {
+	if t, exists := lookupMediaType(info.Ext); exists {
+		return t.generator(ctx, s, info, sink)
 	}
-	return fmt.Errorf("unsupported media format %s", info.ToString())
+	return fmt.Errorf("%w %s", ErrUnsupportedFormat, info.ToString())
 }
 
 func validateSignature(s string) string {
@@ -145,28 +238,28 @@ func guessExt(filePath string) string {
 		if err != nil {
 			return err
 		}
-		for ext, matcher := range image {
-			if matcher(header) {
-				guessedExt = ext
-				return nil
-			}
-		}
-		for ext, matcher := range video {
-			if matcher(header) {
-				guessedExt = ext
-				return nil
-			}
-		}
-		for ext, matcher := range audio {
-			if matcher(header) {
-				guessedExt = ext
-				return nil
-			}
-		}
+		guessedExt = guessExtFromHeader(header)
 		return nil
 	}); err != nil {
 		return ""
 	}
+	return guessedExt
+}
+
+// guessExtFromHeader guess if header is of a supported media, if so return the ext, otherwise nil string
+func guessExtFromHeader(header []byte) string {
+	guessedExt := ""
+	for _, kind := range []MediaKind{KindImage, KindVideo, KindAudio} {
+		for _, t := range mediaTypes(kind) {
+			if t.matcher != nil && t.matcher(header) {
+				guessedExt = t.ext
+				break
+			}
+		}
+		if len(guessedExt) > 0 {
+			break
+		}
+	}
 	// uniform the jpg extensions
 	if guessedExt == "jpe" || guessedExt == "jpeg" {
 		guessedExt = "jpg"
@@ -174,8 +267,16 @@ func guessExt(filePath string) string {
 	return guessedExt
 }
 
-// MediaInfoFromString convert String width[x]height[x]duration[[x]signature[.]ext To MediaInfo
+// MediaInfoFromString convert String width[x]height[x]duration[[x]signature[.]ext To MediaInfo,
+// strings in the v2 encoding of MediaInfoV2.ToString are converted as well, see ParseMediaInfo
 func MediaInfoFromString(str string) (*MediaInfo, error) {
+	if strings.HasPrefix(str, mediaInfoV2Prefix) {
+		info, err := ParseMediaInfo(str)
+		if err != nil {
+			return nil, err
+		}
+		return info.V1()
+	}
 	heightIndex := 0
 	durationIndex := 0
 	signatureIndex := 0
@@ -203,16 +304,16 @@ func MediaInfoFromString(str string) (*MediaInfo, error) {
 		signatureIndex <= durationIndex+1 ||
 		extIndex <= signatureIndex+1 ||
 		len(str) <= extIndex+1 {
-		return nil, fmt.Errorf("error occurred when convert %s to media info", str)
+		return nil, &ParseError{Output: []byte(str), Err: errors.New("not in width[x]height[x]duration[x]signature[.]ext")}
 	}
 
-	info := &MediaInfo{0, 0, 0, "", ""}
+	info := &MediaInfo{}
 	// width
 	widthStr := str[0:heightIndex]
 	if i, err := strconv.Atoi(widthStr); err == nil {
 		info.Width = uint32(i)
 	} else {
-		return nil, fmt.Errorf("error occurred when convert %s to int:%s", widthStr, err)
+		return nil, &ParseError{Output: []byte(str), Err: err}
 	}
 	// height
 	heightStr := str[heightIndex+1 : durationIndex]
@@ -220,7 +321,7 @@ func MediaInfoFromString(str string) (*MediaInfo, error) {
 		info.Height = uint32(i)
 	} else {
 		info.Width = 0
-		return nil, fmt.Errorf("error occurred when convert %s to int:%s", heightStr, err)
+		return nil, &ParseError{Output: []byte(str), Err: err}
 	}
 	// duration
 	durationStr := str[durationIndex+1 : signatureIndex]
@@ -229,7 +330,7 @@ func MediaInfoFromString(str string) (*MediaInfo, error) {
 	} else {
 		info.Width = 0
 		info.Height = 0
-		return nil, fmt.Errorf("error occurred when convert %s to int:%s", durationStr, err)
+		return nil, &ParseError{Output: []byte(str), Err: err}
 	}
 	// signature
 	sigStr := validateSignature(str[signatureIndex+1 : extIndex])
@@ -239,79 +340,42 @@ func MediaInfoFromString(str string) (*MediaInfo, error) {
 		info.Width = 0
 		info.Height = 0
 		info.Duration = 0
-		return nil, fmt.Errorf("error occurred when convert %s to signature", sigStr)
+		return nil, &ParseError{Output: []byte(str), Err: ErrInvalidSignature}
 	}
 	// ext
 	info.Ext = str[extIndex+1:]
 	return info, nil
 }
 
-// CheckCompatibility check compatibility of ImageMagicK and FFMpeg
-func CheckCompatibility(output io.Writer, exportDir string) {
-	mediaList := map[string]*MediaInfo{}
-	duration := 5000
-	for imgExt := range image {
-		infoStr := "32x32x0x123456." + imgExt
-		if i, err := MediaInfoFromString(infoStr); err == nil {
-			mediaList[imgExt] = i
-		} else {
-			fmt.Fprintf(output, "failed to parse media info %s with error %s", infoStr, err)
-			return
-		}
-	}
-	for audioExt := range audio {
-		infoStr := "0x0x" + strconv.Itoa(duration) + "x123456." + audioExt
-		if a, err := MediaInfoFromString(infoStr); err == nil {
-			mediaList[audioExt] = a
-		} else {
-			fmt.Fprintf(output, "failed to parse media info %s with error %s", infoStr, err)
-			return
-		}
-	}
-	for videoExt := range video {
-		infoStr := "128x128x" + strconv.Itoa(duration) + "x123456." + videoExt
-		if v, err := MediaInfoFromString(infoStr); err == nil {
-			mediaList[videoExt] = v
-		} else {
-			fmt.Fprintf(output, "failed to parse media info %s with error %s", infoStr, err)
-			return
-		}
-	}
-
-	for ext, media := range mediaList {
-		sample := filepath.Join(exportDir, "shrink."+ext)
-		fmt.Fprintf(output, "generating %s to %s : ", ext, sample)
-		if err := media.Shrink(sample); err != nil {
-			fmt.Fprintf(output, "Failed with error %s\n", err)
-		} else {
-			fmt.Fprintf(output, "OKay\n")
-			fmt.Fprintln(output, "guessing ext of ", sample, ":", guessExt(sample))
-			fmt.Fprintf(output, "reading %s from %s :", ext, sample)
-			if info, err := GetMediaInfo("", false, sample); err != nil {
-				fmt.Fprintf(output, "Failed with error %s\n", err)
-			} else {
-				durationMargin := 0
-				if info.Duration > 0 {
-					durationMargin = int(info.Duration) - duration
-				}
-				fmt.Fprintln(output, "Okay:", info.ToString(), "duration margin: ", durationMargin, "ms")
-			}
-		}
-		os.Remove(sample)
-	}
-}
-
-// ImageMatchers image matchers
+// ImageMatchers a copy of the registered image matchers
 func ImageMatchers() map[string]matchers.Matcher {
-	return image
+	return mediaMatchers(KindImage)
 }
 
-// AudioMatchers audio matchers
+// AudioMatchers a copy of the registered audio matchers
 func AudioMatchers() map[string]matchers.Matcher {
-	return audio
+	return mediaMatchers(KindAudio)
 }
 
-// VideoMatchers video matchers
+// VideoMatchers a copy of the registered video matchers
 func VideoMatchers() map[string]matchers.Matcher {
-	return video
+	return mediaMatchers(KindVideo)
+}
+
+// ArchiveMatchers a copy of the archive matchers, archives are shrunk by ShrinkArchive
+func ArchiveMatchers() map[string]matchers.Matcher {
+	m := make(map[string]matchers.Matcher, len(archive))
+	for ext, matcher := range archive {
+		m[ext] = matcher
+	}
+	return m
+}
+
+// WritableArchiveMatchers a copy of the archive matchers which ShrinkArchive can write into
+func WritableArchiveMatchers() map[string]matchers.Matcher {
+	m := make(map[string]matchers.Matcher, len(archiveWritable))
+	for ext, matcher := range archiveWritable {
+		m[ext] = matcher
+	}
+	return m
 }
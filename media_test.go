@@ -0,0 +1,59 @@
+package mediashrink
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestGetMediaInfoDisplaySize(t *testing.T) {
+	// MediaInfo has no rotation or orientation, so the v1 API probes & makes media in the display dimension
+	s := NewShrinker(WithImageBackends(NativeImageBackend{}), WithVideoBackends(NativeVideoBackend{}))
+	tests := []struct {
+		fixture       string
+		width, height uint32
+	}{
+		{"rotated.mp4", 48, 64}, // 64x48 rotated by 90 degrees
+		{"oriented.tiff", 7, 5}, // 5x7 in the EXIF orientation 6
+	}
+	for _, test := range tests {
+		t.Run(test.fixture, func(t *testing.T) {
+			info, err := s.GetMediaInfo("123456", false, filepath.Join("testdata", test.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Width != test.width || info.Height != test.height {
+				t.Errorf("got %dx%d, want %dx%d", info.Width, info.Height, test.width, test.height)
+			}
+			if !isImage(info.Ext) {
+				return
+			}
+			output := filepath.Join(t.TempDir(), "shrunk."+info.Ext)
+			if err := s.Shrink(info, output); err != nil {
+				t.Fatal(err)
+			}
+			shrunk, err := s.GetMediaInfoV2("123456", false, output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if shrunk.Width != test.width || shrunk.Height != test.height || shrunk.Orientation != 0 {
+				t.Errorf("got %dx%d in orientation %d, want %dx%d without orientation",
+					shrunk.Width, shrunk.Height, shrunk.Orientation, test.width, test.height)
+			}
+		})
+	}
+}
+
+func TestNormalizeRotation(t *testing.T) {
+	tests := []struct {
+		degrees  float64
+		rotation int
+	}{
+		{0, 0}, {90, 90}, {180, 180}, {270, 270}, {360, 0}, {450, 90},
+		{-90, 270}, {-180, 180}, {-270, 90}, {89.9, 90}, {-90.1, 270},
+	}
+	for _, test := range tests {
+		if rotation := normalizeRotation(test.degrees); rotation != test.rotation {
+			t.Errorf("got %d of %v degrees, want %d", rotation, test.degrees, test.rotation)
+		}
+	}
+}
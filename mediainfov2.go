@@ -0,0 +1,243 @@
+package mediashrink
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+// Rational an exact rate like 30000/1001 frames per second, the zero value is an unknown rate
+type Rational struct {
+	Num int64
+	Den int64
+}
+
+// ParseRational parse a rate in "30000/1001", "25" or "29.97" as ffprobe prints it, "0/0" is an unknown rate
+func ParseRational(s string) (Rational, error) {
+	s = strings.TrimSpace(s)
+	if s == "0/0" {
+		return Rational{}, nil
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok || !r.Num().IsInt64() || !r.Denom().IsInt64() {
+		return Rational{}, fmt.Errorf("invalid rational %q", s)
+	}
+	return Rational{Num: r.Num().Int64(), Den: r.Denom().Int64()}, nil
+}
+
+// IsZero check if the rate is unknown
+func (r Rational) IsZero() bool {
+	return r.Num == 0 || r.Den == 0
+}
+
+// Float64 the rate in float, 0 if unknown
+func (r Rational) Float64() float64 {
+	if r.IsZero() {
+		return 0
+	}
+	return float64(r.Num) / float64(r.Den)
+}
+
+// String the rate in num/den like ffprobe
+func (r Rational) String() string {
+	return fmt.Sprintf("%d/%d", r.Num, r.Den)
+}
+
+// reduceRational the closest rational of num/den whose num & den are not greater than max like av_reduce of ffmpeg,
+// zero if num or den is not positive
+func reduceRational(num, den, max int64) Rational {
+	if num <= 0 || den <= 0 {
+		return Rational{}
+	}
+	r := new(big.Rat).SetFrac64(num, den)
+	if num, den = r.Num().Int64(), r.Denom().Int64(); num <= max && den <= max {
+		return Rational{Num: num, Den: den}
+	}
+	// the convergents of the continued fraction, with a semiconvergent for the last one
+	a0n, a0d, a1n, a1d := int64(0), int64(1), int64(1), int64(0)
+	for den != 0 {
+		x := num / den
+		a2n, a2d := x*a1n+a0n, x*a1d+a0d
+		if a2n > max || a2d > max {
+			if a1n != 0 {
+				x = (max - a0n) / a1n
+			}
+			if a1d != 0 && (max-a0d)/a1d < x {
+				x = (max - a0d) / a1d
+			}
+			if den*(2*x*a1d+a0d) > num*a1d {
+				a1n, a1d = x*a1n+a0n, x*a1d+a0d
+			}
+			break
+		}
+		a0n, a0d, a1n, a1d = a1n, a1d, a2n, a2d
+		num, den = den, num-den*x
+	}
+	return Rational{Num: a1n, Den: a1d}
+}
+
+// MediaInfoV2 media info in full precision, which holds durations longer than the ~49 days of MediaInfo,
+// with everything probed to make a placeholder alike, convert from & to MediaInfo with MediaInfo.V2
+// & MediaInfoV2.V1
+type MediaInfoV2 struct {
+	Width    uint32        `json:"width"`
+	Height   uint32        `json:"height"`
+	Duration time.Duration `json:"duration"`       // in ns in JSON
+	Size     int64         `json:"size,omitempty"` // size of the original media in bytes, 0 if unknown
+	// FrameRate the r_frame_rate of the 1st video stream, zero if unknown
+	FrameRate Rational `json:"frame_rate"`
+	// SampleRate of audios or the 1st audio stream of videos, zero if unknown
+	SampleRate Rational `json:"sample_rate"`
+	Signature  string   `json:"signature"`
+	Ext        string   `json:"ext"`
+	Backend    string   `json:"backend,omitempty"` // name of the backend which probed the media
+	// Rotation degrees to rotate the coded Width x Height clockwise for display of videos, 0, 90, 180 or 270
+	Rotation int `json:"rotation,omitempty"`
+	// Orientation EXIF orientation of JPEG & TIFF images from 1 to 8, 0 if not presented
+	Orientation int `json:"orientation,omitempty"`
+	// Streams layout of the video, audio & subtitle streams of videos in order, empty if unknown, which is made
+	// as a video stream with an audio stream
+	Streams []Stream `json:"streams,omitempty"`
+	// Chapters chapter markers of videos
+	Chapters []Chapter `json:"chapters,omitempty"`
+	// VideoCodec, VideoProfile & PixelFormat of the 1st video stream as ffprobe names them, like hevc, Main 10
+	// & yuv420p10le, empty if unknown, which are made in the default codec of the container
+	VideoCodec   string `json:"video_codec,omitempty"`
+	VideoProfile string `json:"video_profile,omitempty"`
+	PixelFormat  string `json:"pixel_format,omitempty"`
+	// AudioCodec, Channels, ChannelLayout & BitDepth of audios or the 1st audio stream of videos as ffprobe names
+	// them, like ac3, 6, 5.1(side) & 16, zero if unknown, which are made in the defaults of the container,
+	// the bit depth is known for PCM & lossless codecs only
+	AudioCodec    string `json:"audio_codec,omitempty"`
+	Channels      int    `json:"channels,omitempty"`
+	ChannelLayout string `json:"channel_layout,omitempty"`
+	BitDepth      int    `json:"bit_depth,omitempty"`
+
+	chapterTrack bool // the chapters are in a text track of MP4 / MOV, which are not parsed natively
+}
+
+// DisplaySize get the dimension as displayed, which is Height x Width for videos rotated by 90 or 270 degrees
+// and images in the EXIF orientation 5 to 8, which are transposed
+func (info *MediaInfoV2) DisplaySize() (uint32, uint32) {
+	if info.Rotation == 90 || info.Rotation == 270 || info.Orientation >= 5 {
+		return info.Height, info.Width
+	}
+	return info.Width, info.Height
+}
+
+// Upright make a copy of info in the display dimension without rotation or orientation,
+// shrink it to make a pre-rotated placeholder instead of one with the rotation or orientation in metadata
+func (info *MediaInfoV2) Upright() *MediaInfoV2 {
+	upright := *info
+	upright.Width, upright.Height = info.DisplaySize()
+	upright.Rotation, upright.Orientation = 0, 0
+	return &upright
+}
+
+// streams get the stream layout to make, the default one of a video stream with an audio stream if unknown
+func (info *MediaInfoV2) streams() []Stream {
+	if len(info.Streams) == 0 {
+		return defaultStreams
+	}
+	return info.Streams
+}
+
+// durationInfo a MediaInfoV2 of duration d only
+func durationInfo(d time.Duration) *MediaInfoV2 {
+	return &MediaInfoV2{Duration: d}
+}
+
+// setAudioFormat set codec, channels & sample rate of the audio stream with the default channel layout of the codec,
+// the bit depth is kept for PCM & lossless codecs only
+func (info *MediaInfoV2) setAudioFormat(codec string, channels int, sampleRate uint64, bitDepth int) {
+	info.AudioCodec, info.Channels = codec, channels
+	info.ChannelLayout = defaultChannelLayout(codec, channels)
+	if sampleRate > 0 && sampleRate <= math.MaxInt32 {
+		info.SampleRate = Rational{Num: int64(sampleRate), Den: 1}
+	}
+	if hasBitDepth(codec) {
+		info.BitDepth = bitDepth
+	}
+}
+
+// V2 convert info into a MediaInfoV2 of the duration in ms
+func (info *MediaInfo) V2() *MediaInfoV2 {
+	return &MediaInfoV2{
+		Width:     info.Width,
+		Height:    info.Height,
+		Duration:  time.Duration(info.Duration) * time.Millisecond,
+		Signature: info.Signature,
+		Ext:       info.Ext,
+	}
+}
+
+// V1 convert info into a MediaInfo in the display dimension, see Upright, which fails if the duration
+// does not fit in the uint32 ms of MediaInfo, the fields MediaInfo does not have are dropped
+func (info *MediaInfoV2) V1() (*MediaInfo, error) {
+	if info.Duration < 0 || info.Duration.Milliseconds() > math.MaxUint32 {
+		return nil, fmt.Errorf("%w: %s of %s", ErrDurationOverflow, info.Duration, info.ToString())
+	}
+	upright := info.Upright()
+	return &MediaInfo{
+		Width:     upright.Width,
+		Height:    upright.Height,
+		Duration:  uint32(info.Duration.Milliseconds()),
+		Signature: info.Signature,
+		Ext:       info.Ext,
+	}, nil
+}
+
+// Shrink makes a shrink media using info in full precision, see ShrinkV2 of Shrinker
+func (info *MediaInfoV2) Shrink(outputPath string, options ...ShrinkOption) error {
+	return defaultShrinker().ShrinkV2Context(context.Background(), info, outputPath, options...)
+}
+
+// ShrinkContext is like Shrink but kills convert & ffmpeg when ctx is done.
+func (info *MediaInfoV2) ShrinkContext(ctx context.Context, outputPath string, options ...ShrinkOption) error {
+	return defaultShrinker().ShrinkV2Context(ctx, info, outputPath, options...)
+}
+
+// ShrinkTo writes a shrink media using info in full precision into w, see ShrinkToV2 of Shrinker
+func (info *MediaInfoV2) ShrinkTo(w io.Writer, options ...ShrinkOption) error {
+	return defaultShrinker().ShrinkToV2Context(context.Background(), info, w, options...)
+}
+
+// ShrinkToContext is like ShrinkTo but kills convert & ffmpeg when ctx is done.
+func (info *MediaInfoV2) ShrinkToContext(ctx context.Context, w io.Writer, options ...ShrinkOption) error {
+	return defaultShrinker().ShrinkToV2Context(ctx, info, w, options...)
+}
+
+// GetMediaInfoV2 is like GetMediaInfo but returns the info in full precision with the size of path
+func GetMediaInfoV2(sig string, guessMissingExt bool, path string) (*MediaInfoV2, error) {
+	return defaultShrinker().GetMediaInfoV2Context(context.Background(), sig, guessMissingExt, path)
+}
+
+// GetMediaInfoV2Context is like GetMediaInfoV2 but kills identify & ffprobe when ctx is done.
+func GetMediaInfoV2Context(ctx context.Context, sig string, guessMissingExt bool, path string) (*MediaInfoV2, error) {
+	return defaultShrinker().GetMediaInfoV2Context(ctx, sig, guessMissingExt, path)
+}
+
+// GetMediaInfoV2 is like GetMediaInfo of s but returns the info in full precision with the size of path
+func (s *Shrinker) GetMediaInfoV2(sig string, guessMissingExt bool, path string) (*MediaInfoV2, error) {
+	return s.GetMediaInfoV2Context(context.Background(), sig, guessMissingExt, path)
+}
+
+// GetMediaInfoV2Context is like GetMediaInfoV2 but kills identify & ffprobe when ctx is done.
+func (s *Shrinker) GetMediaInfoV2Context(ctx context.Context, sig string, guessMissingExt bool,
+	path string) (*MediaInfoV2, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := s.getMediaInfo(ctx, sig, guessMissingExt, path, true)
+	if err != nil {
+		return nil, err
+	}
+	info.Size = fi.Size()
+	return info, nil
+}
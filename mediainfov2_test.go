@@ -0,0 +1,155 @@
+package mediashrink
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseRational(t *testing.T) {
+	tests := []struct {
+		s     string
+		want  Rational
+		float float64
+	}{
+		{"30000/1001", Rational{Num: 30000, Den: 1001}, 30000.0 / 1001},
+		{"25", Rational{Num: 25, Den: 1}, 25},
+		{" 29.97\n", Rational{Num: 2997, Den: 100}, 29.97},
+		{"50/2", Rational{Num: 25, Den: 1}, 25},
+		{"0/0", Rational{}, 0},
+	}
+	for _, test := range tests {
+		r, err := ParseRational(test.s)
+		if err != nil {
+			t.Errorf("got err %v of %q", err, test.s)
+		} else if r != test.want || r.Float64() != test.float || r.IsZero() != (test.float == 0) {
+			t.Errorf("got %s in %f of %q, want %s in %f", r, r.Float64(), test.s, test.want, test.float)
+		}
+	}
+	for _, s := range []string{"", "N/A", "1/0", "99999999999999999999/1"} {
+		if r, err := ParseRational(s); err == nil {
+			t.Errorf("got %s of %q, want an error", r, s)
+		}
+	}
+}
+
+func TestMediaInfoV1V2(t *testing.T) {
+	v1 := &MediaInfo{Width: 1920, Height: 1080, Duration: 1500, Signature: "abcdef", Ext: "mp4"}
+	v2 := v1.V2()
+	want := MediaInfoV2{Width: 1920, Height: 1080, Duration: 1500 * time.Millisecond, Signature: "abcdef", Ext: "mp4"}
+	if v2.Width != want.Width || v2.Height != want.Height || v2.Duration != want.Duration ||
+		v2.Signature != want.Signature || v2.Ext != want.Ext {
+		t.Errorf("got %s, want %s", v2.ToString(), want.ToString())
+	}
+	// durations are truncated into ms
+	v2.Duration += 999 * time.Microsecond
+	if back, err := v2.V1(); err != nil || *back != *v1 {
+		t.Errorf("got %v with err %v, want %s", back, err, v1.ToString())
+	}
+
+	for _, d := range []time.Duration{time.Duration(math.MaxUint32+1) * time.Millisecond, -time.Second} {
+		if _, err := (&MediaInfoV2{Duration: d, Ext: "mp4"}).V1(); !errors.Is(err, ErrDurationOverflow) {
+			t.Errorf("got err %v of %s, want %v", err, d, ErrDurationOverflow)
+		}
+	}
+	if back, err := (&MediaInfoV2{Duration: math.MaxUint32 * time.Millisecond}).V1(); err != nil ||
+		back.Duration != math.MaxUint32 {
+		t.Errorf("got %v with err %v, want the max duration", back, err)
+	}
+}
+
+func TestGetMediaInfoV2(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "silence.wav")
+	s := NewShrinker()
+	if err := s.Shrink(&MediaInfo{Duration: 5000, Signature: "abcdef", Ext: "wav"}, path); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := s.GetMediaInfoV2("123456", false, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Duration != 5*time.Second || info.Size != fi.Size() || info.Ext != "wav" || info.Signature != "123456" {
+		t.Errorf("got %s in %d bytes, want 5s in %d bytes", info.ToString(), info.Size, fi.Size())
+	}
+	if _, err := s.GetMediaInfoV2("123456", false, path+".missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got err %v, want %v", err, os.ErrNotExist)
+	}
+}
+
+func TestLongDuration(t *testing.T) {
+	// longer than the ~49 days of MediaInfo
+	const long = 60*24*time.Hour + time.Nanosecond
+	var made time.Duration
+	err := RegisterMediaType("mslong", KindVideo, nil,
+		func(ctx context.Context, s *Shrinker, src Source) (*MediaInfoV2, error) {
+			return &MediaInfoV2{Width: 2, Height: 2, Duration: long}, nil
+		},
+		func(ctx context.Context, s *Shrinker, info *MediaInfoV2, sink Sink) error {
+			made = info.Duration
+			return sink.Generate(info, func(w io.Writer, info *MediaInfoV2) error {
+				_, err := io.WriteString(w, "long")
+				return err
+			})
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "media.mslong")
+	if err := os.WriteFile(path, []byte("long"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := NewShrinker()
+	if _, err := s.GetMediaInfo("123456", false, path); !errors.Is(err, ErrDurationOverflow) {
+		t.Errorf("got err %v, want %v", err, ErrDurationOverflow)
+	}
+	info, err := s.GetMediaInfoV2("123456", false, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ShrinkV2(info, path+".shrunk"); err != nil || made != long {
+		t.Errorf("got %s made with err %v, want %s", made, err, long)
+	}
+}
+
+func TestDurationPrecision(t *testing.T) {
+	if d, err := getDurationFromBytes([]byte("36000.123456\n")); err != nil || d != 36000123456*time.Microsecond {
+		t.Errorf("got %s with err %v, want 10h0m0.123456s", d, err)
+	}
+	if d := durationOfSamples(44100*3600*24*60+1, 44100); d != 60*24*time.Hour+22675*time.Nanosecond {
+		t.Errorf("got %s of 60 days & a sample", d)
+	}
+}
+
+func TestReduceRational(t *testing.T) {
+	tests := []struct {
+		num, den, max int64
+		want          Rational
+	}{
+		{90000, 3003, math.MaxInt32, Rational{Num: 30000, Den: 1001}},
+		{15360, 512, math.MaxInt32, Rational{Num: 30, Den: 1}},
+		// the default durations of Matroska in ns are rounded
+		{1e9, 33366667, 30000, Rational{Num: 30000, Den: 1001}},
+		{1e9, 41708333, 30000, Rational{Num: 24000, Den: 1001}},
+		{1e9, 40000000, 30000, Rational{Num: 25, Den: 1}},
+		{1e9, 16683333, 60000, Rational{Num: 60000, Den: 1001}},
+		{355, 113, 100, Rational{Num: 22, Den: 7}}, // a convergent like av_reduce, not the closest 311/99
+		{1, 3, 2, Rational{Num: 1, Den: 2}},
+		{0, 1, 5, Rational{}},
+		{1, 0, 5, Rational{}},
+		{-1, 2, 5, Rational{}},
+	}
+	for _, test := range tests {
+		if got := reduceRational(test.num, test.den, test.max); got != test.want {
+			t.Errorf("got %v of %d/%d in %d, want %v", got, test.num, test.den, test.max, test.want)
+		}
+	}
+}
@@ -0,0 +1,604 @@
+package mediashrink
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+var errNotMKV = errors.New("not a Matroska / WebM file")
+
+// EBML element IDs used for probing, see https://www.matroska.org/technical/elements.html
+const (
+	ebmlIDHeader          = 0x1A45DFA3
+	ebmlIDSegment         = 0x18538067
+	ebmlIDSeekHead        = 0x114D9B74
+	ebmlIDSeek            = 0x4DBB
+	ebmlIDSeekID          = 0x53AB
+	ebmlIDSeekPosition    = 0x53AC
+	ebmlIDInfo            = 0x1549A966
+	ebmlIDTimecodeScale   = 0x2AD7B1
+	ebmlIDDuration        = 0x4489
+	ebmlIDTracks          = 0x1654AE6B
+	ebmlIDTrackEntry      = 0xAE
+	ebmlIDTrackType       = 0x83
+	ebmlIDName            = 0x536E
+	ebmlIDCodecID         = 0x86
+	ebmlIDCodecPrivate    = 0x63A2
+	ebmlIDDefaultDuration = 0x23E383
+	ebmlIDLanguage        = 0x22B59C
+	ebmlIDFlagDefault     = 0x88
+	ebmlIDFlagForced      = 0x55AA
+	ebmlIDFlagHearing     = 0x55AB
+	ebmlIDFlagVisual      = 0x55AC
+	ebmlIDFlagOriginal    = 0x55AE
+	ebmlIDFlagCommentary  = 0x55AF
+	ebmlIDVideo           = 0xE0
+	ebmlIDPixelWidth      = 0xB0
+	ebmlIDPixelHeight     = 0xBA
+	ebmlIDAudio           = 0xE1
+	ebmlIDSamplingFreq    = 0xB5
+	ebmlIDChannels        = 0x9F
+	ebmlIDBitDepth        = 0x6264
+	ebmlIDCluster         = 0x1F43B675
+	ebmlIDChapters        = 0x1043A770
+	ebmlIDEditionEntry    = 0x45B9
+	ebmlIDChapterAtom     = 0xB6
+	ebmlIDChapterStart    = 0x91
+	ebmlIDChapterEnd      = 0x92
+	ebmlIDChapterHidden   = 0x98
+	ebmlIDChapterDisplay  = 0x80
+	ebmlIDChapString      = 0x85
+	ebmlTrackTypeVideo    = 1
+	ebmlTrackTypeAudio    = 2
+	ebmlTrackTypeSubtitle = 0x11
+	ebmlDefaultTimescale  = 1000000 // in ns
+	ebmlMaxStringSize     = 1 << 16
+)
+
+// mkvTrackTypes stream types of the track types
+var mkvTrackTypes = map[uint64]StreamType{
+	ebmlTrackTypeVideo:    StreamVideo,
+	ebmlTrackTypeAudio:    StreamAudio,
+	ebmlTrackTypeSubtitle: StreamSubtitle,
+}
+
+// mkvDispositions disposition names of the track flags
+var mkvDispositions = map[uint32]string{
+	ebmlIDFlagDefault:    "default",
+	ebmlIDFlagForced:     "forced",
+	ebmlIDFlagHearing:    "hearing_impaired",
+	ebmlIDFlagVisual:     "visual_impaired",
+	ebmlIDFlagOriginal:   "original",
+	ebmlIDFlagCommentary: "comment",
+}
+
+// ebmlElement an EBML element header
+type ebmlElement struct {
+	ID     uint32
+	Offset int64 // offset of the element data
+	Size   int64 // size of the element data, -1 for unknown size
+}
+
+// readEBMLVint read a variable length integer at offset, returns the value & its length,
+// the length marker bit is kept for IDs and cleared for sizes, sizes with all value bits set are unknown (-1)
+func readEBMLVint(r io.ReaderAt, offset int64, isID bool) (int64, int64, error) {
+	buf := make([]byte, 8)
+	if err := readAt(r, buf[:1], offset); err != nil {
+		return 0, 0, err
+	}
+	length := 1
+	for mask := byte(0x80); length <= 8 && buf[0]&mask == 0; mask >>= 1 {
+		length++
+	}
+	if length > 8 || (isID && length > 4) {
+		return 0, 0, errNotMKV
+	}
+	if err := readAt(r, buf[1:length], offset+1); err != nil {
+		return 0, 0, err
+	}
+	value := uint64(buf[0])
+	if !isID {
+		value &= uint64(0xFF) >> uint(length)
+	}
+	allOnes := value == uint64(0xFF)>>uint(length)
+	for _, b := range buf[1:length] {
+		value = value<<8 | uint64(b)
+		allOnes = allOnes && b == 0xFF
+	}
+	if !isID && allOnes {
+		return -1, int64(length), nil
+	}
+	return int64(value), int64(length), nil
+}
+
+// readEBMLElement read the element header at offset
+func readEBMLElement(r io.ReaderAt, offset int64) (ebmlElement, error) {
+	id, idLength, err := readEBMLVint(r, offset, true)
+	if err != nil {
+		return ebmlElement{}, err
+	}
+	size, sizeLength, err := readEBMLVint(r, offset+idLength, false)
+	if err != nil {
+		return ebmlElement{}, err
+	}
+	return ebmlElement{ID: uint32(id), Offset: offset + idLength + sizeLength, Size: size}, nil
+}
+
+// readEBMLChildren read headers of child elements in [offset, end) without reading their data
+func readEBMLChildren(r io.ReaderAt, offset, end int64) ([]ebmlElement, error) {
+	var elements []ebmlElement
+	for offset < end {
+		element, err := readEBMLElement(r, offset)
+		if err != nil {
+			return nil, err
+		}
+		if element.Size < 0 || element.Offset+element.Size > end {
+			return nil, errNotMKV
+		}
+		elements = append(elements, element)
+		offset = element.Offset + element.Size
+	}
+	return elements, nil
+}
+
+// readEBMLUint read data of an unsigned integer element
+func readEBMLUint(r io.ReaderAt, element ebmlElement) (uint64, error) {
+	if element.Size > 8 {
+		return 0, errNotMKV
+	}
+	buf := make([]byte, element.Size)
+	if err := readAt(r, buf, element.Offset); err != nil {
+		return 0, err
+	}
+	value := uint64(0)
+	for _, b := range buf {
+		value = value<<8 | uint64(b)
+	}
+	return value, nil
+}
+
+// readEBMLString read data of a string element, the trailing zeros are trimmed
+func readEBMLString(r io.ReaderAt, element ebmlElement) (string, error) {
+	if element.Size > ebmlMaxStringSize {
+		return "", errNotMKV
+	}
+	buf := make([]byte, element.Size)
+	if err := readAt(r, buf, element.Offset); err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(buf), "\x00"), nil
+}
+
+// readEBMLFloat read data of a float element
+func readEBMLFloat(r io.ReaderAt, element ebmlElement) (float64, error) {
+	buf := make([]byte, element.Size)
+	switch element.Size {
+	case 0:
+		return 0, nil
+	case 4, 8:
+		if err := readAt(r, buf, element.Offset); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, errNotMKV
+	}
+	if element.Size == 4 {
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(buf))), nil
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(buf)), nil
+}
+
+// getMKVInfo get duration from Segment/Info, dimension from the 1st video track, the codecs of the 1st video & audio
+// tracks & the stream layout in Segment/Tracks and chapters from Segment/Chapters of mkv & webm files,
+// clusters are skipped or located through the SeekHead
+func getMKVInfo(r io.ReaderAt, size int64) (*MediaInfoV2, error) {
+	header, err := readEBMLElement(r, 0)
+	if err != nil || header.ID != ebmlIDHeader || header.Size < 0 {
+		return nil, errNotMKV
+	}
+	segment, err := readEBMLElement(r, header.Offset+header.Size)
+	if err != nil || segment.ID != ebmlIDSegment {
+		return nil, errNotMKV
+	}
+	segmentEnd := segment.Offset + segment.Size
+	if segment.Size < 0 || segmentEnd > size { // live streams or truncated files
+		segmentEnd = size
+	}
+
+	var info, tracks, chapters, seekHead *ebmlElement
+	for offset := segment.Offset; offset < segmentEnd && (info == nil || tracks == nil || chapters == nil); {
+		element, err := readEBMLElement(r, offset)
+		if err != nil {
+			return nil, err
+		}
+		if element.Size < 0 { // clusters in unknown size can not be skipped
+			break
+		}
+		switch element.ID {
+		case ebmlIDInfo:
+			info = &element
+		case ebmlIDTracks:
+			tracks = &element
+		case ebmlIDChapters:
+			chapters = &element
+		case ebmlIDSeekHead:
+			if seekHead == nil {
+				seekHead = &element
+			}
+		case ebmlIDCluster:
+			// Info & Tracks are placed after clusters, locate them by the SeekHead,
+			// the optional Chapters are placed before clusters if Info & Tracks are
+			if seekHead != nil || (info != nil && tracks != nil) {
+				offset = segmentEnd
+				continue
+			}
+		}
+		offset = element.Offset + element.Size
+	}
+	if (info == nil || tracks == nil || chapters == nil) && seekHead != nil {
+		if positions, err := readEBMLSeekHead(r, *seekHead); err == nil {
+			for _, id := range []uint32{ebmlIDInfo, ebmlIDTracks, ebmlIDChapters} {
+				position, exists := positions[id]
+				if !exists {
+					continue
+				}
+				element, err := readEBMLElement(r, segment.Offset+position)
+				if err != nil || element.ID != id || element.Size < 0 {
+					continue
+				}
+				if id == ebmlIDInfo && info == nil {
+					info = &element
+				} else if id == ebmlIDTracks && tracks == nil {
+					tracks = &element
+				} else if id == ebmlIDChapters && chapters == nil {
+					chapters = &element
+				}
+			}
+		}
+	}
+	if info == nil {
+		return nil, errNotMKV
+	}
+
+	mediaInfo := &MediaInfoV2{}
+	d, err := readMKVDuration(r, *info)
+	if err != nil {
+		return nil, err
+	}
+	mediaInfo.Duration = d
+	if tracks != nil {
+		entries, err := readMKVTracks(r, *tracks)
+		if err != nil {
+			return nil, err
+		}
+		mediaInfo.Width, mediaInfo.Height = mkvDimension(entries)
+		mediaInfo.Streams = mkvStreams(entries)
+		if err = readMKVVideoCodec(r, entries, mediaInfo); err != nil {
+			return nil, err
+		}
+		mkvAudioCodec(entries, mediaInfo)
+	}
+	if chapters != nil {
+		if mediaInfo.Chapters, err = readMKVChapters(r, *chapters); err != nil {
+			return nil, err
+		}
+		fillChapterEnds(mediaInfo.Chapters, d)
+	}
+	return mediaInfo, nil
+}
+
+// readEBMLSeekHead read positions of top level elements relative to the segment data
+func readEBMLSeekHead(r io.ReaderAt, seekHead ebmlElement) (map[uint32]int64, error) {
+	seeks, err := readEBMLChildren(r, seekHead.Offset, seekHead.Offset+seekHead.Size)
+	if err != nil {
+		return nil, err
+	}
+	positions := make(map[uint32]int64)
+	for _, seek := range seeks {
+		if seek.ID != ebmlIDSeek {
+			continue
+		}
+		children, err := readEBMLChildren(r, seek.Offset, seek.Offset+seek.Size)
+		if err != nil {
+			return nil, err
+		}
+		var id, position uint64
+		for _, child := range children {
+			switch child.ID {
+			case ebmlIDSeekID:
+				// the SeekID is stored as binary, which is read the same as an unsigned integer
+				id, err = readEBMLUint(r, child)
+			case ebmlIDSeekPosition:
+				position, err = readEBMLUint(r, child)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		positions[uint32(id)] = int64(position)
+	}
+	return positions, nil
+}
+
+// readMKVDuration read duration from Segment/Info
+func readMKVDuration(r io.ReaderAt, info ebmlElement) (time.Duration, error) {
+	children, err := readEBMLChildren(r, info.Offset, info.Offset+info.Size)
+	if err != nil {
+		return 0, err
+	}
+	timescale := uint64(ebmlDefaultTimescale)
+	duration := float64(0)
+	for _, child := range children {
+		switch child.ID {
+		case ebmlIDTimecodeScale:
+			timescale, err = readEBMLUint(r, child)
+		case ebmlIDDuration:
+			duration, err = readEBMLFloat(r, child)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	// duration is in unit of timescale ns
+	if ns := duration * float64(timescale); ns >= 1 && ns < math.MaxInt64 {
+		return time.Duration(ns), nil
+	}
+	return 0, errNotMKV
+}
+
+// mkvTrack a TrackEntry of Segment/Tracks with the elements used for probing
+type mkvTrack struct {
+	trackType       uint64
+	codecID         string
+	private         *ebmlElement // CodecPrivate, which is read for the 1st video track only
+	defaultDuration uint64
+	stream          Stream // language, title & dispositions, the type is empty if not a video, audio or subtitle
+	// PixelWidth & PixelHeight of the Video element, nil if not presented
+	video map[uint32]uint64
+	// Channels, SamplingFrequency & BitDepth of the Audio element, 1, 8000 & 0 if not presented
+	channels   uint64
+	sampleRate float64
+	bitDepth   uint64
+}
+
+// readMKVTracks read the TrackEntry elements of Segment/Tracks in order,
+// the language is eng & the default flag is set if they are not presented
+func readMKVTracks(r io.ReaderAt, tracks ebmlElement) ([]mkvTrack, error) {
+	entries, err := readEBMLChildren(r, tracks.Offset, tracks.Offset+tracks.Size)
+	if err != nil {
+		return nil, err
+	}
+	var result []mkvTrack
+	for _, entry := range entries {
+		if entry.ID != ebmlIDTrackEntry {
+			continue
+		}
+		children, err := readEBMLChildren(r, entry.Offset, entry.Offset+entry.Size)
+		if err != nil {
+			return nil, err
+		}
+		track := mkvTrack{stream: Stream{Language: "eng"}, channels: 1, sampleRate: 8000}
+		flags := map[uint32]uint64{ebmlIDFlagDefault: 1}
+		for i, child := range children {
+			switch child.ID {
+			case ebmlIDTrackType:
+				track.trackType, err = readEBMLUint(r, child)
+			case ebmlIDCodecID:
+				track.codecID, err = readEBMLString(r, child)
+			case ebmlIDCodecPrivate:
+				track.private = &children[i]
+			case ebmlIDDefaultDuration:
+				track.defaultDuration, err = readEBMLUint(r, child)
+			case ebmlIDLanguage:
+				track.stream.Language, err = readEBMLString(r, child)
+			case ebmlIDName:
+				track.stream.Title, err = readEBMLString(r, child)
+			case ebmlIDFlagDefault, ebmlIDFlagForced, ebmlIDFlagHearing, ebmlIDFlagVisual, ebmlIDFlagOriginal,
+				ebmlIDFlagCommentary:
+				flags[child.ID], err = readEBMLUint(r, child)
+			case ebmlIDVideo:
+				track.video, err = readMKVTrackVideo(r, child)
+			case ebmlIDAudio:
+				err = readMKVTrackAudio(r, child, &track)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		track.stream.Type = mkvTrackTypes[track.trackType]
+		track.stream.Language = normalizeLanguage(track.stream.Language)
+		var names []string
+		for id, set := range flags {
+			if set != 0 {
+				names = append(names, mkvDispositions[id])
+			}
+		}
+		track.stream.Disposition = newDisposition(names...)
+		result = append(result, track)
+	}
+	return result, nil
+}
+
+// readMKVTrackVideo read the dimension elements of the Video element of a track
+func readMKVTrackVideo(r io.ReaderAt, video ebmlElement) (map[uint32]uint64, error) {
+	children, err := readEBMLChildren(r, video.Offset, video.Offset+video.Size)
+	if err != nil {
+		return nil, err
+	}
+	values := map[uint32]uint64{}
+	for _, child := range children {
+		switch child.ID {
+		case ebmlIDPixelWidth, ebmlIDPixelHeight:
+			if values[child.ID], err = readEBMLUint(r, child); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return values, nil
+}
+
+// readMKVTrackAudio read channels, sample rate & bit depth of the Audio element of a track into track
+func readMKVTrackAudio(r io.ReaderAt, audio ebmlElement, track *mkvTrack) error {
+	children, err := readEBMLChildren(r, audio.Offset, audio.Offset+audio.Size)
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		switch child.ID {
+		case ebmlIDChannels:
+			track.channels, err = readEBMLUint(r, child)
+		case ebmlIDSamplingFreq:
+			track.sampleRate, err = readEBMLFloat(r, child)
+		case ebmlIDBitDepth:
+			track.bitDepth, err = readEBMLUint(r, child)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mkvDimension the coded PixelWidth & PixelHeight of the 1st video track with a Video element like ffprobe,
+// DisplayWidth & DisplayHeight of anamorphic videos are ignored
+func mkvDimension(tracks []mkvTrack) (uint32, uint32) {
+	for _, track := range tracks {
+		if track.trackType != ebmlTrackTypeVideo || track.video == nil {
+			continue
+		}
+		return uint32(track.video[ebmlIDPixelWidth]), uint32(track.video[ebmlIDPixelHeight])
+	}
+	return 0, 0
+}
+
+// readMKVVideoCodec read codec, profile & pixel format from CodecID & CodecPrivate and frame rate from
+// DefaultDuration of the 1st video track into info, unknown ones are not set
+func readMKVVideoCodec(r io.ReaderAt, tracks []mkvTrack, info *MediaInfoV2) error {
+	for _, track := range tracks {
+		if track.trackType != ebmlTrackTypeVideo {
+			continue
+		}
+
+		// frame rate in 1/30000 precision like ffmpeg
+		if track.defaultDuration > 0 && track.defaultDuration <= math.MaxInt64 {
+			info.FrameRate = reduceRational(int64(time.Second), int64(track.defaultDuration), 30000)
+		}
+		info.VideoCodec = mkvVideoCodecs[track.codecID]
+		parser, exists := videoConfigParsers[info.VideoCodec]
+		if info.VideoCodec == "vp9" {
+			parser, exists = parseVP9CodecPrivate, true
+		}
+		if exists && track.private != nil && track.private.Size <= ebmlMaxStringSize {
+			config := make([]byte, track.private.Size)
+			if err := readAt(r, config, track.private.Offset); err != nil {
+				return err
+			}
+			info.VideoProfile, info.PixelFormat = parser(config)
+		}
+		return nil
+	}
+	return nil
+}
+
+// mkvAudioCodec set codec from CodecID with channels, sample rate & bit depth of the 1st audio track into info
+func mkvAudioCodec(tracks []mkvTrack, info *MediaInfoV2) {
+	for _, track := range tracks {
+		if track.trackType != ebmlTrackTypeAudio {
+			continue
+		}
+		if track.channels > math.MaxUint16 || track.bitDepth > 64 ||
+			track.sampleRate < 0 || track.sampleRate > math.MaxInt32 {
+			return
+		}
+
+		codecID := track.codecID
+		codec := mkvAudioCodecs[codecID]
+		switch {
+		case strings.HasPrefix(codecID, "A_AAC"): // A_AAC/MPEG4/LC & the like of the legacy muxers
+			codec = "aac"
+		case codecID == "A_PCM/INT/LIT" || codecID == "A_PCM/INT/BIG" || codecID == "A_PCM/FLOAT/IEEE":
+			codec = pcmCodec(int(track.bitDepth), codecID == "A_PCM/FLOAT/IEEE", codecID == "A_PCM/INT/BIG")
+		}
+		info.setAudioFormat(codec, int(track.channels), uint64(math.Round(track.sampleRate)), int(track.bitDepth))
+		return
+	}
+}
+
+// mkvStreams the video, audio & subtitle tracks in order
+func mkvStreams(tracks []mkvTrack) []Stream {
+	var streams []Stream
+	for _, track := range tracks {
+		if len(track.stream.Type) > 0 {
+			streams = append(streams, track.stream)
+		}
+	}
+	return streams
+}
+
+// readMKVChapters read the chapters which are not hidden from the 1st edition in Segment/Chapters,
+// the ends are 0 if not presented
+func readMKVChapters(r io.ReaderAt, chapters ebmlElement) ([]Chapter, error) {
+	editions, err := readEBMLChildren(r, chapters.Offset, chapters.Offset+chapters.Size)
+	if err != nil {
+		return nil, err
+	}
+	edition, exists := findEBMLElement(editions, ebmlIDEditionEntry)
+	if !exists {
+		return nil, nil
+	}
+	atoms, err := readEBMLChildren(r, edition.Offset, edition.Offset+edition.Size)
+	if err != nil {
+		return nil, err
+	}
+	var result []Chapter
+	for _, atom := range atoms {
+		if atom.ID != ebmlIDChapterAtom {
+			continue
+		}
+		children, err := readEBMLChildren(r, atom.Offset, atom.Offset+atom.Size)
+		if err != nil {
+			return nil, err
+		}
+		var start, end, hidden uint64
+		title := ""
+		for _, child := range children {
+			switch child.ID {
+			case ebmlIDChapterStart:
+				start, err = readEBMLUint(r, child)
+			case ebmlIDChapterEnd:
+				end, err = readEBMLUint(r, child)
+			case ebmlIDChapterHidden:
+				hidden, err = readEBMLUint(r, child)
+			case ebmlIDChapterDisplay:
+				var display []ebmlElement
+				display, err = readEBMLChildren(r, child.Offset, child.Offset+child.Size)
+				if chapString, exists := findEBMLElement(display, ebmlIDChapString); exists && len(title) == 0 {
+					title, err = readEBMLString(r, chapString)
+				}
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		// times are in ns regardless of the timescale
+		if hidden != 0 || start > math.MaxInt64 || end > math.MaxInt64 {
+			continue
+		}
+		result = append(result, Chapter{Start: time.Duration(start), End: time.Duration(end), Title: title})
+	}
+	return result, nil
+}
+
+// findEBMLElement find the 1st element of id in elements
+func findEBMLElement(elements []ebmlElement, id uint32) (ebmlElement, bool) {
+	for _, element := range elements {
+		if element.ID == id {
+			return element, true
+		}
+	}
+	return ebmlElement{}, false
+}
@@ -0,0 +1,120 @@
+package mediashrink
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReadEBMLVint(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		isID   bool
+		value  int64
+		length int64
+		err    error
+	}{
+		{"size in 1 byte", []byte{0x81}, false, 1, 1, nil},
+		{"size in 2 bytes", []byte{0x40, 0x7F}, false, 0x7F, 2, nil},
+		{"size in 8 bytes", []byte{0x01, 0, 0, 0, 0, 0, 0x01, 0x00}, false, 0x100, 8, nil},
+		{"unknown size in 1 byte", []byte{0xFF}, false, -1, 1, nil},
+		{"unknown size in 8 bytes", []byte{0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, false, -1, 8, nil},
+		{"marker kept in id", []byte{0x1A, 0x45, 0xDF, 0xA3}, true, 0x1A45DFA3, 4, nil},
+		{"all ones id", []byte{0xFF}, true, 0xFF, 1, nil},
+		{"no marker", []byte{0x00, 0x81}, false, 0, 0, errNotMKV},
+		{"id longer than 4 bytes", []byte{0x08, 0, 0, 0, 0}, true, 0, 0, errNotMKV},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, length, err := readEBMLVint(bytes.NewReader(test.data), 0, test.isID)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("got error %v, want %v", err, test.err)
+			}
+			if value != test.value || length != test.length {
+				t.Errorf("got %d in %d bytes, want %d in %d bytes", value, length, test.value, test.length)
+			}
+		})
+	}
+}
+
+func TestGetMKVInfo(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    *MediaInfoV2
+	}{
+		{
+			// anamorphic VP9 of 720x576 displayed in 1024x576 with opus, a forced subtitle, a track of buttons
+			// & a hidden chapter, the coded dimension is kept like ffprobe
+			fixture: "tracks.mkv",
+			want: &MediaInfoV2{
+				Width:         720,
+				Height:        576,
+				Duration:      2535500 * time.Microsecond,
+				FrameRate:     Rational{Num: 24000, Den: 1001},
+				SampleRate:    Rational{Num: 48000, Den: 1},
+				VideoCodec:    "vp9",
+				VideoProfile:  "Profile 2",
+				PixelFormat:   "yuv420p10le",
+				AudioCodec:    "opus",
+				Channels:      6,
+				ChannelLayout: "5.1",
+				Streams: []Stream{
+					{Type: StreamVideo, Language: "eng", Disposition: []string{"default"}},
+					{Type: StreamAudio, Language: "jpn", Title: "Director: cut, take 2 & more",
+						Disposition: []string{"comment"}},
+					{Type: StreamSubtitle, Language: "eng", Disposition: []string{"default", "forced"}},
+				},
+				Chapters: []Chapter{
+					{Start: 0, End: time.Second, Title: "Intro"},
+					{Start: time.Second, End: 2535500 * time.Microsecond, Title: "Main: part 1"},
+				},
+			},
+		},
+		{
+			// Info & Tracks after a cluster in a segment of unknown size, located by the SeekHead
+			fixture: "seekhead.webm",
+			want: &MediaInfoV2{
+				Duration:      1500 * time.Millisecond,
+				SampleRate:    Rational{Num: 44100, Den: 1},
+				AudioCodec:    "pcm_s16le",
+				Channels:      2,
+				ChannelLayout: "stereo",
+				BitDepth:      16,
+				Streams:       []Stream{{Type: StreamAudio, Language: "eng", Disposition: []string{"default"}}},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.fixture, func(t *testing.T) {
+			r := bytes.NewReader(readFixture(t, test.fixture))
+			info, err := getMKVInfo(r, r.Size())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(info, test.want) {
+				t.Errorf("got %s, want %s", info.ToString(), test.want.ToString())
+			}
+		})
+	}
+}
+
+func TestGetMKVInfoInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"not EBML", []byte("RIFF\x00\x00\x00\x00WAVE")},
+		{"no segment", []byte{0x1A, 0x45, 0xDF, 0xA3, 0x80, 0xEC, 0x80}},
+		{"no info", []byte{0x1A, 0x45, 0xDF, 0xA3, 0x80, 0x18, 0x53, 0x80, 0x67, 0x80}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := getMKVInfo(bytes.NewReader(test.data), int64(len(test.data))); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
@@ -0,0 +1,599 @@
+package mediashrink
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+var errNotMP4 = errors.New("not a MP4 / MOV file")
+
+// maxMP4BoxRead max size of the boxes read in whole, like chapters & names
+const maxMP4BoxRead = 1 << 20
+
+// mp4HandlerTypes stream types of the track handlers
+var mp4HandlerTypes = map[string]StreamType{
+	"vide": StreamVideo,
+	"soun": StreamAudio,
+	"sbtl": StreamSubtitle,
+	"subt": StreamSubtitle,
+	"text": StreamSubtitle,
+	"clcp": StreamSubtitle,
+}
+
+// mp4Box an ISO base media file format (ISO-BMFF) box
+type mp4Box struct {
+	Type   string
+	Offset int64 // offset of the box payload
+	Size   int64 // size of the box payload
+}
+
+// readMP4Boxes read headers of boxes in [offset, end) without reading their payloads
+func readMP4Boxes(r io.ReaderAt, offset, end int64) ([]mp4Box, error) {
+	var boxes []mp4Box
+	header := make([]byte, 16)
+	for offset+8 <= end {
+		// size(4) type(4) [largesize(8)]
+		if err := readAt(r, header[:8], offset); err != nil {
+			return nil, err
+		}
+		boxSize := int64(binary.BigEndian.Uint32(header[0:4]))
+		headerSize := int64(8)
+		switch boxSize {
+		case 0: // box extends to the end of its parent
+			boxSize = end - offset
+		case 1:
+			if err := readAt(r, header[8:16], offset+8); err != nil {
+				return nil, err
+			}
+			boxSize = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+		if boxSize < headerSize {
+			return nil, errNotMP4
+		}
+		if boxSize > end-offset { // truncated box, keep it so boxes before it are still usable
+			boxSize = end - offset
+		}
+		boxes = append(boxes, mp4Box{
+			Type:   string(header[4:8]),
+			Offset: offset + headerSize,
+			Size:   boxSize - headerSize,
+		})
+		offset += boxSize
+	}
+	return boxes, nil
+}
+
+// findMP4Box find the 1st box of boxType in boxes
+func findMP4Box(boxes []mp4Box, boxType string) (mp4Box, bool) {
+	for _, box := range boxes {
+		if box.Type == boxType {
+			return box, true
+		}
+	}
+	return mp4Box{}, false
+}
+
+// getMP4Info get duration from moov/mvhd, dimension & rotation from the video trak/tkhd, the codecs of the 1st video
+// & audio traks and the stream layout from all the traks of mp4, m4v, mov & m4a files, only box headers and the few
+// boxes needed are read
+func getMP4Info(r io.ReaderAt, size int64) (*MediaInfoV2, error) {
+	top, err := readMP4Boxes(r, 0, size)
+	if err != nil {
+		return nil, err
+	}
+	moov, exists := findMP4Box(top, "moov")
+	if !exists {
+		return nil, errNotMP4
+	}
+	boxes, err := readMP4Boxes(r, moov.Offset, moov.Offset+moov.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &MediaInfoV2{}
+	mvhd, exists := findMP4Box(boxes, "mvhd")
+	if !exists {
+		return nil, errNotMP4
+	}
+	d, err := readMP4Duration(r, mvhd)
+	if err != nil {
+		return nil, err
+	}
+	info.Duration = d
+
+	// tracks referenced as chapters are the chapter titles instead of streams
+	type mp4Track struct {
+		id     uint32
+		stream Stream
+	}
+	var tracks []mp4Track
+	chapterTracks := map[uint32]bool{}
+	hasAudio := false
+	for _, trak := range boxes {
+		if trak.Type != "trak" {
+			continue
+		}
+		trakBoxes, err := readMP4Boxes(r, trak.Offset, trak.Offset+trak.Size)
+		if err != nil {
+			return nil, err
+		}
+		media, err := readMP4TrackMedia(r, trakBoxes)
+		if err != nil {
+			continue
+		}
+		tkhd, exists := findMP4Box(trakBoxes, "tkhd")
+		if !exists {
+			continue
+		}
+		header, err := readMP4TrackHeader(r, tkhd)
+		if err != nil {
+			return nil, err
+		}
+		ids, err := readMP4ChapterTracks(r, trakBoxes)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			chapterTracks[id] = true
+		}
+		if media.Handler == "vide" && (info.Width == 0 || info.Height == 0) {
+			info.Width, info.Height, info.Rotation = header.Width, header.Height, header.Rotation
+			readMP4VideoCodec(r, media, info)
+		}
+		if media.Handler == "soun" && !hasAudio {
+			hasAudio = true
+			readMP4AudioCodec(r, media, info)
+		}
+		streamType, exists := mp4HandlerTypes[media.Handler]
+		if !exists {
+			continue
+		}
+		stream := Stream{Type: streamType, Language: media.Language, Title: readMP4TrackName(r, trakBoxes)}
+		if header.Enabled {
+			stream.Disposition = newDisposition("default")
+		}
+		tracks = append(tracks, mp4Track{id: header.ID, stream: stream})
+	}
+	for _, track := range tracks {
+		if !chapterTracks[track.id] {
+			info.Streams = append(info.Streams, track.stream)
+		}
+	}
+
+	if info.Chapters, err = readMP4Chapters(r, boxes, d); err != nil {
+		return nil, err
+	}
+	// chapters in text tracks are read by ffprobe when the stream layout is needed, see getVideoInfo
+	info.chapterTrack = len(info.Chapters) == 0 && len(chapterTracks) > 0
+	return info, nil
+}
+
+// readMP4Duration read movie duration from mvhd
+func readMP4Duration(r io.ReaderAt, mvhd mp4Box) (time.Duration, error) {
+	// version(1) flags(3)
+	// v0: creation(4) modification(4) timescale(4) duration(4)
+	// v1: creation(8) modification(8) timescale(4) duration(8)
+	buf := make([]byte, 32)
+	if mvhd.Size < 20 {
+		return 0, errNotMP4
+	}
+	if err := readAt(r, buf[:1], mvhd.Offset); err != nil {
+		return 0, err
+	}
+	var timescale, duration uint64
+	if buf[0] == 1 {
+		if mvhd.Size < 32 {
+			return 0, errNotMP4
+		}
+		if err := readAt(r, buf, mvhd.Offset); err != nil {
+			return 0, err
+		}
+		timescale = uint64(binary.BigEndian.Uint32(buf[20:24]))
+		duration = binary.BigEndian.Uint64(buf[24:32])
+	} else {
+		if err := readAt(r, buf[:20], mvhd.Offset); err != nil {
+			return 0, err
+		}
+		timescale = uint64(binary.BigEndian.Uint32(buf[12:16]))
+		duration = uint64(binary.BigEndian.Uint32(buf[16:20]))
+		if duration == 0xFFFFFFFF { // unknown duration
+			duration = 0
+		}
+	}
+	if timescale == 0 || duration == 0 {
+		// duration of fragmented files are not presented in mvhd
+		return 0, errNotMP4
+	}
+	return durationOfSamples(duration, timescale), nil
+}
+
+// mp4TrackMedia the fields of trak/mdia
+type mp4TrackMedia struct {
+	Handler   string // handler type from hdlr, like vide & soun
+	Language  string // language from mdhd, empty if undetermined
+	Timescale uint32 // timescale from mdhd
+	Boxes     []mp4Box
+}
+
+// readMP4TrackMedia read handler type from trak/mdia/hdlr with language & timescale from trak/mdia/mdhd
+func readMP4TrackMedia(r io.ReaderAt, trakBoxes []mp4Box) (mp4TrackMedia, error) {
+	mdia, exists := findMP4Box(trakBoxes, "mdia")
+	if !exists {
+		return mp4TrackMedia{}, errNotMP4
+	}
+	mdiaBoxes, err := readMP4Boxes(r, mdia.Offset, mdia.Offset+mdia.Size)
+	if err != nil {
+		return mp4TrackMedia{}, err
+	}
+	hdlr, exists := findMP4Box(mdiaBoxes, "hdlr")
+	if !exists || hdlr.Size < 12 {
+		return mp4TrackMedia{}, errNotMP4
+	}
+	// version(1) flags(3) pre_defined(4) handler_type(4)
+	handler := make([]byte, 4)
+	if err := readAt(r, handler, hdlr.Offset+8); err != nil {
+		return mp4TrackMedia{}, err
+	}
+	media := mp4TrackMedia{Handler: string(handler), Boxes: mdiaBoxes}
+	if mdhd, exists := findMP4Box(mdiaBoxes, "mdhd"); exists {
+		media.Language, media.Timescale = readMP4MediaHeader(r, mdhd)
+	}
+	return media, nil
+}
+
+// readMP4MediaHeader read the ISO 639-2 language & timescale from mdhd, the language is empty if undetermined
+func readMP4MediaHeader(r io.ReaderAt, mdhd mp4Box) (string, uint32) {
+	// version(1) flags(3)
+	// v0: creation(4) modification(4) timescale(4) duration(4) language(2)
+	// v1: creation(8) modification(8) timescale(4) duration(8) language(2)
+	version := make([]byte, 1)
+	if err := readAt(r, version, mdhd.Offset); err != nil {
+		return "", 0
+	}
+	timescaleOffset, languageOffset := int64(12), int64(20)
+	if version[0] == 1 {
+		timescaleOffset, languageOffset = 20, 32
+	}
+	buf := make([]byte, 4)
+	if mdhd.Size < languageOffset+2 || readAt(r, buf, mdhd.Offset+timescaleOffset) != nil {
+		return "", 0
+	}
+	timescale := binary.BigEndian.Uint32(buf)
+	if readAt(r, buf[:2], mdhd.Offset+languageOffset) != nil {
+		return "", timescale
+	}
+	code := binary.BigEndian.Uint16(buf[:2])
+	switch {
+	case code == 0: // the Macintosh language code of English, the other Macintosh codes are not kept
+		return "eng", timescale
+	case code < 0x400 || code == 0x7FFF:
+		return "", timescale
+	}
+	// 3 letters in 5 bits each, offset by 0x60
+	letters := []byte{byte(code>>10&0x1F) + 0x60, byte(code>>5&0x1F) + 0x60, byte(code&0x1F) + 0x60}
+	return normalizeLanguage(string(letters)), timescale
+}
+
+// readMP4VideoCodec read codec, profile & pixel format from the 1st sample entry in minf/stbl/stsd and frame rate
+// from the most common sample delta in minf/stbl/stts of a video track into info, unknown ones are not set
+func readMP4VideoCodec(r io.ReaderAt, media mp4TrackMedia, info *MediaInfoV2) {
+	stblBoxes, exists := readMP4SampleTable(r, media)
+	if !exists {
+		return
+	}
+	if stts, exists := findMP4Box(stblBoxes, "stts"); exists {
+		info.FrameRate = readMP4FrameRate(r, stts, media.Timescale)
+	}
+	entry, exists := readMP4SampleEntry(r, stblBoxes)
+	if !exists {
+		return
+	}
+	if info.VideoCodec = mp4VideoCodecs[entry.Type]; info.VideoCodec == "prores" {
+		info.VideoProfile = proresProfiles[entry.Type]
+		if entry.Type != "ap4h" && entry.Type != "ap4x" {
+			info.PixelFormat = "yuv422p10le"
+		}
+		return
+	}
+	// the visual sample entry of 78 bytes is followed by boxes like the codec configuration
+	configType, exists := mp4VideoConfigs[info.VideoCodec]
+	if !exists || entry.Size < 78 {
+		return
+	}
+	entryBoxes, err := readMP4Boxes(r, entry.Offset+78, entry.Offset+entry.Size)
+	if err != nil {
+		return
+	}
+	configBox, exists := findMP4Box(entryBoxes, configType)
+	if !exists || configBox.Size > maxMP4BoxRead {
+		return
+	}
+	config := make([]byte, configBox.Size)
+	if err := readAt(r, config, configBox.Offset); err != nil {
+		return
+	}
+	if info.VideoCodec == "vp9" {
+		info.VideoProfile, info.PixelFormat = parseVP9Config(config)
+	} else {
+		info.VideoProfile, info.PixelFormat = videoConfigParsers[info.VideoCodec](config)
+	}
+}
+
+// readMP4AudioCodec read codec, channels, sample rate & bit depth from the 1st sample entry in minf/stbl/stsd
+// of an audio track into info, unknown ones are not set
+func readMP4AudioCodec(r io.ReaderAt, media mp4TrackMedia, info *MediaInfoV2) {
+	stblBoxes, exists := readMP4SampleTable(r, media)
+	if !exists {
+		return
+	}
+	entry, exists := readMP4SampleEntry(r, stblBoxes)
+	if !exists || entry.Size < 28 {
+		return
+	}
+	// reserved(6) data_reference_index(2) version(2) revision(2) vendor(4)
+	// channels(2) sample_size(2) compression_id(2) packet_size(2) sample_rate(4, 16.16)
+	// v1 of QuickTime: samples_per_packet(4) bytes_per_packet(4) bytes_per_frame(4) bytes_per_sample(4)
+	// v2 of QuickTime: struct_size(4) sample_rate(8, float64) channels(4) reserved(4) bits(4) flags(4) ...
+	buf := make([]byte, 64)
+	if entry.Size < int64(len(buf)) {
+		buf = buf[:entry.Size]
+	}
+	if err := readAt(r, buf, entry.Offset); err != nil {
+		return
+	}
+	channels := int(binary.BigEndian.Uint16(buf[16:18]))
+	bits := int(binary.BigEndian.Uint16(buf[18:20]))
+	sampleRate := uint64(binary.BigEndian.Uint32(buf[24:28]) >> 16)
+	childrenOffset := int64(28)
+	switch binary.BigEndian.Uint16(buf[8:10]) {
+	case 1:
+		childrenOffset = 44
+	case 2:
+		if len(buf) < 64 {
+			return
+		}
+		sampleRate = uint64(math.Round(math.Float64frombits(binary.BigEndian.Uint64(buf[32:40]))))
+		channels = int(binary.BigEndian.Uint32(buf[40:44]))
+		bits = int(binary.BigEndian.Uint32(buf[48:52]))
+		childrenOffset = 64
+	}
+
+	// the bit depth of alac & flac is in their configuration boxes instead of the sample size
+	codec := mp4AudioCodecs[entry.Type]
+	readConfig := func(configType string, size int64) []byte {
+		children, err := readMP4Boxes(r, entry.Offset+childrenOffset, entry.Offset+entry.Size)
+		if err != nil {
+			return nil
+		}
+		configBox, exists := findMP4Box(children, configType)
+		if !exists || configBox.Size < size {
+			return nil
+		}
+		config := make([]byte, size)
+		if err := readAt(r, config, configBox.Offset); err != nil {
+			return nil
+		}
+		return config
+	}
+	switch codec {
+	case "alac":
+		// version(1) flags(3) frame_length(4) compatible_version(1) bit_depth(1)
+		if config := readConfig("alac", 10); config != nil {
+			bits = int(config[9])
+		}
+	case "flac":
+		// version(1) flags(3) metadata_block_header(4) STREAMINFO(34)
+		if config := readConfig("dfLa", 42); config != nil {
+			bits = int(parseFLACFormat(config[8:42]).BitsPerSample)
+		}
+	}
+	info.setAudioFormat(codec, channels, sampleRate, bits)
+}
+
+// readMP4SampleTable read the boxes of minf/stbl of a track
+func readMP4SampleTable(r io.ReaderAt, media mp4TrackMedia) ([]mp4Box, bool) {
+	minf, exists := findMP4Box(media.Boxes, "minf")
+	if !exists {
+		return nil, false
+	}
+	minfBoxes, err := readMP4Boxes(r, minf.Offset, minf.Offset+minf.Size)
+	if err != nil {
+		return nil, false
+	}
+	stbl, exists := findMP4Box(minfBoxes, "stbl")
+	if !exists {
+		return nil, false
+	}
+	stblBoxes, err := readMP4Boxes(r, stbl.Offset, stbl.Offset+stbl.Size)
+	if err != nil {
+		return nil, false
+	}
+	return stblBoxes, true
+}
+
+// readMP4SampleEntry read the header of the 1st sample entry in stsd of the boxes of stbl
+func readMP4SampleEntry(r io.ReaderAt, stblBoxes []mp4Box) (mp4Box, bool) {
+	// version(1) flags(3) entry_count(4) entries
+	stsd, exists := findMP4Box(stblBoxes, "stsd")
+	if !exists || stsd.Size < 8 {
+		return mp4Box{}, false
+	}
+	entries, err := readMP4Boxes(r, stsd.Offset+8, stsd.Offset+stsd.Size)
+	if err != nil || len(entries) == 0 {
+		return mp4Box{}, false
+	}
+	return entries[0], true
+}
+
+// readMP4FrameRate get frame rate from the most common sample delta in stts, zero if unknown
+func readMP4FrameRate(r io.ReaderAt, stts mp4Box, timescale uint32) Rational {
+	// version(1) flags(3) entry_count(4) entry_count * (sample_count(4) sample_delta(4))
+	size := stts.Size
+	if size > maxMP4BoxRead {
+		size = maxMP4BoxRead
+	}
+	buf := make([]byte, size)
+	if timescale == 0 || size < 8 || readAt(r, buf, stts.Offset) != nil {
+		return Rational{}
+	}
+	counts := map[uint32]uint64{}
+	var delta uint32
+	for offset := 8; offset+8 <= len(buf); offset += 8 {
+		d := binary.BigEndian.Uint32(buf[offset+4 : offset+8])
+		counts[d] += uint64(binary.BigEndian.Uint32(buf[offset : offset+4]))
+		if counts[d] > counts[delta] || (counts[d] == counts[delta] && d < delta) {
+			delta = d
+		}
+	}
+	return reduceRational(int64(timescale), int64(delta), math.MaxInt32)
+}
+
+// mp4TrackHeader the fields of tkhd
+type mp4TrackHeader struct {
+	ID       uint32
+	Enabled  bool
+	Width    uint32 // width & height before the transformation
+	Height   uint32
+	Rotation int // the clockwise rotation of the matrix
+}
+
+// readMP4TrackHeader read track ID, enabled flag, width & height and the rotation of the matrix from tkhd
+func readMP4TrackHeader(r io.ReaderAt, tkhd mp4Box) (mp4TrackHeader, error) {
+	// version(1) flags(3)
+	// v0: creation(4) modification(4) track_ID(4) reserved(4) duration(4)
+	// v1: creation(8) modification(8) track_ID(4) reserved(4) duration(8)
+	// reserved(8) layer(2) alternate_group(2) volume(2) reserved(2)
+	// matrix(36) of a b u c d v x y w, where a b c d are in 16.16 fixed point
+	// width(4) height(4) in 16.16 fixed point
+	header := make([]byte, 24)
+	if err := readAt(r, header[:4], tkhd.Offset); err != nil {
+		return mp4TrackHeader{}, err
+	}
+	idOffset, matrixOffset := int64(12), int64(40)
+	if header[0] == 1 {
+		idOffset, matrixOffset = 20, 52
+	}
+	if tkhd.Size < matrixOffset+44 {
+		return mp4TrackHeader{}, errNotMP4
+	}
+	if err := readAt(r, header[4:8], tkhd.Offset+idOffset); err != nil {
+		return mp4TrackHeader{}, err
+	}
+	buf := make([]byte, 44)
+	if err := readAt(r, buf, tkhd.Offset+matrixOffset); err != nil {
+		return mp4TrackHeader{}, err
+	}
+	a, b := int32(binary.BigEndian.Uint32(buf[0:4])), int32(binary.BigEndian.Uint32(buf[4:8]))
+	return mp4TrackHeader{
+		ID:       binary.BigEndian.Uint32(header[4:8]),
+		Enabled:  header[3]&1 != 0,
+		Width:    binary.BigEndian.Uint32(buf[36:40]) >> 16,
+		Height:   binary.BigEndian.Uint32(buf[40:44]) >> 16,
+		Rotation: normalizeRotation(math.Atan2(float64(b), float64(a)) * 180 / math.Pi),
+	}, nil
+}
+
+// readMP4ChapterTracks read IDs of the chapter tracks referenced by trak/tref/chap
+func readMP4ChapterTracks(r io.ReaderAt, trakBoxes []mp4Box) ([]uint32, error) {
+	tref, exists := findMP4Box(trakBoxes, "tref")
+	if !exists {
+		return nil, nil
+	}
+	trefBoxes, err := readMP4Boxes(r, tref.Offset, tref.Offset+tref.Size)
+	if err != nil {
+		return nil, err
+	}
+	chap, exists := findMP4Box(trefBoxes, "chap")
+	if !exists {
+		return nil, nil
+	} else if chap.Size > maxMP4BoxRead {
+		return nil, errNotMP4
+	}
+	buf := make([]byte, chap.Size-chap.Size%4)
+	if err := readAt(r, buf, chap.Offset); err != nil {
+		return nil, err
+	}
+	var ids []uint32
+	for i := 0; i < len(buf); i += 4 {
+		ids = append(ids, binary.BigEndian.Uint32(buf[i:i+4]))
+	}
+	return ids, nil
+}
+
+// readMP4TrackName read title of a track from trak/udta/name, which is either a plain string or in a data box
+func readMP4TrackName(r io.ReaderAt, trakBoxes []mp4Box) string {
+	udta, exists := findMP4Box(trakBoxes, "udta")
+	if !exists {
+		return ""
+	}
+	udtaBoxes, err := readMP4Boxes(r, udta.Offset, udta.Offset+udta.Size)
+	if err != nil {
+		return ""
+	}
+	name, exists := findMP4Box(udtaBoxes, "name")
+	if !exists || name.Size > maxMP4BoxRead {
+		return ""
+	}
+	buf := make([]byte, name.Size)
+	if err := readAt(r, buf, name.Offset); err != nil {
+		return ""
+	}
+	// size(4) "data"(4) type(4) locale(4) value
+	if len(buf) >= 16 && string(buf[4:8]) == "data" {
+		buf = buf[16:]
+	}
+	return strings.TrimRight(string(buf), "\x00")
+}
+
+// readMP4Chapters read the Nero chapters from moov/udta/chpl, the end of a chapter is the start of the next one
+func readMP4Chapters(r io.ReaderAt, moovBoxes []mp4Box, duration time.Duration) ([]Chapter, error) {
+	udta, exists := findMP4Box(moovBoxes, "udta")
+	if !exists {
+		return nil, nil
+	}
+	udtaBoxes, err := readMP4Boxes(r, udta.Offset, udta.Offset+udta.Size)
+	if err != nil {
+		return nil, err
+	}
+	chpl, exists := findMP4Box(udtaBoxes, "chpl")
+	if !exists {
+		return nil, nil
+	} else if chpl.Size > maxMP4BoxRead {
+		return nil, errNotMP4
+	}
+	// version(1) flags(3) [reserved(4) in v1] count(1)
+	// count * (start(8) in 100ns, title_length(1), title)
+	buf := make([]byte, chpl.Size)
+	if err := readAt(r, buf, chpl.Offset); err != nil {
+		return nil, err
+	}
+	offset := 4
+	if len(buf) > 0 && buf[0] == 1 {
+		offset = 8
+	}
+	if len(buf) < offset+1 {
+		return nil, errNotMP4
+	}
+	count := int(buf[offset])
+	offset++
+	var chapters []Chapter
+	for i := 0; i < count && offset+9 <= len(buf); i++ {
+		start := binary.BigEndian.Uint64(buf[offset : offset+8])
+		titleEnd := offset + 9 + int(buf[offset+8])
+		if titleEnd > len(buf) || start > math.MaxInt64/100 {
+			break
+		}
+		chapters = append(chapters, Chapter{
+			Start: time.Duration(start) * 100,
+			Title: string(buf[offset+9 : titleEnd]),
+		})
+		offset = titleEnd
+	}
+	fillChapterEnds(chapters, duration)
+	return chapters, nil
+}
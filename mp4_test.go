@@ -0,0 +1,150 @@
+package mediashrink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetMP4Info(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    *MediaInfoV2
+	}{
+		{
+			// h264 rotated by 90 degrees with aac in a disabled track, a chapter text track & Nero chapters
+			fixture: "rotated.mp4",
+			want: &MediaInfoV2{
+				Width:         64,
+				Height:        48,
+				Duration:      5 * time.Second,
+				FrameRate:     Rational{Num: 25, Den: 1},
+				SampleRate:    Rational{Num: 48000, Den: 1},
+				Rotation:      90,
+				VideoCodec:    "h264",
+				VideoProfile:  "Main",
+				PixelFormat:   "yuv420p",
+				AudioCodec:    "aac",
+				Channels:      2,
+				ChannelLayout: "stereo",
+				Streams: []Stream{
+					{Type: StreamVideo, Disposition: []string{"default"}},
+					{Type: StreamAudio, Language: "jpn", Title: "Director: cut, take 2 & more"},
+				},
+				Chapters: []Chapter{
+					{Start: 0, End: 2500 * time.Millisecond, Title: "Intro"},
+					{Start: 2500 * time.Millisecond, End: 5 * time.Second, Title: "Main: part 1"},
+				},
+			},
+		},
+		{
+			// mdat in a large size box before moov, the headers in version 1 and flac in 24-bit
+			fixture: "flac.m4a",
+			want: &MediaInfoV2{
+				Duration:      3 * time.Second,
+				SampleRate:    Rational{Num: 44100, Den: 1},
+				AudioCodec:    "flac",
+				Channels:      2,
+				ChannelLayout: "stereo",
+				BitDepth:      24,
+				Streams:       []Stream{{Type: StreamAudio, Language: "eng", Disposition: []string{"default"}}},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.fixture, func(t *testing.T) {
+			r := bytes.NewReader(readFixture(t, test.fixture))
+			info, err := getMP4Info(r, r.Size())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(info, test.want) {
+				t.Errorf("got %s, want %s", info.ToString(), test.want.ToString())
+			}
+		})
+	}
+}
+
+func TestReadMP4Boxes(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  []byte
+		boxes []mp4Box
+		err   error
+	}{
+		{"empty", nil, nil, nil},
+		{"compact size", []byte("\x00\x00\x00\x0Afree\x00\x00"), []mp4Box{{Type: "free", Offset: 8, Size: 2}}, nil},
+		{
+			"large size",
+			[]byte("\x00\x00\x00\x01mdat\x00\x00\x00\x00\x00\x00\x00\x11\x00"),
+			[]mp4Box{{Type: "mdat", Offset: 16, Size: 1}},
+			nil,
+		},
+		{"to the end", []byte("\x00\x00\x00\x00mdat\x00\x00\x00"), []mp4Box{{Type: "mdat", Offset: 8, Size: 3}}, nil},
+		{"truncated", []byte("\x00\x00\x01\x00mdat\x00"), []mp4Box{{Type: "mdat", Offset: 8, Size: 1}}, nil},
+		{"smaller than the header", []byte("\x00\x00\x00\x07free"), nil, errNotMP4},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			boxes, err := readMP4Boxes(bytes.NewReader(test.data), 0, int64(len(test.data)))
+			if err != test.err {
+				t.Fatalf("got error %v, want %v", err, test.err)
+			}
+			if !reflect.DeepEqual(boxes, test.boxes) {
+				t.Errorf("got %v, want %v", boxes, test.boxes)
+			}
+		})
+	}
+}
+
+// tkhdPayload the payload of tkhd in version of track 7, enabled, with the matrix of a b c d
+func tkhdPayload(version byte, a, b, c, d int32, width, height uint32) []byte {
+	be32 := func(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+	times := make([]byte, 8)
+	if version == 1 {
+		times = make([]byte, 16)
+	}
+	payload := append([]byte{version, 0, 0, 1}, times...)
+	payload = append(append(payload, be32(7)...), make([]byte, len(times)/2+4)...)
+	payload = append(payload, make([]byte, 16)...)
+	for _, v := range []int32{a, b, 0, c, d, 0, 0, 0, 1 << 30} {
+		payload = append(payload, be32(uint32(v))...)
+	}
+	return append(append(payload, be32(width<<16)...), be32(height<<16)...)
+}
+
+func TestReadMP4TrackHeader(t *testing.T) {
+	const one = 1 << 16
+	tests := []struct {
+		name     string
+		payload  []byte
+		rotation int
+	}{
+		{"identity", tkhdPayload(0, one, 0, 0, one, 1920, 1080), 0},
+		{"90", tkhdPayload(0, 0, one, -one, 0, 1920, 1080), 90},
+		{"180", tkhdPayload(0, -one, 0, 0, -one, 1920, 1080), 180},
+		{"270", tkhdPayload(0, 0, -one, one, 0, 1920, 1080), 270},
+		{"90 in version 1", tkhdPayload(1, 0, one, -one, 0, 1920, 1080), 90},
+		// flipped horizontally then rotated by 90 degrees, only the rotation is kept
+		{"mirrored 90", tkhdPayload(0, 0, one, one, 0, 1920, 1080), 90},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := bytes.NewReader(test.payload)
+			header, err := readMP4TrackHeader(r, mp4Box{Type: "tkhd", Size: r.Size()})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := mp4TrackHeader{ID: 7, Enabled: true, Width: 1920, Height: 1080, Rotation: test.rotation}
+			if header != want {
+				t.Errorf("got %+v, want %+v", header, want)
+			}
+		})
+	}
+	short := tkhdPayload(1, one, 0, 0, one, 1920, 1080)[:60]
+	if _, err := readMP4TrackHeader(bytes.NewReader(short), mp4Box{Type: "tkhd", Size: 60}); err != errNotMP4 {
+		t.Errorf("got error %v of a truncated tkhd, want %v", err, errNotMP4)
+	}
+}
@@ -0,0 +1,154 @@
+package mediashrink
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/haxii/filetype/matchers"
+)
+
+// MediaKind kind of a media type, which decides what is checked in its MediaInfoV2
+type MediaKind int
+
+// media kinds
+const (
+	KindImage MediaKind = iota + 1 // width & height are required
+	KindVideo                      // width, height & duration are required
+	KindAudio                      // duration is required
+)
+
+// Prober get the MediaInfoV2 of the media in src, Ext & Signature of the info are set by the caller
+type Prober func(ctx context.Context, s *Shrinker, src Source) (*MediaInfoV2, error)
+
+// Generator write a null media of info into sink
+type Generator func(ctx context.Context, s *Shrinker, info *MediaInfoV2, sink Sink) error
+
+// mediaType a registered media type
+type mediaType struct {
+	ext       string
+	kind      MediaKind
+	matcher   matchers.Matcher
+	prober    Prober
+	generator Generator
+}
+
+// registry of media types, built-in types are registered in init
+var registry = struct {
+	sync.RWMutex
+	types []*mediaType // in the order matchers are tried by guessExt
+	index map[string]*mediaType
+}{index: map[string]*mediaType{}}
+
+// RegisterMediaType register a media type of ext, which is then recognized by GetMediaInfo, guessExt & Shrink.
+// matcher detects the type from the first 64 bytes of a file for guessing, it can be nil;
+// prober & generator default to the built-in ones of kind when nil, which try the backends of the Shrinker
+// in order, see NewShrinker. registering a type of an existing ext replaces it.
+func RegisterMediaType(ext string, kind MediaKind, matcher matchers.Matcher, prober Prober, generator Generator) error {
+	ext = strings.ToLower(strings.TrimPrefix(ext, "."))
+	if len(ext) == 0 {
+		return errors.New("empty ext of media type")
+	}
+	if kind < KindImage || kind > KindAudio {
+		return errors.New("unknown kind of media type " + ext)
+	}
+	if prober == nil {
+		prober = defaultProbers[kind]
+	}
+	if generator == nil {
+		generator = defaultGenerators[kind]
+	}
+	t := &mediaType{ext: ext, kind: kind, matcher: matcher, prober: prober, generator: generator}
+
+	registry.Lock()
+	defer registry.Unlock()
+	if old, exists := registry.index[ext]; exists {
+		for i := range registry.types {
+			if registry.types[i] == old {
+				registry.types[i] = t
+			}
+		}
+	} else {
+		registry.types = append(registry.types, t)
+	}
+	registry.index[ext] = t
+	return nil
+}
+
+// lookupMediaType get the registered media type of ext
+func lookupMediaType(ext string) (*mediaType, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	t, exists := registry.index[ext]
+	return t, exists
+}
+
+// mediaTypes get the registered media types of kind in order
+func mediaTypes(kind MediaKind) []*mediaType {
+	registry.RLock()
+	defer registry.RUnlock()
+	var types []*mediaType
+	for _, t := range registry.types {
+		if t.kind == kind {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// mediaMatchers make a copy of the matchers of kind
+func mediaMatchers(kind MediaKind) map[string]matchers.Matcher {
+	m := map[string]matchers.Matcher{}
+	for _, t := range mediaTypes(kind) {
+		if t.matcher != nil {
+			m[t.ext] = t.matcher
+		}
+	}
+	return m
+}
+
+// built-in probers & generators of each kind, native parsers & generators are tried first by ext
+var (
+	defaultProbers = map[MediaKind]Prober{
+		KindImage: func(ctx context.Context, s *Shrinker, src Source) (*MediaInfoV2, error) {
+			return s.getImageInfo(ctx, src)
+		},
+		KindVideo: func(ctx context.Context, s *Shrinker, src Source) (*MediaInfoV2, error) {
+			return s.getVideoInfo(ctx, src)
+		},
+		KindAudio: func(ctx context.Context, s *Shrinker, src Source) (*MediaInfoV2, error) {
+			return s.getAudioInfo(ctx, src)
+		},
+	}
+	defaultGenerators = map[MediaKind]Generator{
+		KindImage: func(ctx context.Context, s *Shrinker, info *MediaInfoV2, sink Sink) error {
+			return s.makeNullImage(ctx, info, sink)
+		},
+		KindVideo: func(ctx context.Context, s *Shrinker, info *MediaInfoV2, sink Sink) error {
+			return s.makeNullVideo(ctx, info, sink)
+		},
+		KindAudio: func(ctx context.Context, s *Shrinker, info *MediaInfoV2, sink Sink) error {
+			return s.makeNullAudio(ctx, info, sink)
+		},
+	}
+)
+
+// registerBuiltinMediaTypes register the built-in media types in kind & ext order
+func registerBuiltinMediaTypes(kind MediaKind, types map[string]matchers.Matcher) {
+	exts := make([]string, 0, len(types))
+	for ext := range types {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+	for _, ext := range exts {
+		RegisterMediaType(ext, kind, types[ext], nil, nil)
+	}
+}
+
+func init() {
+	registerBuiltinMediaTypes(KindImage, image)
+	registerBuiltinMediaTypes(KindVideo, video)
+	registerBuiltinMediaTypes(KindAudio, audio)
+}
@@ -0,0 +1,101 @@
+package mediashrink
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testMediaMagic the magic of the test media type: "MSTEST" width(2) height(2) in big endian
+const testMediaMagic = "MSTEST"
+
+// parseTestMedia get the dimension of a test media
+func parseTestMedia(r io.ReaderAt, size int64) (*MediaInfoV2, error) {
+	header := make([]byte, len(testMediaMagic)+4)
+	if err := readAt(r, header, 0); err != nil || string(header[:len(testMediaMagic)]) != testMediaMagic {
+		return nil, errors.New("not a test media")
+	}
+	width := binary.BigEndian.Uint16(header[len(testMediaMagic):])
+	height := binary.BigEndian.Uint16(header[len(testMediaMagic)+2:])
+	return &MediaInfoV2{Width: uint32(width), Height: uint32(height)}, nil
+}
+
+// writeTestMedia write a test media of info into w
+func writeTestMedia(w io.Writer, info *MediaInfoV2) error {
+	data := []byte(testMediaMagic)
+	data = binary.BigEndian.AppendUint16(data, uint16(info.Width))
+	data = binary.BigEndian.AppendUint16(data, uint16(info.Height))
+	_, err := w.Write(data)
+	return err
+}
+
+func TestRegisterMediaType(t *testing.T) {
+	probed := 0
+	err := RegisterMediaType(".MSTest", KindImage, func(header []byte) bool {
+		return bytes.HasPrefix(header, []byte(testMediaMagic))
+	}, func(ctx context.Context, s *Shrinker, src Source) (*MediaInfoV2, error) {
+		probed++
+		return src.Parse(parseTestMedia)
+	}, func(ctx context.Context, s *Shrinker, info *MediaInfoV2, sink Sink) error {
+		return sink.Generate(info, writeTestMedia)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	input := filepath.Join(dir, "media.mstest")
+	if err := os.WriteFile(input, []byte(testMediaMagic+"\x01\x00\x00\xC0 and its pixels"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := NewShrinker()
+	info, err := s.GetMediaInfo("123456", false, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := MediaInfo{Width: 256, Height: 192, Signature: "123456", Ext: "mstest"}
+	if *info != want || probed != 1 {
+		t.Errorf("got %s probed %d times, want %s probed once", info.ToString(), probed, want.ToString())
+	}
+
+	// the type is guessed by its matcher
+	noExt := filepath.Join(dir, "media")
+	if err := os.Rename(input, noExt); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := s.GetMediaInfo("123456", true, noExt); err != nil || *info != want || probed != 2 {
+		t.Errorf("got %v with err %v probed %d times, want %s probed twice", info, err, probed, want.ToString())
+	}
+
+	output := filepath.Join(dir, "shrunk.mstest")
+	if err := s.Shrink(info, output); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(output); err != nil || string(data) != testMediaMagic+"\x01\x00\x00\xC0" {
+		t.Errorf("got %q with err %v, want the media made by the generator", data, err)
+	}
+
+	// the default prober & generator of images are used when nil, which replace the type registered
+	if err := RegisterMediaType("mstest", KindImage, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetMediaInfo("123456", false, output); err == nil || probed != 2 {
+		t.Errorf("got err %v probed %d more times, want the default prober failed", err, probed-2)
+	}
+}
+
+func TestRegisterMediaTypeInvalid(t *testing.T) {
+	if err := RegisterMediaType(".", KindImage, nil, nil, nil); err == nil {
+		t.Error("got no error of an empty ext")
+	}
+	if err := RegisterMediaType("mstestkind", MediaKind(0), nil, nil, nil); err == nil {
+		t.Error("got no error of an unknown kind")
+	}
+	if _, exists := lookupMediaType("mstestkind"); exists {
+		t.Error("got the media type registered by a failed registration")
+	}
+}
@@ -0,0 +1,208 @@
+package mediashrink
+
+import (
+	"sync"
+	"time"
+)
+
+// Shrinker probes and shrinks media with its own backends, temp dir, signature settings and logger,
+// create it with NewShrinker, it is safe for concurrent use
+type Shrinker struct {
+	commands      *CommandNames
+	imageBackend  ImageBackend
+	audioBackend  AudioBackend
+	videoBackend  VideoBackend
+	imageChain    []ImageBackend
+	audioChain    []AudioBackend
+	videoChain    []VideoBackend
+	tempDir       string
+	signatureFunc SignatureFunc
+	logger        Logger
+	timeout       time.Duration
+	verify        bool
+	tolerance     time.Duration
+	rotationMode  RotationMode
+}
+
+// SignatureFunc get the signature of the file at path when no signature is given,
+// it should return a hex string in min length of 6
+type SignatureFunc func(path string) (string, error)
+
+// Logger logs what a Shrinker is doing, *log.Logger satisfies it
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// Option configures a Shrinker
+type Option func(*Shrinker)
+
+// WithCommands use commands instead of the default ones found in $PATH,
+// nil members are left to the defaults. setting ImageMagicK or FFMPEG chooses the ImageMagick or ffmpeg backends
+// made of them, empty commands in them are looked up in $PATH
+func WithCommands(commands *CommandNames) Option {
+	return func(s *Shrinker) {
+		if commands == nil {
+			return
+		}
+		if commands.FFMPEG != nil {
+			s.commands.FFMPEG = commands.FFMPEG
+			backend := FFmpegBackend{FFMpeg: commands.FFMPEG.FFMpeg, FFProbe: commands.FFMPEG.FFProbe}
+			s.audioBackend, s.videoBackend = backend, backend
+		}
+		if commands.ImageMagicK != nil {
+			s.commands.ImageMagicK = commands.ImageMagicK
+			if len(commands.ImageMagicK.Magick) > 0 {
+				s.imageBackend = ImageMagick7Backend{Magick: commands.ImageMagicK.Magick}
+			} else {
+				s.imageBackend = ImageMagick6Backend{
+					Identify: commands.ImageMagicK.Identify,
+					Convert:  commands.ImageMagicK.Convert,
+				}
+			}
+		}
+		if commands.P7Zip != nil {
+			s.commands.P7Zip = commands.P7Zip
+		}
+	}
+}
+
+// WithTempDir write intermediate files into dir instead of next to the output or os.TempDir
+func WithTempDir(dir string) Option {
+	return func(s *Shrinker) {
+		s.tempDir = dir
+	}
+}
+
+// WithSignatureFunc get signatures of files with f instead of their MD5 when no signature is given
+func WithSignatureFunc(f SignatureFunc) Option {
+	return func(s *Shrinker) {
+		if f != nil {
+			s.signatureFunc = f
+		}
+	}
+}
+
+// WithLogger log executed commands and fallbacks into logger
+func WithLogger(logger Logger) Option {
+	return func(s *Shrinker) {
+		if logger != nil {
+			s.logger = logger
+		}
+	}
+}
+
+// WithTimeout kill every external command running longer than timeout with a TimeoutError,
+// no timeout is set by default, deadlines of contexts are respected as well
+func WithTimeout(timeout time.Duration) Option {
+	return func(s *Shrinker) {
+		s.timeout = timeout
+	}
+}
+
+// WithVerify probe every media made by Shrink & ShrinkTo again, which fails with a *VerifyError
+// if the dimension differs or the duration is off by more than tolerance, see Verify.
+// ffmpeg makes audios & videos again until their duration is within tolerance as well, see calibrate
+func WithVerify(tolerance time.Duration) Option {
+	return func(s *Shrinker) {
+		s.verify = true
+		s.tolerance = tolerance
+	}
+}
+
+// WithRotationMode choose how rotated videos are made, RotationMetadata by default
+func WithRotationMode(mode RotationMode) Option {
+	return func(s *Shrinker) {
+		s.rotationMode = mode
+	}
+}
+
+// ShrinkOption configures a single shrink of ShrinkV2 & ShrinkToV2
+type ShrinkOption func(*shrinkOptions)
+
+// shrinkOptions the options of a single shrink
+type shrinkOptions struct {
+	orientationMode OrientationMode
+}
+
+// WithOrientationMode choose how an image in an EXIF orientation is made by a single shrink,
+// OrientationMetadata by default
+func WithOrientationMode(mode OrientationMode) ShrinkOption {
+	return func(o *shrinkOptions) {
+		o.orientationMode = mode
+	}
+}
+
+// applyShrinkOptions get the info to make by a single shrink configured by options,
+// which is a copy of info if it is changed by them
+func applyShrinkOptions(info *MediaInfoV2, options []ShrinkOption) *MediaInfoV2 {
+	o := &shrinkOptions{}
+	for _, option := range options {
+		option(o)
+	}
+	if o.orientationMode == OrientationPreRotated && info.Orientation > 0 {
+		return info.Upright()
+	}
+	return info
+}
+
+// NewShrinker create a Shrinker configured by options, backends not chosen by options are detected in $PATH:
+// ImageMagick 7, ImageMagick 6, GraphicsMagick & libvips in order for images, ffmpeg for audios & videos,
+// the pure Go backends are used when none is installed.
+// media are tried in a chain of the pure Go backend, the chosen backend, then ffmpeg for images,
+// unless the chains are set by WithImageBackends, WithAudioBackends & WithVideoBackends.
+func NewShrinker(options ...Option) *Shrinker {
+	s := &Shrinker{
+		commands:      DefaultCommandNames(),
+		signatureFunc: fileMD5,
+		logger:        nopLogger{},
+	}
+	for _, option := range options {
+		option(s)
+	}
+	if s.imageBackend == nil {
+		s.imageBackend = detectImageBackend()
+	}
+	if s.audioBackend == nil {
+		s.audioBackend = detectAudioBackend()
+	}
+	if s.videoBackend == nil {
+		s.videoBackend = detectVideoBackend()
+	}
+	if s.imageChain == nil {
+		s.imageChain = defaultImageChain(s.imageBackend, s.videoBackend)
+	}
+	if s.audioChain == nil {
+		s.audioChain = []AudioBackend{NativeAudioBackend{}}
+		if _, native := s.audioBackend.(NativeAudioBackend); !native {
+			s.audioChain = append(s.audioChain, s.audioBackend)
+		}
+	}
+	if s.videoChain == nil {
+		s.videoChain = []VideoBackend{NativeVideoBackend{}}
+		if _, native := s.videoBackend.(NativeVideoBackend); !native {
+			s.videoChain = append(s.videoChain, s.videoBackend)
+		}
+	}
+	return s
+}
+
+// defaultShrinker get the Shrinker used by the package level functions, which is created on first use,
+// so that $PATH is not looked up for backends when the package is imported only
+func defaultShrinker() *Shrinker {
+	lazyDefaultShrinker.Do(func() {
+		lazyDefaultShrinker.shrinker = NewShrinker()
+	})
+	return lazyDefaultShrinker.shrinker
+}
+
+// lazyDefaultShrinker the Shrinker of defaultShrinker
+var lazyDefaultShrinker struct {
+	sync.Once
+	shrinker *Shrinker
+}
+
+// nopLogger logs nothing
+type nopLogger struct{}
+
+// Printf implements Logger
+func (nopLogger) Printf(format string, v ...interface{}) {}
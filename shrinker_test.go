@@ -0,0 +1,179 @@
+package mediashrink
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	goimage "image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writePNG write a gray PNG of width x height with noise into dir, which is larger than its placeholder
+func writePNG(t *testing.T, dir string, width, height int) string {
+	t.Helper()
+	m := goimage.NewGray(goimage.Rect(0, 0, width, height))
+	for i := range m.Pix {
+		m.Pix[i] = byte(i * 7919 % 251)
+	}
+	path := filepath.Join(dir, "noise.png")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, m); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// readPNGColor read the color of the top left pixel of the PNG at path
+func readPNGColor(t *testing.T, path string) color.RGBA {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	m, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return color.RGBAModel.Convert(m.At(0, 0)).(color.RGBA)
+}
+
+func TestShrinkerSignatureFunc(t *testing.T) {
+	input := writePNG(t, t.TempDir(), 40, 30)
+	signedPath := ""
+	s := NewShrinker(WithSignatureFunc(func(path string) (string, error) {
+		signedPath = path
+		return "abcdef0123", nil
+	}))
+	info, err := s.GetMediaInfo("", false, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signedPath != input || info.Signature != "abcdef" {
+		t.Errorf("got signature %s of %s, want abcdef of %s", info.Signature, signedPath, input)
+	}
+	// a signature given is used as it is
+	if info, err := s.GetMediaInfo("123456", false, input); err != nil || info.Signature != "123456" {
+		t.Errorf("got %v with err %v, want the signature 123456", info, err)
+	}
+	output := filepath.Join(t.TempDir(), "shrunk.png")
+	if err := s.Shrink(info, output); err != nil {
+		t.Fatal(err)
+	}
+	if c := readPNGColor(t, output); c != (color.RGBA{0xAB, 0xCD, 0xEF, 0xFF}) {
+		t.Errorf("got color %v, want #abcdef", c)
+	}
+
+	// another Shrinker signs in MD5 by default
+	sum, err := fileMD5(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := NewShrinker().GetMediaInfo("", false, input); err != nil || info.Signature != sum[:6] {
+		t.Errorf("got %v with err %v, want the signature %s", info, err, sum[:6])
+	}
+
+	errSign := errors.New("sign failed")
+	s = NewShrinker(WithSignatureFunc(func(string) (string, error) { return "", errSign }))
+	if _, err := s.GetMediaInfo("", false, input); !errors.Is(err, errSign) {
+		t.Errorf("got err %v, want %v", err, errSign)
+	}
+	s = NewShrinker(WithSignatureFunc(func(string) (string, error) { return "xyz", nil }))
+	if _, err := s.GetMediaInfo("", false, input); err == nil {
+		t.Error("got no error of an invalid signature")
+	}
+}
+
+func TestShrinkerTempDir(t *testing.T) {
+	input := writePNG(t, t.TempDir(), 40, 30)
+	tempDir, outputDir := t.TempDir(), t.TempDir()
+	s := NewShrinker(WithTempDir(tempDir))
+	info, err := s.GetMediaInfo("123456", false, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(outputDir, "shrunk.png")
+	if err := s.Shrink(info, output); err != nil {
+		t.Fatal(err)
+	}
+	// the media is made in the temp dir, which is cleaned up, & moved to the output only
+	for dir, want := range map[string]int{tempDir: 0, outputDir: 1} {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != want {
+			t.Errorf("got %d entries in %s, want %d", len(entries), dir, want)
+		}
+	}
+	if shrunk, err := s.GetMediaInfo("123456", false, output); err != nil || *shrunk != *info {
+		t.Errorf("got %v with err %v, want %v", shrunk, err, info)
+	}
+}
+
+func TestWithOrientationMode(t *testing.T) {
+	s := NewShrinker(WithImageBackends(NativeImageBackend{}))
+	info := &MediaInfoV2{Width: 40, Height: 30, Signature: "abcdef", Ext: "jpg", Orientation: 6}
+	tests := []struct {
+		name          string
+		options       []ShrinkOption
+		width, height uint32
+		orientation   int
+	}{
+		{"metadata by default", nil, 40, 30, 6},
+		{"metadata", []ShrinkOption{WithOrientationMode(OrientationMetadata)}, 40, 30, 6},
+		{"pre-rotated", []ShrinkOption{WithOrientationMode(OrientationPreRotated)}, 30, 40, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := s.ShrinkToV2(info, &buf, test.options...); err != nil {
+				t.Fatal(err)
+			}
+			probed, err := s.ProbeReaderV2(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if probed.Width != test.width || probed.Height != test.height || probed.Orientation != test.orientation {
+				t.Errorf("got %dx%d in orientation %d, want %dx%d in orientation %d", probed.Width, probed.Height,
+					probed.Orientation, test.width, test.height, test.orientation)
+			}
+			if info.Width != 40 || info.Height != 30 || info.Orientation != 6 {
+				t.Errorf("info is changed into %s", info.ToString())
+			}
+		})
+	}
+	// images without orientation & videos are made as is
+	for _, unchanged := range []*MediaInfoV2{
+		{Width: 40, Height: 30, Signature: "abcdef", Ext: "jpg"},
+		{Width: 40, Height: 30, Signature: "abcdef", Ext: "mp4", Rotation: 90},
+	} {
+		options := []ShrinkOption{WithOrientationMode(OrientationPreRotated)}
+		if got := applyShrinkOptions(unchanged, options); got != unchanged {
+			t.Errorf("got %s, want %s as is", got.ToString(), unchanged.ToString())
+		}
+	}
+}
+
+func TestOrientationUnsupported(t *testing.T) {
+	// vips & ffmpeg can not write the EXIF orientation, which fail before running
+	info := &MediaInfoV2{Width: 40, Height: 30, Signature: "abcdef", Ext: "jpg", Orientation: 6}
+	for _, backend := range []ImageBackend{
+		VipsBackend{Vips: "mediashrink-missing-vips"},
+		FFmpegBackend{FFMpeg: "mediashrink-missing-ffmpeg"},
+	} {
+		err := backend.MakeNullImage(context.Background(), NewShrinker(), info,
+			NewFileSink(filepath.Join(t.TempDir(), "oriented.jpg")))
+		if !errors.Is(err, ErrUnsupportedFormat) {
+			t.Errorf("%s: got error %v, want %v", backend.Name(), err, ErrUnsupportedFormat)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package mediashrink
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Source a media to probe, either a file or bytes of a reader, in the media type of its ext
+type Source struct {
+	path   string
+	r      io.ReaderAt
+	size   int64
+	ext    string
+	layout bool // the stream layout & chapters are needed, which may take another probe
+}
+
+// NewFileSource a source of the file at path in the media type of ext, to call the backends directly
+func NewFileSource(path, ext string) Source {
+	return Source{path: path, ext: ext}
+}
+
+// NewReaderSource a source of the first size bytes of r in the media type of ext
+func NewReaderSource(r io.ReaderAt, size int64, ext string) Source {
+	return Source{r: r, size: size, ext: ext}
+}
+
+// Path the path of the source file, empty for readers, which are read by Parse
+func (src Source) Path() string {
+	return src.path
+}
+
+// Ext the ext of the media type to probe the source as
+func (src Source) Ext() string {
+	return src.ext
+}
+
+// Name the path of the source, used in logs & errors
+func (src Source) Name() string {
+	if len(src.path) > 0 {
+		return src.path
+	}
+	return "<reader>"
+}
+
+// Parse get the media info of the source using parser, files are opened for it
+func (src Source) Parse(parser func(r io.ReaderAt, size int64) (*MediaInfoV2, error)) (*MediaInfoV2, error) {
+	if len(src.path) > 0 {
+		return getHeaderInfo(src.path, parser)
+	}
+	return parser(src.r, src.size)
+}
+
+// input get the input argument of ImageMagick for the source,
+// readers are piped into stdin as "ext:-"
+func (src Source) input() (string, io.Reader) {
+	if len(src.path) > 0 {
+		return src.path, nil
+	}
+	return src.ext + ":-", io.NewSectionReader(src.r, 0, src.size)
+}
+
+// file get a file path of the source for tools which need to seek like ffprobe,
+// readers are copied into a temp file in tempDir which is removed by calling release
+func (src Source) file(tempDir string) (path string, release func(), err error) {
+	if len(src.path) > 0 {
+		return src.path, func() {}, nil
+	}
+	workDir, err := ioutil.TempDir(tempDir, "mediashrink")
+	if err != nil {
+		return "", nil, err
+	}
+	release = func() { os.RemoveAll(workDir) }
+	path = filepath.Join(workDir, "probe."+src.ext)
+	f, err := os.Create(path)
+	if err != nil {
+		release()
+		return "", nil, err
+	}
+	_, err = io.Copy(f, io.NewSectionReader(src.r, 0, src.size))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		release()
+		return "", nil, err
+	}
+	return path, release, nil
+}
+
+// Sink where a null media is written, either a file or a writer
+type Sink struct {
+	path    string
+	w       io.Writer
+	backend *string // set to the name of the backend which made the media if not nil
+}
+
+// NewFileSink a sink of the file at path, to call the backends directly
+func NewFileSink(path string) Sink {
+	return Sink{path: path}
+}
+
+// NewWriterSink a sink writing into w
+func NewWriterSink(w io.Writer) Sink {
+	return Sink{w: w}
+}
+
+// Path the path of the sink file, empty for writers, which are written by Generate
+func (sink Sink) Path() string {
+	return sink.path
+}
+
+// Generate write a null media of info into the sink using generator, the file is removed on failure
+func (sink Sink) Generate(info *MediaInfoV2, generator func(w io.Writer, info *MediaInfoV2) error) error {
+	if len(sink.path) == 0 {
+		w := bufio.NewWriter(sink.w)
+		if err := generator(w, info); err != nil {
+			return err
+		}
+		return w.Flush()
+	}
+	f, err := os.Create(sink.path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if err = generator(w, info); err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(sink.path)
+	}
+	return err
+}
+
+// dirty check if anything is written into the writer of the sink, files are overwritten by the next backend
+func (sink Sink) dirty() bool {
+	cw, ok := sink.w.(*countingWriter)
+	return ok && cw.n > 0
+}
+
+// output get the output argument of ImageMagick for the sink,
+// writers are fed from stdout with "ext:-"
+func (sink Sink) output(ext string) (string, io.Writer) {
+	if len(sink.path) > 0 {
+		return sink.path, nil
+	}
+	return ext + ":-", sink.w
+}
+
+// file get a file path of the sink for tools which need to seek like ffmpeg muxing mp4,
+// for writers a temp file in tempDir is used, call done with the error of the tool,
+// which copies the temp file into the writer if there is no error and removes it
+func (sink Sink) file(tempDir, ext string) (path string, done func(err error) error, err error) {
+	if len(sink.path) > 0 {
+		return sink.path, func(err error) error { return err }, nil
+	}
+	workDir, err := ioutil.TempDir(tempDir, "mediashrink")
+	if err != nil {
+		return "", nil, err
+	}
+	path = filepath.Join(workDir, "shrink."+ext)
+	done = func(err error) error {
+		defer os.RemoveAll(workDir)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(sink.w, f)
+		return err
+	}
+	return path, done, nil
+}
+
+// ProbeReader return the MediaInfo of the media in the first size bytes of r,
+// the media type is guessed from the content and the signature is the MD5 of the content.
+// the media is parsed natively when possible, identify reads it from stdin,
+// it is copied into a temp file for ffprobe only.
+func ProbeReader(r io.ReaderAt, size int64) (*MediaInfo, error) {
+	return defaultShrinker().ProbeReaderContext(context.Background(), r, size)
+}
+
+// ProbeReaderContext is like ProbeReader but kills identify & ffprobe when ctx is done.
+func ProbeReaderContext(ctx context.Context, r io.ReaderAt, size int64) (*MediaInfo, error) {
+	return defaultShrinker().ProbeReaderContext(ctx, r, size)
+}
+
+// ProbeReader return the MediaInfo of the media in the first size bytes of r using s, see ProbeReader.
+func (s *Shrinker) ProbeReader(r io.ReaderAt, size int64) (*MediaInfo, error) {
+	return s.ProbeReaderContext(context.Background(), r, size)
+}
+
+// ProbeReaderContext is like ProbeReader but kills identify & ffprobe when ctx is done.
+func (s *Shrinker) ProbeReaderContext(ctx context.Context, r io.ReaderAt, size int64) (*MediaInfo, error) {
+	info, err := s.probeReader(ctx, r, size, false)
+	if err != nil {
+		return nil, err
+	}
+	return info.V1()
+}
+
+// ProbeReaderV2 is like ProbeReader but returns the info in full precision with size
+func ProbeReaderV2(r io.ReaderAt, size int64) (*MediaInfoV2, error) {
+	return defaultShrinker().ProbeReaderV2Context(context.Background(), r, size)
+}
+
+// ProbeReaderV2Context is like ProbeReaderV2 but kills identify & ffprobe when ctx is done.
+func ProbeReaderV2Context(ctx context.Context, r io.ReaderAt, size int64) (*MediaInfoV2, error) {
+	return defaultShrinker().ProbeReaderV2Context(ctx, r, size)
+}
+
+// ProbeReaderV2 is like ProbeReader of s but returns the info in full precision with size
+func (s *Shrinker) ProbeReaderV2(r io.ReaderAt, size int64) (*MediaInfoV2, error) {
+	return s.ProbeReaderV2Context(context.Background(), r, size)
+}
+
+// ProbeReaderV2Context is like ProbeReaderV2 but kills identify & ffprobe when ctx is done.
+func (s *Shrinker) ProbeReaderV2Context(ctx context.Context, r io.ReaderAt, size int64) (*MediaInfoV2, error) {
+	return s.probeReader(ctx, r, size, true)
+}
+
+// probeReader probe the media in the first size bytes of r with its stream layout if layout is set
+func (s *Shrinker) probeReader(ctx context.Context, r io.ReaderAt, size int64, layout bool) (*MediaInfoV2, error) {
+	headerSize := int64(maxFileHeaderSize)
+	if headerSize > size {
+		headerSize = size
+	}
+	header := make([]byte, headerSize)
+	if err := readAt(r, header, 0); err != nil {
+		return nil, err
+	}
+	ext := guessExtFromHeader(header)
+	if len(ext) == 0 {
+		return nil, fmt.Errorf("%w: <reader>", ErrUnknownMediaType)
+	}
+	signature, err := readerMD5(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return nil, err
+	}
+	info, err := s.probe(ctx, validateSignature(signature), Source{r: r, size: size, ext: ext, layout: layout})
+	if err != nil {
+		return nil, err
+	}
+	info.Size = size
+	return info, nil
+}
+
+// ShrinkTo writes a shrink media using info into w
+func (info *MediaInfo) ShrinkTo(w io.Writer) error {
+	return defaultShrinker().ShrinkToContext(context.Background(), info, w)
+}
+
+// ShrinkToContext is like ShrinkTo but kills convert & ffmpeg when ctx is done.
+func (info *MediaInfo) ShrinkToContext(ctx context.Context, w io.Writer) error {
+	return defaultShrinker().ShrinkToContext(ctx, info, w)
+}
+
+// ShrinkTo writes a shrink media using info into w, native generators and convert write into w directly,
+// ffmpeg writes into a temp file which is copied into w then, since most containers need to seek.
+// with WithVerify, the media is always made in a temp file which is verified before copying into w.
+func (s *Shrinker) ShrinkTo(info *MediaInfo, w io.Writer) error {
+	return s.ShrinkToContext(context.Background(), info, w)
+}
+
+// ShrinkToContext is like ShrinkTo but kills convert & ffmpeg when ctx is done.
+func (s *Shrinker) ShrinkToContext(ctx context.Context, info *MediaInfo, w io.Writer) error {
+	return s.ShrinkToV2Context(ctx, info.V2(), w)
+}
+
+// ShrinkToV2 is like ShrinkTo but makes the media of info in full precision, see ShrinkV2
+func (s *Shrinker) ShrinkToV2(info *MediaInfoV2, w io.Writer, options ...ShrinkOption) error {
+	return s.ShrinkToV2Context(context.Background(), info, w, options...)
+}
+
+// ShrinkToV2Context is like ShrinkToV2 but kills convert & ffmpeg when ctx is done.
+func (s *Shrinker) ShrinkToV2Context(ctx context.Context, info *MediaInfoV2, w io.Writer,
+	options ...ShrinkOption) error {
+	info = applyShrinkOptions(info, options)
+	if s.verify {
+		return s.shrinkVerifiedTo(ctx, info, w)
+	}
+	return s.makeNullMedia(ctx, info, Sink{w: &countingWriter{w: w}})
+}
+
+// shrinkVerifiedTo make the media in a temp file to verify it before copying into w
+func (s *Shrinker) shrinkVerifiedTo(ctx context.Context, info *MediaInfoV2, w io.Writer) error {
+	workDir, err := ioutil.TempDir(s.tempDir, "mediashrink")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+	outputPath := filepath.Join(workDir, "verify."+info.Ext)
+	if err := s.ShrinkV2Context(ctx, info, outputPath); err != nil {
+		return err
+	}
+	f, err := os.Open(outputPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// countingWriter count bytes written into w, so that a failed backend which has written
+// is not followed by another one
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// readerMD5 cal MD5 of everything in r
+func readerMD5(r io.Reader) (string, error) {
+	hash := md5.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
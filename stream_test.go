@@ -0,0 +1,74 @@
+package mediashrink
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProbeReaderShrinkTo(t *testing.T) {
+	data, err := os.ReadFile(writePNG(t, t.TempDir(), 40, 30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := md5.Sum(data)
+	// bytes after size are not read
+	r := bytes.NewReader(append(append([]byte{}, data...), "trailing junk"...))
+	s := NewShrinker()
+	info, err := s.ProbeReader(r, int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := MediaInfo{Width: 40, Height: 30, Signature: hex.EncodeToString(sum[:3]), Ext: "png"}
+	if *info != want {
+		t.Fatalf("got %s, want %s", info.ToString(), want.ToString())
+	}
+
+	var buf bytes.Buffer
+	if err := s.ShrinkTo(info, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() >= len(data) {
+		t.Errorf("got %d bytes shrunk, want less than %d", buf.Len(), len(data))
+	}
+	shrunk, err := s.ProbeReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shrunk.Width != want.Width || shrunk.Height != want.Height || shrunk.Ext != want.Ext {
+		t.Errorf("got %s shrunk, want %s", shrunk.ToString(), want.ToString())
+	}
+	path := filepath.Join(t.TempDir(), "shrunk.png")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if c, rgb := readPNGColor(t, path), sum[:3]; c != (color.RGBA{rgb[0], rgb[1], rgb[2], 0xFF}) {
+		t.Errorf("got color %v, want #%s", c, want.Signature)
+	}
+}
+
+func TestShrinkToAudio(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewShrinker()
+	if err := s.ShrinkTo(&MediaInfo{Duration: 1500, Signature: "abcdef", Ext: "wav"}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	info, err := s.ProbeReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Duration != 1500 || info.Ext != "wav" {
+		t.Errorf("got %s, want a wav of 1500 ms", info.ToString())
+	}
+}
+
+func TestProbeReaderUnknown(t *testing.T) {
+	data := []byte("neither an image, an audio nor a video")
+	if info, err := NewShrinker().ProbeReader(bytes.NewReader(data), int64(len(data))); err == nil {
+		t.Errorf("got %s, want an error", info.ToString())
+	}
+}
@@ -0,0 +1,183 @@
+# gen_fixtures.py hand-crafts the media of testdata next to it, run with python3
+import os
+import struct
+
+out = os.path.dirname(os.path.abspath(__file__))
+
+def w(name, data):
+    open(os.path.join(out, name), 'wb').write(data)
+
+# ---------- EBML ----------
+def eid(i):
+    n = (i.bit_length() + 7) // 8
+    return i.to_bytes(n, 'big')
+
+def esize(n, width=None):
+    if width is None:
+        width = 1
+        while n >= (1 << (7 * width)) - 1:
+            width += 1
+    return ((1 << (7 * width)) | n).to_bytes(width, 'big')
+
+def el(i, payload):
+    return eid(i) + esize(len(payload)) + payload
+
+def uint(i, v):
+    n = max(1, (v.bit_length() + 7) // 8)
+    return el(i, v.to_bytes(n, 'big'))
+
+def s(i, v):
+    return el(i, v.encode())
+
+def f64(i, v):
+    return el(i, struct.pack('>d', v))
+
+def f32(i, v):
+    return el(i, struct.pack('>f', v))
+
+def ebml_header(doctype):
+    return el(0x1A45DFA3, uint(0x4286, 1) + s(0x4282, doctype) + uint(0x4287, 4) + uint(0x4285, 2))
+
+info = el(0x1549A966, uint(0x2AD7B1, 1000000) + f64(0x4489, 2535.5) + s(0x4D80, 'handmade'))
+video = el(0xAE, uint(0xD7, 1) + uint(0x83, 1) + s(0x86, 'V_VP9') + uint(0x23E383, 41708333) +
+           el(0x63A2, bytes([1, 1, 2, 3, 1, 10, 4, 1, 1])) +
+           el(0xE0, uint(0xB0, 720) + uint(0xBA, 576) + uint(0x54B0, 1024) + uint(0x54BA, 576)))
+audio = el(0xAE, uint(0xD7, 2) + uint(0x83, 2) + s(0x86, 'A_OPUS') + s(0x22B59C, 'jpn') +
+           s(0x536E, 'Director: cut, take 2 & more') + uint(0x88, 0) + uint(0x55AF, 1) +
+           el(0xE1, uint(0x9F, 6) + f32(0xB5, 48000.0)))
+subtitle = el(0xAE, uint(0xD7, 3) + uint(0x83, 0x11) + s(0x86, 'S_TEXT/UTF8') + uint(0x55AA, 1))
+buttons = el(0xAE, uint(0xD7, 4) + uint(0x83, 0x12) + s(0x86, 'B_VOBBTN'))
+tracks = el(0x1654AE6B, video + audio + subtitle + buttons)
+def atom(start, title, end=None, hidden=False):
+    payload = uint(0x91, start)
+    if end is not None:
+        payload += uint(0x92, end)
+    if hidden:
+        payload += uint(0x98, 1)
+    return el(0xB6, payload + el(0x80, s(0x85, title) + s(0x437C, 'eng')))
+chapters = el(0x1043A770, el(0x45B9, atom(0, 'Intro', 1000000000) + atom(500000000, 'Hidden', hidden=True) +
+                                     atom(1000000000, 'Main: part 1')))
+cluster = el(0x1F43B675, uint(0xE7, 0))
+payload = info + tracks + chapters + cluster
+w('tracks.mkv', ebml_header('matroska') + eid(0x18538067) + esize(len(payload), 8) + payload)
+
+# Info & Tracks after the clusters in a segment of unknown size, located by the SeekHead
+info = el(0x1549A966, f32(0x4489, 1500.0))
+tracks = el(0x1654AE6B, el(0xAE, uint(0x83, 2) + s(0x86, 'A_PCM/INT/LIT') +
+                               el(0xE1, uint(0x9F, 2) + f64(0xB5, 44100.0) + uint(0x6264, 16))))
+def seekhead(info_pos, tracks_pos):
+    return el(0x114D9B74, el(0x4DBB, el(0x53AB, eid(0x1549A966)) + el(0x53AC, info_pos.to_bytes(4, 'big'))) +
+              el(0x4DBB, el(0x53AB, eid(0x1654AE6B)) + el(0x53AC, tracks_pos.to_bytes(4, 'big'))))
+head_size = len(seekhead(0, 0))
+info_pos = head_size + len(cluster)
+payload = seekhead(info_pos, info_pos + len(info)) + cluster + info + tracks
+w('seekhead.webm', ebml_header('webm') + eid(0x18538067) + b'\x01\xff\xff\xff\xff\xff\xff\xff' + payload)
+
+# ---------- ISO-BMFF ----------
+def box(t, payload, large=False):
+    if large:
+        return struct.pack('>I4sQ', 1, t.encode(), 16 + len(payload)) + payload
+    return struct.pack('>I4s', 8 + len(payload), t.encode()) + payload
+
+def full(v, flags):
+    return struct.pack('>I', v << 24 | flags)
+
+def lang(code):
+    return (ord(code[0]) - 0x60) << 10 | (ord(code[1]) - 0x60) << 5 | (ord(code[2]) - 0x60)
+
+identity = struct.pack('>9i', 0x10000, 0, 0, 0, 0x10000, 0, 0, 0, 0x40000000)
+rotate90 = struct.pack('>9i', 0, 0x10000, 0, -0x10000, 0, 0, 0, 0, 0x40000000)
+
+def mvhd0(timescale, duration):
+    return box('mvhd', full(0, 0) + struct.pack('>IIII', 0, 0, timescale, duration) +
+               struct.pack('>IH10x', 0x10000, 0x100) + identity + bytes(24) + struct.pack('>I', 4))
+
+def tkhd0(flags, track_id, duration, matrix, width, height):
+    return box('tkhd', full(0, flags) + struct.pack('>IIIII', 0, 0, track_id, 0, duration) + bytes(8) +
+               struct.pack('>hhhH', 0, 0, 0, 0) + matrix + struct.pack('>II', width << 16, height << 16))
+
+def mdhd0(timescale, duration, language):
+    return box('mdhd', full(0, 0) + struct.pack('>IIIIHH', 0, 0, timescale, duration, language, 0))
+
+def hdlr(handler):
+    return box('hdlr', full(0, 0) + struct.pack('>I4s12x', 0, handler.encode()) + b'handler\x00')
+
+def stbl(entry, *others):
+    return box('minf', box('stbl', box('stsd', full(0, 0) + struct.pack('>I', 1) + entry) + b''.join(others)))
+
+visual = bytes(6) + struct.pack('>H', 1) + bytes(16) + struct.pack('>HHIII', 64, 48, 0x480000, 0x480000, 0) + \
+    struct.pack('>H', 1) + bytes(32) + struct.pack('>Hh', 0x18, -1)
+assert len(visual) == 78
+avc1 = box('avc1', visual + box('avcC', bytes([1, 77, 0x40, 30, 0xFF, 0xE0, 0x00])))
+stts = box('stts', full(0, 0) + struct.pack('>III', 2, 120, 512) + struct.pack('>II', 1, 256))
+video = box('trak', tkhd0(3, 1, 5000, rotate90, 64, 48) + box('tref', box('chap', struct.pack('>I', 3))) +
+            box('mdia', mdhd0(12800, 64000, lang('und')) + hdlr('vide') + stbl(avc1, stts)))
+mp4a = box('mp4a', bytes(6) + struct.pack('>HHH4sHHHHI', 1, 0, 0, b'\0\0\0\0', 2, 16, 0, 0, 48000 << 16))
+audio = box('trak', tkhd0(0, 2, 5000, identity, 0, 0) +
+            box('mdia', mdhd0(48000, 240000, lang('jpn')) + hdlr('soun') + stbl(mp4a)) +
+            box('udta', box('name', 'Director: cut, take 2 & more'.encode())))
+text = box('trak', tkhd0(0, 3, 5000, identity, 0, 0) + box('mdia', mdhd0(1000, 5000, lang('eng')) + hdlr('text')))
+def chpl(entries):
+    payload = full(1, 0) + bytes(4) + bytes([len(entries)])
+    for start, title in entries:
+        payload += struct.pack('>QB', start, len(title)) + title.encode()
+    return box('chpl', payload)
+udta = box('udta', chpl([(0, 'Intro'), (25000000, 'Main: part 1')]))
+moov = box('moov', mvhd0(1000, 5000) + video + audio + text + udta)
+w('rotated.mp4', box('ftyp', b'isom' + struct.pack('>I', 0x200) + b'isomiso2avc1mp41') + moov + box('mdat', b''))
+
+# mdat in a large size box before moov, mvhd, tkhd & mdhd in version 1 and flac in the sample entry
+def streaminfo(rate, channels, bits, total):
+    return struct.pack('>HH', 4096, 4096) + bytes(6) + \
+        struct.pack('>Q', rate << 44 | (channels - 1) << 41 | (bits - 1) << 36 | total) + bytes(16)
+mvhd1 = box('mvhd', full(1, 0) + struct.pack('>QQIQ', 0, 0, 44100, 132300) + struct.pack('>IH10x', 0x10000, 0x100) +
+            identity + bytes(24) + struct.pack('>I', 2))
+tkhd1 = box('tkhd', full(1, 1) + struct.pack('>QQIIQ', 0, 0, 1, 0, 132300) + bytes(8) +
+            struct.pack('>hhhH', 0, 0, 0x100, 0) + identity + struct.pack('>II', 0, 0))
+mdhd1 = box('mdhd', full(1, 0) + struct.pack('>QQIQHH', 0, 0, 44100, 132300, 0, 0))
+dfla = box('dfLa', full(0, 0) + bytes([0x80, 0, 0, 34]) + streaminfo(44100, 2, 24, 132300))
+flac = box('fLaC', bytes(6) + struct.pack('>HHH4sHHHHI', 1, 0, 0, b'\0\0\0\0', 2, 16, 0, 0, 44100 << 16) + dfla)
+trak = box('trak', tkhd1 + box('mdia', mdhd1 + hdlr('soun') + stbl(flac)))
+w('flac.m4a', box('ftyp', b'M4A ' + struct.pack('>I', 0) + b'M4A mp42isom') + box('mdat', bytes(4), large=True) +
+  box('moov', mvhd1 + trak))
+
+# ---------- TIFF ----------
+def ifd(order, entries, next_ifd=0):
+    data = struct.pack(order + 'H', len(entries))
+    for tag, typ, count, value in entries:
+        if isinstance(value, bytes):
+            data += struct.pack(order + 'HHI', tag, typ, count) + value
+        elif typ == 3 and count == 1:
+            data += struct.pack(order + 'HHIH2x', tag, typ, count, value)
+        else:
+            data += struct.pack(order + 'HHII', tag, typ, count, value)
+    return data + struct.pack(order + 'I', next_ifd)
+
+# 2x2 RGB with BitsPerSample & the strip stored after the IFD
+ifd_size = 2 + 9 * 12 + 4
+bits_offset = 8 + ifd_size
+strip_offset = bits_offset + 6
+pixels = bytes([255, 0, 0, 0, 255, 0, 0, 0, 255, 255, 255, 255])
+w('rgb.tiff', b'II' + struct.pack('<HI', 42, 8) + ifd('<', [
+    (256, 3, 1, 2), (257, 3, 1, 2), (258, 3, 3, bits_offset), (259, 3, 1, 1), (262, 3, 1, 2),
+    (273, 4, 1, strip_offset), (277, 3, 1, 3), (278, 3, 1, 2), (279, 4, 1, len(pixels))]) +
+  struct.pack('<HHH', 8, 8, 8) + pixels)
+
+# 3x1 gray in big endian with the strip stored before the IFD
+w('gray.tiff', b'MM' + struct.pack('>HI', 42, 12) + bytes([0, 128, 255, 0]) + ifd('>', [
+    (256, 4, 1, 3), (257, 4, 1, 1), (258, 3, 1, 8), (259, 3, 1, 1), (262, 3, 1, 1),
+    (273, 4, 1, 8), (277, 3, 1, 1), (278, 4, 1, 1), (279, 4, 1, 3)]))
+
+# the tags used for probing only
+w('oriented.tiff', b'II' + struct.pack('<HI', 42, 8) + ifd('<', [(256, 4, 1, 5), (257, 3, 1, 7), (274, 3, 1, 6)]))
+
+big = b'II' + struct.pack('<HHHQ', 43, 8, 0, 16) + struct.pack('<Q', 3)
+big += struct.pack('<HHQQ', 256, 16, 1, 70000) + struct.pack('<HHQI4x', 257, 4, 1, 3) + \
+    struct.pack('<HHQH6x', 274, 3, 1, 3) + struct.pack('<Q', 0)
+w('big.tiff', big)
+
+# ---------- FLAC ----------
+w('surround.flac', b'fLaC' + bytes([0x00, 0, 0, 34]) + streaminfo(48000, 6, 24, 120000) +
+  bytes([0x81, 0, 0, 4]) + bytes(4))
+w('id3.flac', b'ID3' + bytes([3, 0, 0, 0, 0, 0, 16]) + bytes(16) + b'fLaC' + bytes([0x80, 0, 0, 34]) +
+  streaminfo(44100, 2, 16, 154350))
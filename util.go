@@ -120,3 +120,32 @@ func acquireFileHeaderReader(c io.Reader) *bufio.Reader {
 func releaseFileHeaderReader(r *bufio.Reader) {
 	fileHeaderBufioPool.Put(r)
 }
+
+// headerParser get media info by parsing the necessary parts of a file only, without exec anything
+type headerParser func(r io.ReaderAt, size int64) (*MediaInfoV2, error)
+
+// getHeaderInfo open filePath and get its media info using parser
+func getHeaderInfo(filePath string, parser headerParser) (*MediaInfoV2, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return parser(f, stat.Size())
+}
+
+// readAt read exactly len(buf) bytes at off
+func readAt(r io.ReaderAt, buf []byte, off int64) error {
+	n, err := r.ReadAt(buf, off)
+	if n == len(buf) {
+		return nil
+	}
+	if err == nil || err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
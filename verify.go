@@ -0,0 +1,58 @@
+package mediashrink
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultVerifyTolerance the duration tolerance of Verify when the Shrinker is not created WithVerify
+const DefaultVerifyTolerance = 100 * time.Millisecond
+
+// Verify probe the shrunk media at path in the format of expected.Ext, and return a *VerifyError
+// if its dimension differs from expected or its duration is off by more than DefaultVerifyTolerance
+func Verify(path string, expected *MediaInfo) error {
+	return defaultShrinker().VerifyContext(context.Background(), path, expected)
+}
+
+// VerifyContext is like Verify but kills identify & ffprobe when ctx is done.
+func VerifyContext(ctx context.Context, path string, expected *MediaInfo) error {
+	return defaultShrinker().VerifyContext(ctx, path, expected)
+}
+
+// Verify probe the shrunk media at path using s, the tolerance of WithVerify is used if given, see Verify
+func (s *Shrinker) Verify(path string, expected *MediaInfo) error {
+	return s.VerifyContext(context.Background(), path, expected)
+}
+
+// durationTolerance the tolerance of WithVerify, or DefaultVerifyTolerance
+func (s *Shrinker) durationTolerance() time.Duration {
+	if s.verify {
+		return s.tolerance
+	}
+	return DefaultVerifyTolerance
+}
+
+// VerifyContext is like Verify but kills identify & ffprobe when ctx is done.
+func (s *Shrinker) VerifyContext(ctx context.Context, path string, expected *MediaInfo) error {
+	return s.verifyMedia(ctx, path, expected.V2())
+}
+
+// verifyMedia probe the shrunk media at path and compare it with expected in full precision
+func (s *Shrinker) verifyMedia(ctx context.Context, path string, expected *MediaInfoV2) error {
+	tolerance := s.durationTolerance()
+	actual, err := s.probe(ctx, expected.Signature, Source{path: path, ext: expected.Ext})
+	if err != nil {
+		return err
+	}
+	margin := actual.Duration - expected.Duration
+	if margin < 0 {
+		margin = -margin
+	}
+	// pre-rotated videos are compared in the display dimension
+	actualWidth, actualHeight := actual.DisplaySize()
+	expectedWidth, expectedHeight := expected.DisplaySize()
+	if actualWidth != expectedWidth || actualHeight != expectedHeight || margin > tolerance {
+		return &VerifyError{Path: path, Expected: expected, Actual: actual, Tolerance: tolerance}
+	}
+	return nil
+}
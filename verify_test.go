@@ -0,0 +1,102 @@
+package mediashrink
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wrongSizeBackend makes every image natively in 1x1
+type wrongSizeBackend struct {
+	NativeImageBackend
+}
+
+// MakeNullImage implements ImageBackend
+func (wrongSizeBackend) MakeNullImage(ctx context.Context, s *Shrinker, info *MediaInfoV2, sink Sink) error {
+	wrong := *info
+	wrong.Width, wrong.Height = 1, 1
+	return NativeImageBackend{}.MakeNullImage(ctx, s, &wrong, sink)
+}
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	s := NewShrinker()
+	imageInfo := &MediaInfo{Width: 40, Height: 30, Signature: "abcdef", Ext: "png"}
+	var buf bytes.Buffer
+	if err := s.ShrinkTo(imageInfo, &buf); err != nil {
+		t.Fatal(err)
+	}
+	imagePath := filepath.Join(dir, "shrunk.png")
+	if err := os.WriteFile(imagePath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	audioInfo := &MediaInfo{Duration: 4500, Signature: "abcdef", Ext: "wav"}
+	audioPath := filepath.Join(dir, "shrunk.wav")
+	if err := s.Shrink(audioInfo, audioPath); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		expected *MediaInfo
+		ok       bool
+	}{
+		{"image", imagePath, imageInfo, true},
+		{"image in another dimension", imagePath, &MediaInfo{Width: 30, Height: 40, Signature: "abcdef", Ext: "png"}, false},
+		{"audio", audioPath, audioInfo, true},
+		{"audio within tolerance", audioPath, &MediaInfo{Duration: 4600, Signature: "abcdef", Ext: "wav"}, true},
+		{"audio too short", audioPath, &MediaInfo{Duration: 5000, Signature: "abcdef", Ext: "wav"}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := s.Verify(test.path, test.expected)
+			if test.ok {
+				if err != nil {
+					t.Errorf("got err %v", err)
+				}
+				return
+			}
+			var verifyErr *VerifyError
+			if !errors.Is(err, ErrVerifyFailed) || !errors.As(err, &verifyErr) {
+				t.Fatalf("got err %v, want a *VerifyError", err)
+			}
+			if verifyErr.Path != test.path || verifyErr.Tolerance != DefaultVerifyTolerance ||
+				!strings.HasPrefix(err.Error(), ErrVerifyFailed.Error()+" "+test.path+": expected ") {
+				t.Errorf("got %v, want the error of %s", err, test.path)
+			}
+		})
+	}
+}
+
+func TestWithVerify(t *testing.T) {
+	dir := t.TempDir()
+	imageInfo := &MediaInfo{Width: 40, Height: 30, Signature: "abcdef", Ext: "png"}
+	s := NewShrinker(WithVerify(10 * time.Millisecond))
+	if err := s.Shrink(&MediaInfo{Duration: 5000, Signature: "abcdef", Ext: "wav"}, filepath.Join(dir, "a")); err != nil {
+		t.Error(err)
+	}
+	var buf bytes.Buffer
+	if err := s.ShrinkTo(imageInfo, &buf); err != nil || buf.Len() == 0 {
+		t.Errorf("got %d bytes with err %v", buf.Len(), err)
+	}
+
+	// media made wrong are removed instead of being moved to the output
+	s = NewShrinker(WithVerify(10*time.Millisecond), WithImageBackends(wrongSizeBackend{}))
+	output := filepath.Join(dir, "b")
+	if err := s.Shrink(imageInfo, output); !errors.Is(err, ErrVerifyFailed) {
+		t.Errorf("got err %v, want %v", err, ErrVerifyFailed)
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 1 {
+		t.Errorf("got %d files with err %v, want the 1st output only", len(entries), err)
+	}
+	buf.Reset()
+	if err := s.ShrinkTo(imageInfo, &buf); !errors.Is(err, ErrVerifyFailed) || buf.Len() > 0 {
+		t.Errorf("got %d bytes written with err %v, want nothing written with %v", buf.Len(), err, ErrVerifyFailed)
+	}
+}
@@ -1,67 +1,398 @@
 package mediashrink
 
 import (
+	"context"
 	"fmt"
-	"os/exec"
+	"io/ioutil"
+	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
-// getVideoInfo get audio and video duration in secs with video dimension as well
-func getVideoInfo(videoPath string) (*MediaInfo, error) {
-	info := &MediaInfo{0, 0, 0, "", ""}
-	// ffprobe -v quiet -print_format json -show_streams -show_format
-	// ffprobe -v quiet -show_entries stream=width,height -of default=noprint_wrappers=1:nokey=1
+// getVideoInfo get audio and video duration in secs with video dimension as well, the video backends are tried
+// in order, the file is parsed natively when possible, the video backend is used for the unusual ones
+func (s *Shrinker) getVideoInfo(ctx context.Context, src Source) (*MediaInfoV2, error) {
+	var info *MediaInfoV2
+	name, err := tryBackends(ctx, s, "probe "+src.Name(), s.videoChain, nil, func(backend VideoBackend) (err error) {
+		info, err = backend.ProbeVideo(ctx, s, src)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	info.Backend = name
+	if info.chapterTrack && src.layout {
+		s.readChapterTrack(ctx, src, info)
+	}
+	return info, nil
+}
 
-	// get dimension
-	if dimensionOutput, err := exec.Command(
-		commands.FFMPEG.FFProbe,
-		"-v", "quiet",
-		"-show_entries", "stream=width,height",
-		"-of", "default=noprint_wrappers=1:nokey=1",
-		videoPath).CombinedOutput(); err != nil {
-		return nil, fmt.Errorf("exec ffprobe %s with err: %s", videoPath, err)
-	} else if info.Width, info.Height, err = getWidthAndHeightFromBytes(dimensionOutput); err != nil {
-		return nil, fmt.Errorf("failed get width & height from %s with err %s", dimensionOutput, err)
+// readChapterTrack read the chapters in a text track of MP4 / MOV into info with the first ffmpeg backend,
+// the chapters are left empty with a warning if none can read them
+func (s *Shrinker) readChapterTrack(ctx context.Context, src Source, info *MediaInfoV2) {
+	for _, backend := range s.videoChain {
+		if b, ok := backend.(FFmpegBackend); ok {
+			if err := b.readChapters(ctx, s, src, info); err != nil {
+				s.logger.Printf("mediashrink: unable to read chapters of %s with err %s", src.Name(), err)
+			}
+			return
+		}
+	}
+	s.logger.Printf("mediashrink: chapters of %s are dropped, which are read by ffmpeg only", src.Name())
+}
+
+// readChapters read the chapters of src into info using ffprobe
+func (b FFmpegBackend) readChapters(ctx context.Context, s *Shrinker, src Source, info *MediaInfoV2) error {
+	videoPath, release, err := src.file(s.tempDir)
+	if err != nil {
+		return err
 	}
+	defer release()
+	probed, err := b.probe(ctx, s, videoPath)
+	if err != nil {
+		return err
+	}
+	layout := &MediaInfoV2{Duration: info.Duration}
+	if err := probed.setStreamLayout(layout); err != nil {
+		return err
+	}
+	info.Chapters = layout.Chapters
+	return nil
+}
 
-	// get duration
-	if d, err := getDuration(videoPath); err == nil {
-		info.Duration = d
-	} else {
+// ProbeVideo implements VideoBackend, get audio and video duration in secs with video dimension as well using ffprobe
+func (b FFmpegBackend) ProbeVideo(ctx context.Context, s *Shrinker, src Source) (*MediaInfoV2, error) {
+	videoPath, release, err := src.file(s.tempDir)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	probed, err := b.probe(ctx, s, videoPath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := probed.durationInfo()
+	if err != nil {
+		return nil, err
+	}
+	if err := probed.setVideoStream(info); err != nil {
+		return nil, err
+	}
+	probed.setAudioStream(info)
+	if err := probed.setStreamLayout(info); err != nil {
 		return nil, err
 	}
 	return info, nil
 }
 
-// makeNullVideo make a null video using vInfo, returns nil if success
-func (vInfo *MediaInfo) makeNullVideo(outputPath string) error {
+// getDimension get width & height of the first video stream in filePath using ffprobe
+func (b FFmpegBackend) getDimension(ctx context.Context, s *Shrinker, filePath string) (uint32, uint32, error) {
+	dimensionOutput, err := s.command(ctx,
+		b.ffprobe(),
+		"-v", "quiet",
+		"-show_entries", "stream=width,height",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		filePath).CombinedOutput()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed probe %s: %w", filePath, err)
+	}
+	width, height, err := getWidthAndHeightFromBytes(dimensionOutput)
+	if err != nil {
+		return 0, 0, &ParseError{Tool: "ffprobe", Output: dimensionOutput, Err: err}
+	}
+	return width, height, nil
+}
+
+// makeNullVideo make a null video using vInfo with the first video backend which can make it, returns nil if success
+func (s *Shrinker) makeNullVideo(ctx context.Context, vInfo *MediaInfoV2, sink Sink) error {
+	_, err := tryBackends(ctx, s, "make "+vInfo.ToString(), s.videoChain, &sink, func(backend VideoBackend) error {
+		return backend.MakeNullVideo(ctx, s, vInfo, sink)
+	})
+	return err
+}
+
+// MakeNullVideo implements VideoBackend, make a null video using ffmpeg
+func (b FFmpegBackend) MakeNullVideo(ctx context.Context, s *Shrinker, vInfo *MediaInfoV2, sink Sink) error {
 	// ffmpeg -f lavfi -i color=#123456:s=640x480:d=10.231 \
 	//        -f lavfi -i anullsrc=sample_rate=11025 -t 10.231  silence.mp4
-	// ffmpeg DTS delay time -11ms
-	dtsDelay := float32(0.011)
-	videoDimension := fmt.Sprintf("%dx%d", vInfo.Width, vInfo.Height)
-	videoDuration := fmt.Sprintf("%.2f", float32(int(vInfo.Duration/10))/100-dtsDelay)
-	audioDuration := fmt.Sprintf("%.3f", float32(vInfo.Duration)/1000-dtsDelay)
-
-	if info, err := exec.Command(
-		commands.FFMPEG.FFMpeg,
-		"-loglevel", "fatal",
-		"-y", "-f", "lavfi", "-i", "color=#"+vInfo.Signature+":s="+videoDimension+":d="+videoDuration,
-		"-f", "lavfi", "-i", "anullsrc=sample_rate="+getBestVideoSampleRate(outputPath),
-		"-t", audioDuration,
-		outputPath,
-	).CombinedOutput(); err != nil {
-		return fmt.Errorf("exec ffmpeg %s with err: %s, info: %s", outputPath, err, info)
+	// the duration is corrected by the calibration of the container, see calibrate
+	// the rotation is kept in metadata, or the video is made in the display dimension, see WithRotationMode
+	// the stream layout & chapters are made with null content, see newVideoLayout
+	// the codec, profile, pixel format & frame rate are kept when known, see videoCodecArgs
+	// the audio codec, channel layout, sample rate & bit depth are kept when known, see nullAudioSource
+	width, height := vInfo.Width, vInfo.Height
+	var inputArgs, outputArgs []string
+	if s.rotationMode == RotationPreRotated {
+		width, height = vInfo.DisplaySize()
+	} else if vInfo.Rotation != 0 {
+		inputArgs, outputArgs = b.rotationArgs(ctx, s, vInfo.Rotation)
 	}
-	return nil
+	outputArgs = append(outputArgs, b.videoCodecArgs(ctx, s, vInfo)...)
+	outputArgs = append(outputArgs, b.audioCodecArgs(ctx, s, vInfo)...)
+	videoSource := fmt.Sprintf("color=#%s:s=%dx%d", vInfo.Signature, width, height)
+	if rate := vInfo.FrameRate.Float64(); rate > 0 && rate <= maxFrameRate {
+		videoSource += ":r=" + vInfo.FrameRate.String()
+	}
+	target := vInfo.Duration
+
+	outputPath, done, err := sink.file(s.tempDir, vInfo.Ext)
+	if err != nil {
+		return err
+	}
+	layout, err := newVideoLayout(s, vInfo)
+	if err != nil {
+		return done(err)
+	}
+	defer layout.release()
+	return done(b.calibrate(ctx, s, "video", outputPath, vInfo.Duration, func(correction time.Duration) error {
+		videoDuration := durationArg(target.Truncate(10*time.Millisecond), correction)
+		args := []string{"-loglevel", "fatal", "-y"}
+		args = append(args, layout.args(
+			append(append([]string{}, inputArgs...), "-f", "lavfi", "-i", videoSource+":d="+videoDuration),
+			[]string{"-f", "lavfi", "-i", nullAudioSource(vInfo, getBestVideoSampleRate(outputPath))},
+		)...)
+		args = append(args, "-t", durationArg(target, correction))
+		args = append(append(args, outputArgs...), outputPath)
+		if _, err := s.command(ctx, b.ffmpeg(), args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed make %s: %w", outputPath, err)
+		}
+		return nil
+	}))
+}
+
+// maxFrameRate frame rates above it are ignored, which are the timebase of variable frame rate videos
+// in r_frame_rate like 90000/1
+const maxFrameRate = 1000
+
+// h264EncoderProfiles the profiles of libx264 for the H.264 profiles,
+// the profiles of the other codecs are decided by the pixel format
+var h264EncoderProfiles = map[string]string{
+	"Constrained Baseline":  "baseline",
+	"Baseline":              "baseline",
+	"Main":                  "main",
+	"High":                  "high",
+	"High 10":               "high10",
+	"High 4:2:2":            "high422",
+	"High 4:4:4 Predictive": "high444",
+}
+
+// ffmpegEncoders the codecs which can be encoded by each ffmpeg command
+var ffmpegEncoders = struct {
+	sync.Mutex
+	codecs map[string]map[string]bool // ffmpeg command -> codec names
+}{codecs: map[string]map[string]bool{}}
+
+// canEncode check if ffmpeg has an encoder of codec, which is true if the encoders can not be listed
+func (b FFmpegBackend) canEncode(ctx context.Context, s *Shrinker, codec string) bool {
+	ffmpegEncoders.Lock()
+	codecs, exists := ffmpegEncoders.codecs[b.ffmpeg()]
+	ffmpegEncoders.Unlock()
+	if exists {
+		return codecs[codec]
+	}
+	// ffmpeg -hide_banner -codecs
+	//  DEV.LS h264    H.264 / AVC / MPEG-4 AVC / MPEG-4 part 10 (decoders: h264 ) (encoders: libx264 )
+	// the 2nd flag is E if the codec can be encoded
+	output, err := s.command(ctx, b.ffmpeg(), "-hide_banner", "-codecs").Output()
+	if err != nil {
+		return true
+	}
+	codecs = map[string]bool{}
+	for _, line := range strings.Split(string(output), "\n") {
+		if fields := strings.Fields(line); len(fields) >= 2 && len(fields[0]) == 6 && fields[0][1] == 'E' {
+			codecs[fields[1]] = true
+		}
+	}
+	ffmpegEncoders.Lock()
+	ffmpegEncoders.codecs[b.ffmpeg()] = codecs
+	ffmpegEncoders.Unlock()
+	return codecs[codec]
+}
+
+// encoderArgs ffmpeg output args to encode the streams of specifier like v & a in codec of info, which are empty
+// with a warning if ffmpeg has no encoder of the codec, the default encoder of the container is used then
+func (b FFmpegBackend) encoderArgs(ctx context.Context, s *Shrinker, info *MediaInfoV2,
+	specifier, codec string) []string {
+	if len(codec) == 0 {
+		return nil
+	}
+	if !b.canEncode(ctx, s, codec) {
+		s.logger.Printf("mediashrink: ffmpeg has no encoder of %s, make %s in the default codec of %s",
+			codec, info.ToString(), info.Ext)
+		return nil
+	}
+	// the default encoder of the codec is matched by ffmpeg, like libx264 for h264
+	return []string{"-c:" + specifier, codec}
+}
+
+// videoCodecArgs ffmpeg output args to encode the video streams in the codec, profile & pixel format of vInfo,
+// the default encoder of the container is used with a warning if ffmpeg has no encoder of the codec
+func (b FFmpegBackend) videoCodecArgs(ctx context.Context, s *Shrinker, vInfo *MediaInfoV2) []string {
+	args := b.encoderArgs(ctx, s, vInfo, "v", vInfo.VideoCodec)
+	if profile, exists := h264EncoderProfiles[vInfo.VideoProfile]; exists && len(args) > 0 && vInfo.VideoCodec == "h264" {
+		args = append(args, "-profile:v", profile)
+	}
+	// the closest pixel format is chosen by ffmpeg if it is not supported by the encoder
+	if len(vInfo.PixelFormat) > 0 {
+		args = append(args, "-pix_fmt", vInfo.PixelFormat)
+	}
+	return args
+}
+
+// videoLayout the null inputs & output streams of ffmpeg to make the stream layout & chapters of a video
+type videoLayout struct {
+	streams      []Stream // streams to map in order, nil to make the default layout without mapping
+	subtitlePath string   // an empty subtitle for the subtitle streams
+	chaptersPath string   // the chapters in ffmetadata
+	release      func()   // remove the subtitle & chapters
+}
+
+// newVideoLayout prepare the layout of vInfo, streams which can not be held by the container are dropped,
+// the subtitle & chapters are written into a temp dir in the temp dir of s
+func newVideoLayout(s *Shrinker, vInfo *MediaInfoV2) (*videoLayout, error) {
+	layout := &videoLayout{release: func() {}}
+	if len(vInfo.Streams) == 0 && len(vInfo.Chapters) == 0 {
+		return layout, nil
+	}
+	ext := strings.ToLower(vInfo.Ext)
+	counts := map[StreamType]int{}
+	layout.streams = []Stream{}
+	for i, stream := range vInfo.streams() {
+		if (stream.Type == StreamSubtitle && !videoSubtitleContainers[ext]) ||
+			(videoSingleStreamContainers[ext] && counts[stream.Type] > 0) {
+			s.logger.Printf("mediashrink: %s stream %d of %s is dropped, which can not be held in %s",
+				stream.Type, i, vInfo.ToString(), ext)
+			continue
+		}
+		counts[stream.Type]++
+		layout.streams = append(layout.streams, stream)
+	}
+	if counts[StreamSubtitle] == 0 && len(vInfo.Chapters) == 0 {
+		return layout, nil
+	}
+
+	workDir, err := ioutil.TempDir(s.tempDir, "mediashrink")
+	if err != nil {
+		return nil, err
+	}
+	layout.release = func() { os.RemoveAll(workDir) }
+	if counts[StreamSubtitle] > 0 {
+		// subtitles without cues are rejected, a cue of the signature is shown in the 1st ms
+		layout.subtitlePath = filepath.Join(workDir, "null.srt")
+		subtitle := "1\n00:00:00,000 --> 00:00:00,001\n" + vInfo.Signature + "\n"
+		if err := ioutil.WriteFile(layout.subtitlePath, []byte(subtitle), 0644); err != nil {
+			layout.release()
+			return nil, err
+		}
+	}
+	if len(vInfo.Chapters) > 0 {
+		layout.chaptersPath = filepath.Join(workDir, "chapters.txt")
+		if err := ioutil.WriteFile(layout.chaptersPath, ffmetadataChapters(vInfo.Chapters), 0644); err != nil {
+			layout.release()
+			return nil, err
+		}
+	}
+	return layout, nil
+}
+
+// args ffmpeg args of the inputs & the output streams, video & audio are the input args of the null video & audio,
+// which are mapped for each of the video & audio streams
+func (layout *videoLayout) args(video, audio []string) []string {
+	if layout.streams == nil {
+		return append(video, audio...)
+	}
+	args := append([]string{}, video...)
+	inputs := map[StreamType]int{StreamVideo: 0}
+	addInput := func(t StreamType, input ...string) {
+		for _, stream := range layout.streams {
+			if stream.Type == t {
+				inputs[t] = len(inputs)
+				args = append(args, input...)
+				return
+			}
+		}
+	}
+	addInput(StreamAudio, audio...)
+	addInput(StreamSubtitle, "-f", "srt", "-i", layout.subtitlePath)
+	chaptersInput := len(inputs)
+	if len(layout.chaptersPath) > 0 {
+		args = append(args, "-f", "ffmetadata", "-i", layout.chaptersPath)
+	}
+
+	for i, stream := range layout.streams {
+		index := strconv.Itoa(i)
+		args = append(args, "-map", strconv.Itoa(inputs[stream.Type])+":0")
+		if len(stream.Language) > 0 {
+			args = append(args, "-metadata:s:"+index, "language="+stream.Language)
+		}
+		if len(stream.Title) > 0 {
+			args = append(args, "-metadata:s:"+index, "title="+stream.Title)
+		}
+		// the dispositions are cleared if none, otherwise ffmpeg sets the 1st stream of each type as default
+		disposition := "0"
+		if len(stream.Disposition) > 0 {
+			disposition = strings.Join(stream.Disposition, "+")
+		}
+		args = append(args, "-disposition:"+index, disposition)
+	}
+	if len(layout.chaptersPath) > 0 {
+		args = append(args, "-map_chapters", strconv.Itoa(chaptersInput))
+	}
+	return args
+}
+
+// ffmetadataEscaper escape the special characters of values in ffmetadata
+var ffmetadataEscaper = strings.NewReplacer("\\", "\\\\", "=", "\\=", ";", "\\;", "#", "\\#", "\n", "\\\n")
+
+// ffmetadataChapters the chapters in the ffmetadata format of ffmpeg in ns
+func ffmetadataChapters(chapters []Chapter) []byte {
+	var b strings.Builder
+	b.WriteString(";FFMETADATA1\n")
+	for _, chapter := range chapters {
+		fmt.Fprintf(&b, "[CHAPTER]\nTIMEBASE=1/1000000000\nSTART=%d\nEND=%d\n", chapter.Start, chapter.End)
+		if len(chapter.Title) > 0 {
+			b.WriteString("title=" + ffmetadataEscaper.Replace(chapter.Title) + "\n")
+		}
+	}
+	return []byte(b.String())
+}
+
+// RotationMode how rotated videos are made, see WithRotationMode
+type RotationMode int
+
+// rotation modes
+const (
+	// RotationMetadata make the video in the coded dimension with the same rotation in metadata
+	RotationMetadata RotationMode = iota
+	// RotationPreRotated make the video in the display dimension without rotation
+	RotationPreRotated
+)
+
+// rotationArgs ffmpeg input & output args to rotate the video clockwise by rotation for display,
+// the display_rotation input option since ffmpeg 6.0 or the rotate metadata before
+func (b FFmpegBackend) rotationArgs(ctx context.Context, s *Shrinker, rotation int) ([]string, []string) {
+	major := 0
+	version := strings.TrimPrefix(b.version(ctx, s), "n")
+	if i := strings.IndexFunc(version, func(r rune) bool { return r < '0' || r > '9' }); i > 0 {
+		major, _ = strconv.Atoi(version[:i])
+	} else if i < 0 {
+		major, _ = strconv.Atoi(version)
+	}
+	if major > 0 && major < 6 {
+		return nil, []string{"-metadata:s:v:0", "rotate=" + strconv.Itoa(rotation)}
+	}
+	// unknown versions are git builds, which are recent, display_rotation is counter-clockwise
+	return []string{"-display_rotation:v:0", strconv.Itoa(-rotation)}, nil
 }
 
-// getBestVideoSampleRate, use 128k sample rate for best duration approaching
-// mkv, wmv, asf can only get 48k
+// getBestVideoSampleRate, use 128k sample rate for best duration approaching when the sample rate is unknown
+// mkv, webm, wmv, asf can only get 48k
 func getBestVideoSampleRate(outputPath string) string {
 	ext := strings.ToLower(filepath.Ext(outputPath))
-	if ext == ".wmv" || ext == ".mkv" || ext == ".asf" {
+	if ext == ".wmv" || ext == ".mkv" || ext == ".webm" || ext == ".asf" {
 		return "48000"
 	}
 	return "128000"
@@ -0,0 +1,84 @@
+package mediashrink
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRotationArgs(t *testing.T) {
+	tests := []struct {
+		version               string
+		rotation              int
+		inputArgs, outputArgs []string
+	}{
+		{"4.4.2-0ubuntu0.22.04.1", 90, nil, []string{"-metadata:s:v:0", "rotate=90"}},
+		{"n5.1.4", 270, nil, []string{"-metadata:s:v:0", "rotate=270"}},
+		{"5", 180, nil, []string{"-metadata:s:v:0", "rotate=180"}},
+		{"6.0", 90, []string{"-display_rotation:v:0", "-90"}, nil},
+		{"n7.1-static", 270, []string{"-display_rotation:v:0", "-270"}, nil},
+		// git builds & unknown versions are taken as recent
+		{"N-112345-g1234567", 90, []string{"-display_rotation:v:0", "-90"}, nil},
+		{"", 180, []string{"-display_rotation:v:0", "-180"}, nil},
+	}
+	ctx, s := context.Background(), NewShrinker()
+	for _, test := range tests {
+		t.Run(test.version, func(t *testing.T) {
+			// the version is cached by the ffmpeg command, which is never run
+			b := FFmpegBackend{FFMpeg: "ffmpeg-rotation-" + test.version}
+			calibrations.Lock()
+			calibrations.versions[b.ffmpeg()] = test.version
+			calibrations.Unlock()
+			inputArgs, outputArgs := b.rotationArgs(ctx, s, test.rotation)
+			if !reflect.DeepEqual(inputArgs, test.inputArgs) || !reflect.DeepEqual(outputArgs, test.outputArgs) {
+				t.Errorf("got %q & %q, want %q & %q", inputArgs, outputArgs, test.inputArgs, test.outputArgs)
+			}
+		})
+	}
+}
+
+func TestVideoCodecArgs(t *testing.T) {
+	// the encoders are cached by the ffmpeg command, which is never run
+	b := FFmpegBackend{FFMpeg: "ffmpeg-video-codec"}
+	ffmpegEncoders.Lock()
+	ffmpegEncoders.codecs[b.ffmpeg()] = map[string]bool{"h264": true, "vp9": true}
+	ffmpegEncoders.Unlock()
+	tests := []struct {
+		name          string
+		codec         string
+		profile       string
+		pixFmt        string
+		args          []string
+		noEncoderLogs bool
+	}{
+		{name: "unknown", args: nil},
+		{name: "h264", codec: "h264", profile: "High 10", pixFmt: "yuv420p10le",
+			args: []string{"-c:v", "h264", "-profile:v", "high10", "-pix_fmt", "yuv420p10le"}},
+		{name: "h264 constrained baseline", codec: "h264", profile: "Constrained Baseline",
+			args: []string{"-c:v", "h264", "-profile:v", "baseline"}},
+		{name: "h264 profile without encoder profile", codec: "h264", profile: "High 10 Intra", pixFmt: "yuv420p10le",
+			args: []string{"-c:v", "h264", "-pix_fmt", "yuv420p10le"}},
+		// the profiles of the other codecs are decided by the pixel format
+		{name: "vp9", codec: "vp9", profile: "Profile 2", pixFmt: "yuv420p10le",
+			args: []string{"-c:v", "vp9", "-pix_fmt", "yuv420p10le"}},
+		{name: "no encoder", codec: "hevc", profile: "Main 10", pixFmt: "yuv420p10le",
+			args: []string{"-pix_fmt", "yuv420p10le"}, noEncoderLogs: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			s := NewShrinker(WithLogger(log.New(&logs, "", 0)))
+			vInfo := &MediaInfoV2{Width: 64, Height: 48, Signature: "abcdef", Ext: "mp4",
+				VideoCodec: test.codec, VideoProfile: test.profile, PixelFormat: test.pixFmt}
+			if args := b.videoCodecArgs(context.Background(), s, vInfo); !reflect.DeepEqual(args, test.args) {
+				t.Errorf("got %q, want %q", args, test.args)
+			}
+			if noEncoder := strings.Contains(logs.String(), "has no encoder of "+test.codec); noEncoder != test.noEncoderLogs {
+				t.Errorf("got logs %q", logs.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+//go:build unix
+
+package mediashrink
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCanEncode(t *testing.T) {
+	lookPathOrSkip(t, "sh")
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	codecs := strings.Join([]string{
+		"Codecs:",
+		" D..... = Decoding supported",
+		" -------",
+		" DEV.LS h264                 H.264 / AVC / MPEG-4 AVC / MPEG-4 part 10 (encoders: libx264 libx264rgb )",
+		" DEV.L. hevc                 H.265 / HEVC (decoders: hevc ) (encoders: libx265 )",
+		" D.V.L. av1                  Alliance for Open Media AV1 (decoders: libdav1d av1 )",
+		" DEA.L. aac                  AAC (Advanced Audio Coding)",
+	}, "\n")
+	b := FFmpegBackend{FFMpeg: writeScript(t, dir, "ffmpeg", "echo \"$@\" >> '"+calls+"'\ncat <<'EOF'\n"+codecs+"\nEOF\n")}
+	ctx, s := context.Background(), NewShrinker()
+	for codec, want := range map[string]bool{"h264": true, "hevc": true, "aac": true, "av1": false, "vp9": false} {
+		if got := b.canEncode(ctx, s, codec); got != want {
+			t.Errorf("got %t of %s, want %t", got, codec, want)
+		}
+	}
+	// the encoders are listed once for each ffmpeg
+	if output, err := os.ReadFile(calls); err != nil || string(output) != "-hide_banner -codecs\n" {
+		t.Errorf("got calls %q with error %v, want a single -codecs", output, err)
+	}
+	// every codec is taken as encodable if ffmpeg can not list them
+	missing := FFmpegBackend{FFMpeg: filepath.Join(dir, "missing")}
+	if !missing.canEncode(ctx, s, "av1") {
+		t.Errorf("got av1 not encodable by a missing ffmpeg, want it encodable")
+	}
+}